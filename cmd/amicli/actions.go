@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// actionParams is the parameter names of the common actions, used by tab completion
+var actionParams = map[string][]string{
+	"AbsoluteTimeout":                {"Channel", "Timeout"},
+	"Atxfer":                         {"Channel", "Exten", "Context"},
+	"BlindTransfer":                  {"Channel", "Exten", "Context"},
+	"Bridge":                         {"Channel1", "Channel2", "Tone"},
+	"BridgeInfo":                     {"BridgeUniqueid"},
+	"BridgeList":                     {"BridgeType"},
+	"Command":                        {"Command"},
+	"CoreSettings":                   nil,
+	"CoreShowChannels":               nil,
+	"CoreStatus":                     nil,
+	"DBDel":                          {"Family", "Key"},
+	"DBDelTree":                      {"Family", "Key"},
+	"DBGet":                          {"Family", "Key"},
+	"DBPut":                          {"Family", "Key", "Val"},
+	"Events":                         {"EventMask"},
+	"ExtensionState":                 {"Exten", "Context"},
+	"Filter":                         {"Operation", "Filter"},
+	"Getvar":                         {"Channel", "Variable"},
+	"Hangup":                         {"Channel", "Cause"},
+	"ListCommands":                   nil,
+	"Logoff":                         nil,
+	"MixMonitor":                     {"Channel", "File", "Options", "Command"},
+	"ModuleCheck":                    {"Module"},
+	"ModuleLoad":                     {"Module", "LoadType"},
+	"Originate":                      {"Channel", "Exten", "Context", "Priority", "Application", "Data", "Timeout", "CallerID", "Variable", "Account", "EarlyMedia", "Async", "Codecs", "ChannelId", "OtherChannelId"},
+	"PJSIPQualify":                   {"Endpoint"},
+	"PJSIPShowContacts":              nil,
+	"PJSIPShowEndpoint":              {"Endpoint"},
+	"PJSIPShowEndpoints":             nil,
+	"PJSIPShowRegistrationsOutbound": nil,
+	"Ping":                           nil,
+	"PlayDTMF":                       {"Channel", "Digit", "Duration", "Receive"},
+	"QueueAdd":                       {"Queue", "Interface", "Penalty", "Paused", "MemberName", "StateInterface"},
+	"QueuePause":                     {"Queue", "Interface", "Paused", "Reason"},
+	"QueueRemove":                    {"Queue", "Interface"},
+	"QueueStatus":                    {"Queue", "Member"},
+	"QueueSummary":                   {"Queue"},
+	"Redirect":                       {"Channel", "ExtraChannel", "Exten", "ExtraExten", "Context", "ExtraContext", "Priority", "ExtraPriority"},
+	"Reload":                         {"Module"},
+	"SendText":                       {"Channel", "Message"},
+	"Setvar":                         {"Channel", "Variable", "Value"},
+	"ShowDialPlan":                   {"Extension", "Context"},
+	"SIPpeers":                       nil,
+	"SIPshowpeer":                    {"Peer"},
+	"SIPshowregistry":                nil,
+	"Status":                         {"Channel", "Variables", "AllVariables"},
+	"StopMixMonitor":                 {"Channel", "MixMonitorID"},
+	"UserEvent":                      {"UserEvent"},
+}
+
+// shellCommands is the commands of the shell itself
+var shellCommands = []string{"help", "tail", "json", "quit", "exit"}
+
+// actionNames returns sorted names of the known actions and shell commands
+func actionNames(extra []string) (res []string) {
+	known := make(map[string]bool)
+	for name := range actionParams {
+		known[strings.ToLower(name)] = true
+		res = append(res, name)
+	}
+	for _, name := range extra {
+		if !known[strings.ToLower(name)] {
+			known[strings.ToLower(name)] = true
+			res = append(res, name)
+		}
+	}
+	res = append(res, shellCommands...)
+	sort.Strings(res)
+	return
+}
+
+// paramNames returns parameter names of the action. Search is case insensitive
+func paramNames(action string) []string {
+	for name, params := range actionParams {
+		if strings.EqualFold(name, action) {
+			return params
+		}
+	}
+	return nil
+}
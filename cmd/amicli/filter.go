@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+// eventFilter matches events by names and header values.
+// Header value can be a pattern of ami.CompilePattern, for example Channel=PJSIP/100-*
+type eventFilter struct {
+	names   map[string]bool
+	headers map[string]string
+}
+
+// parseEventFilter parse comma separated event names and Key=Value header filters
+func parseEventFilter(names string, headers []string) (res *eventFilter, err error) {
+	res = &eventFilter{
+		names:   make(map[string]bool),
+		headers: make(map[string]string),
+	}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			res.names[strings.ToLower(name)] = true
+		}
+	}
+	for _, header := range headers {
+		pos := strings.Index(header, "=")
+		if pos <= 0 {
+			return nil, fmt.Errorf("filter %q: expected Key=Value", header)
+		}
+		pattern := header[pos+1:]
+		if _, err = ami.CompilePattern(pattern); err != nil {
+			return nil, fmt.Errorf("filter %q: %v", header, err)
+		}
+		res.headers[header[:pos]] = pattern
+	}
+	return
+}
+
+func (s *eventFilter) match(e ami.Event) bool {
+	if len(s.names) > 0 && !s.names[strings.ToLower(e.Name())] {
+		return false
+	}
+	for key, pattern := range s.headers {
+		val, check := e.ActionData[key]
+		if !check {
+			return false
+		}
+		if !ami.MatchPattern(pattern, val) {
+			return false
+		}
+	}
+	return true
+}
+
+// multiFlag is the repeatable command line flag
+type multiFlag []string
+
+func (s *multiFlag) String() string { return strings.Join(*s, ", ") }
+
+func (s *multiFlag) Set(val string) error {
+	*s = append(*s, val)
+	return nil
+}
@@ -0,0 +1,168 @@
+// Command amicli is the command-line client of the Asterisk manager interface.
+//
+// Connection settings can be defined by flags or by ini file:
+//
+//	host = 127.0.0.1:5038
+//	login = admin
+//	password = secret
+//
+// Usage:
+//
+//	amicli -config ami.ini                          interactive shell
+//	amicli -host 127.0.0.1:5038 -login admin -password secret -e "CoreShowChannels"
+//	amicli -config ami.ini -tail -events Newchannel,Hangup -filter Channel=PJSIP/* -json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+	"github.com/fcg-xvii/go-tools/text/config"
+	_ "github.com/fcg-xvii/go-tools/text/config/ini"
+)
+
+type options struct {
+	host     string
+	login    string
+	password string
+	timeout  time.Duration
+	json     bool
+	tail     bool
+	events   string
+	filters  multiFlag
+	execute  string
+}
+
+// loadConfig setup connection options from ini file section. Flags defined in the command line are not replaced
+func loadConfig(opts *options, fileName, sectionName string, defined map[string]bool) error {
+	conf, err := config.FromFile("ini", fileName)
+	if err != nil {
+		return err
+	}
+	section, check := conf.Section(sectionName)
+	if !check {
+		return fmt.Errorf("section [%v] is not found in %v", sectionName, fileName)
+	}
+	for name, ptr := range map[string]*string{"host": &opts.host, "login": &opts.login, "password": &opts.password} {
+		if !defined[name] {
+			section.ValueSetup(name, ptr)
+		}
+	}
+	return nil
+}
+
+// connect starts the client and waits authorization
+func connect(opts *options) (*ami.Client, error) {
+	states := make(chan error, 16)
+	cl := ami.New(opts.host, opts.login, opts.password, nil, func(state ami.State, err error) {
+		switch state {
+		case ami.StateAuth:
+			states <- nil
+		case ami.StateStopped:
+			if err == nil {
+				err = errors.New("connection closed")
+			}
+			select {
+			case states <- err:
+			default:
+			}
+		}
+	})
+	go cl.Start()
+	select {
+	case err := <-states:
+		if err != nil {
+			cl.Close()
+			return nil, err
+		}
+	case <-time.After(opts.timeout):
+		cl.Close()
+		return nil, errors.New("connection timeout")
+	}
+	// report connection lost, the client stop after shutdown is expected
+	go func() {
+		err := <-states
+		select {
+		case <-cl.ShutdownDone():
+		default:
+			fmt.Fprintf(os.Stderr, "\r\nconnection lost: %v\r\n", err)
+		}
+	}()
+	return cl, nil
+}
+
+func run(opts *options) error {
+	cl, err := connect(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		cl.Shutdown(ctx)
+		cancel()
+	}()
+
+	p := newPrinter(os.Stdout, opts.json)
+	sh := newShell(cl, p, opts.timeout)
+
+	switch {
+	case opts.execute != "":
+		if !sh.execute(opts.execute) {
+			return nil
+		}
+	case opts.tail:
+		filter, err := parseEventFilter(opts.events, opts.filters)
+		if err != nil {
+			return err
+		}
+		stop, interrupt := make(chan struct{}), make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			close(stop)
+		}()
+		sh.tail(filter, stop)
+	default:
+		sh.loadActions()
+		p.Message("connected to %v, asterisk %v. Type help for commands list", opts.host, cl.Version())
+		return sh.run(newLineReader(os.Stdin, os.Stdout, sh.complete))
+	}
+	return nil
+}
+
+func main() {
+	opts := new(options)
+	var configFile, section string
+	flag.StringVar(&configFile, "config", "", "ini file with host, login and password values")
+	flag.StringVar(&section, "section", "main", "section of the ini file")
+	flag.StringVar(&opts.host, "host", "127.0.0.1:5038", "ami server address")
+	flag.StringVar(&opts.login, "login", "", "ami user name")
+	flag.StringVar(&opts.password, "password", "", "ami user password")
+	flag.DurationVar(&opts.timeout, "timeout", time.Second*10, "connection and request timeout")
+	flag.BoolVar(&opts.json, "json", false, "print responses and events as json lines")
+	flag.BoolVar(&opts.tail, "tail", false, "print events until interrupt")
+	flag.StringVar(&opts.events, "events", "", "comma separated event names for -tail")
+	flag.Var(&opts.filters, "filter", "Key=Value header filter for -tail, value can be a pattern. Can be repeated")
+	flag.StringVar(&opts.execute, "e", "", "execute single command and exit")
+	flag.Parse()
+
+	if configFile != "" {
+		defined := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { defined[f.Name] = true })
+		if err := loadConfig(opts, configFile, section, defined); err != nil {
+			fmt.Fprintln(os.Stderr, "config error:", err)
+			os.Exit(2)
+		}
+	}
+
+	if err := run(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fcg-xvii/go-tools/json"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+// headFields are printed before other fields
+var headFields = []string{"Event", "Response", "ActionID", "Message"}
+
+func newPrinter(w io.Writer, jsonLines bool) *printer {
+	return &printer{
+		w:         w,
+		jsonLines: jsonLines,
+		locker:    new(sync.Mutex),
+	}
+}
+
+// printer writes responses and events as the aligned text blocks or as json lines
+type printer struct {
+	w         io.Writer
+	jsonLines bool
+	locker    *sync.Mutex
+}
+
+func (s *printer) SetJSON(jsonLines bool) {
+	s.locker.Lock()
+	s.jsonLines = jsonLines
+	s.locker.Unlock()
+}
+
+func (s *printer) IsJSON() (res bool) {
+	s.locker.Lock()
+	res = s.jsonLines
+	s.locker.Unlock()
+	return
+}
+
+// sortedKeys returns head fields first, other keys in alphabetical order
+func sortedKeys(data ami.ActionData) (res []string) {
+	var other []string
+	for _, key := range headFields {
+		if _, check := data[key]; check {
+			res = append(res, key)
+		}
+	}
+	for key := range data {
+		head := false
+		for _, h := range headFields {
+			if key == h {
+				head = true
+				break
+			}
+		}
+		if !head {
+			other = append(other, key)
+		}
+	}
+	sort.Strings(other)
+	return append(res, other...)
+}
+
+func (s *printer) text(data ami.ActionData) string {
+	keys, width := sortedKeys(data), 0
+	for _, key := range keys {
+		if len(key) > width {
+			width = len(key)
+		}
+	}
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%-*v : %v\n", width, key, data[key])
+	}
+	return b.String()
+}
+
+func (s *printer) write(data ami.ActionData) {
+	s.locker.Lock()
+	if s.jsonLines {
+		src, _ := json.Marshal(data)
+		fmt.Fprintf(s.w, "%s\n", src)
+	} else {
+		fmt.Fprintf(s.w, "%v\n", s.text(data))
+	}
+	s.locker.Unlock()
+}
+
+// Response prints response and event list items
+func (s *printer) Response(resp ami.Response, events []ami.Event) {
+	s.write(resp.ActionData)
+	for _, e := range events {
+		s.write(e.ActionData)
+	}
+	if len(events) > 0 && !s.IsJSON() {
+		s.Message("%v events", len(events))
+	}
+}
+
+// Event prints single event
+func (s *printer) Event(e ami.Event) {
+	s.write(e.ActionData)
+}
+
+// Message prints text message. In json mode messages are not printed
+func (s *printer) Message(format string, args ...interface{}) {
+	s.locker.Lock()
+	if !s.jsonLines {
+		fmt.Fprintf(s.w, format+"\n", args...)
+	}
+	s.locker.Unlock()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+const shellHelp = `Commands:
+  <Action> [Key=Value ...]           send action, for example: Getvar Channel="PJSIP/100-00000001" Variable=CALLERID(num)
+                                     Variable=name=value parameters are joined to the Originate variables
+  tail [Name1,Name2] [Key=Value ...] print events until Enter is pressed, header values can be patterns: Channel=PJSIP/*
+  json on|off                        switch json lines output
+  help                               this help
+  quit, exit                         close connection and exit
+Press Tab to complete action and parameter names`
+
+func newShell(cl *ami.Client, p *printer, timeout time.Duration) *shell {
+	return &shell{
+		client:  cl,
+		printer: p,
+		timeout: timeout,
+		actions: actionNames(nil),
+		locker:  new(sync.Mutex),
+	}
+}
+
+// shell is the interactive ami console
+type shell struct {
+	client     *ami.Client
+	printer    *printer
+	timeout    time.Duration
+	actions    []string
+	in         lineReader
+	locker     *sync.Mutex
+	events     bool
+	subscriber chan ami.Event
+}
+
+// loadActions appends actions permitted for the user to the completion list
+func (s *shell) loadActions() {
+	resp, accepted := s.client.Request(ami.InitRequest("ListCommands"), s.timeout)
+	if !accepted || resp.IsError() {
+		return
+	}
+	var names []string
+	for key := range resp.ActionData {
+		switch key {
+		case "Response", "ActionID", "Message":
+		default:
+			names = append(names, key)
+		}
+	}
+	s.actions = actionNames(names)
+}
+
+// tokenize splits command line to the tokens. Double quotes group the token with spaces,
+// the quotes are removed
+func tokenize(line string) (res []string, err error) {
+	var (
+		token   strings.Builder
+		quoted  bool
+		started bool
+	)
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted, started = !quoted, true
+		case (r == ' ' || r == '\t') && !quoted:
+			if started {
+				res = append(res, token.String())
+				token.Reset()
+				started = false
+			}
+		default:
+			token.WriteRune(r)
+			started = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unclosed quote")
+	}
+	if started {
+		res = append(res, token.String())
+	}
+	return
+}
+
+// parseAction makes request from the command line tokens
+func parseAction(tokens []string) (req ami.Request, err error) {
+	req = ami.InitRequest(tokens[0])
+	for _, token := range tokens[1:] {
+		pos := strings.Index(token, "=")
+		if pos <= 0 {
+			return req, fmt.Errorf("parameter %q: expected Key=Value", token)
+		}
+		key, val := token[:pos], token[pos+1:]
+		if strings.EqualFold(key, "Variable") {
+			if vPos := strings.Index(val, "="); vPos > 0 {
+				req.SetVariable(val[:vPos], val[vPos+1:])
+				continue
+			}
+		}
+		req.SetParam(key, val)
+	}
+	return
+}
+
+// complete returns completed line and candidates list if completion is ambiguous
+func (s *shell) complete(line string) (string, []string) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return line, nil
+	}
+	if len(tokens) == 0 || strings.HasSuffix(line, " ") {
+		tokens = append(tokens, "")
+	}
+	last := tokens[len(tokens)-1]
+	base := line[:len(line)-len(last)]
+
+	var candidates []string
+	if len(tokens) == 1 {
+		candidates = s.actions
+	} else {
+		used := make(map[string]bool)
+		for _, token := range tokens[1 : len(tokens)-1] {
+			if pos := strings.Index(token, "="); pos > 0 {
+				used[strings.ToLower(token[:pos])] = true
+			}
+		}
+		for _, param := range paramNames(tokens[0]) {
+			if !used[strings.ToLower(param)] || strings.EqualFold(param, "Variable") {
+				candidates = append(candidates, param+"=")
+			}
+		}
+	}
+
+	var matched []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(last)) {
+			matched = append(matched, candidate)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return line, nil
+	case 1:
+		if len(tokens) == 1 {
+			return base + matched[0] + " ", nil
+		}
+		return base + matched[0], nil
+	}
+	sort.Strings(matched)
+	prefix := matched[0]
+	for _, candidate := range matched[1:] {
+		for !strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(prefix)) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) > len(last) {
+		return base + prefix, matched
+	}
+	return line, matched
+}
+
+// subscribe starts the client events reading. Events are sended to the current subscriber
+func (s *shell) subscribe() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.events {
+		return
+	}
+	s.events = true
+	events := s.client.Event()
+	go func() {
+		for e := range events {
+			s.locker.Lock()
+			subscriber := s.subscriber
+			s.locker.Unlock()
+			if subscriber != nil {
+				subscriber <- e
+			}
+		}
+	}()
+}
+
+// tail prints events matched with filter until stop channel is closed
+func (s *shell) tail(filter *eventFilter, stop <-chan struct{}) {
+	s.subscribe()
+	events := make(chan ami.Event, 64)
+	s.locker.Lock()
+	s.subscriber = events
+	s.locker.Unlock()
+	defer func() {
+		s.locker.Lock()
+		s.subscriber = nil
+		s.locker.Unlock()
+		// release the blocked sender
+		for {
+			select {
+			case <-events:
+			default:
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case e := <-events:
+			if filter.match(e) {
+				s.printer.Event(e)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// execute runs single command line. Returns false on quit command
+func (s *shell) execute(line string) bool {
+	tokens, err := tokenize(line)
+	if err != nil {
+		s.printer.Message("error: %v", err)
+		return true
+	}
+	if len(tokens) == 0 {
+		return true
+	}
+	switch strings.ToLower(tokens[0]) {
+	case "quit", "exit":
+		return false
+	case "help":
+		s.printer.Message(shellHelp)
+	case "json":
+		s.printer.SetJSON(len(tokens) > 1 && strings.EqualFold(tokens[1], "on"))
+	case "tail":
+		var names string
+		headers := tokens[1:]
+		if len(headers) > 0 && !strings.Contains(headers[0], "=") {
+			names, headers = headers[0], headers[1:]
+		}
+		filter, err := parseEventFilter(names, headers)
+		if err != nil {
+			s.printer.Message("error: %v", err)
+			return true
+		}
+		stop := make(chan struct{})
+		go func() {
+			s.in.ReadLine("")
+			close(stop)
+		}()
+		s.printer.Message("tail events, press Enter to stop...")
+		s.tail(filter, stop)
+	default:
+		req, err := parseAction(tokens)
+		if err != nil {
+			s.printer.Message("error: %v", err)
+			return true
+		}
+		resp, events, accepted := s.client.RequestList(req, s.timeout)
+		if !accepted {
+			s.printer.Message("error: request timeout")
+			return true
+		}
+		s.printer.Response(resp, events)
+	}
+	return true
+}
+
+// run reads and executes commands until quit or end of input
+func (s *shell) run(in lineReader) error {
+	s.in = in
+	for {
+		line, err := in.ReadLine("ami> ")
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !s.execute(line) {
+			return nil
+		}
+	}
+}
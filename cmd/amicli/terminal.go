@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// completeFunc returns completed line and candidates list
+type completeFunc func(line string) (string, []string)
+
+// lineReader reads command lines
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+func newPlainReader(r io.Reader, w io.Writer) *plainReader {
+	return &plainReader{
+		scanner: bufio.NewScanner(r),
+		w:       w,
+	}
+}
+
+// plainReader reads lines without editing and completion. Used if input is not a terminal
+type plainReader struct {
+	scanner *bufio.Scanner
+	w       io.Writer
+}
+
+func (s *plainReader) ReadLine(prompt string) (string, error) {
+	if len(prompt) > 0 {
+		fmt.Fprint(s.w, prompt)
+	}
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.scanner.Text(), nil
+}
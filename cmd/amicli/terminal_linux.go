@@ -0,0 +1,143 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+	"unsafe"
+)
+
+func getTermios(fd uintptr) (t syscall.Termios, err error) {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		err = errno
+	}
+	return
+}
+
+func setTermios(fd uintptr, t *syscall.Termios) (err error) {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(t))); errno != 0 {
+		err = errno
+	}
+	return
+}
+
+// newLineReader returns line editor with history and tab completion if the input is a terminal
+func newLineReader(in *os.File, out io.Writer, complete completeFunc) lineReader {
+	state, err := getTermios(in.Fd())
+	if err != nil {
+		return newPlainReader(in, out)
+	}
+	return &termReader{
+		in:       in,
+		out:      out,
+		complete: complete,
+		state:    state,
+	}
+}
+
+// termReader is a minimal line editor, the terminal is switched to raw mode only while line reading
+type termReader struct {
+	in       *os.File
+	out      io.Writer
+	complete completeFunc
+	state    syscall.Termios
+	history  []string
+}
+
+func (s *termReader) rawMode() error {
+	raw := s.state
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+	return setTermios(s.in.Fd(), &raw)
+}
+
+func (s *termReader) redraw(prompt, line string) {
+	fmt.Fprintf(s.out, "\r\033[K%v%v", prompt, line)
+}
+
+func (s *termReader) ReadLine(prompt string) (line string, err error) {
+	if err = s.rawMode(); err != nil {
+		return
+	}
+	defer setTermios(s.in.Fd(), &s.state)
+
+	fmt.Fprint(s.out, prompt)
+	historyPos := len(s.history)
+	buf := make([]byte, 1)
+	for {
+		if _, err = s.in.Read(buf); err != nil {
+			return
+		}
+		switch c := buf[0]; c {
+		case '\r', '\n':
+			fmt.Fprint(s.out, "\r\n")
+			if strings.TrimSpace(line) != "" {
+				s.history = append(s.history, line)
+			}
+			return
+		case 3: // Ctrl-C
+			fmt.Fprint(s.out, "^C\r\n", prompt)
+			line = ""
+		case 4: // Ctrl-D
+			if line == "" {
+				fmt.Fprint(s.out, "\r\n")
+				return "", io.EOF
+			}
+		case 21: // Ctrl-U
+			line = ""
+			s.redraw(prompt, line)
+		case 127, 8: // Backspace
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRuneInString(line)
+				line = line[:len(line)-size]
+				s.redraw(prompt, line)
+			}
+		case '\t':
+			if s.complete == nil {
+				continue
+			}
+			completed, candidates := s.complete(line)
+			if len(candidates) > 0 {
+				fmt.Fprintf(s.out, "\r\n%v\r\n", strings.Join(candidates, "  "))
+			}
+			line = completed
+			s.redraw(prompt, line)
+		case 27: // escape sequence
+			seq := make([]byte, 2)
+			if _, err = io.ReadFull(s.in, seq); err != nil {
+				return
+			}
+			if seq[0] != '[' {
+				continue
+			}
+			switch seq[1] {
+			case 'A': // up
+				if historyPos > 0 {
+					historyPos--
+					line = s.history[historyPos]
+					s.redraw(prompt, line)
+				}
+			case 'B': // down
+				if historyPos < len(s.history)-1 {
+					historyPos++
+					line = s.history[historyPos]
+				} else {
+					historyPos, line = len(s.history), ""
+				}
+				s.redraw(prompt, line)
+			}
+		default:
+			if c >= 32 {
+				line += string(buf)
+				s.out.Write(buf)
+			}
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// newLineReader returns plain line reader, line editing is supported only on linux
+func newLineReader(in *os.File, out io.Writer, complete completeFunc) lineReader {
+	return newPlainReader(in, out)
+}
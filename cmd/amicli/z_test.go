@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize(`Originate Channel="Local/100@from internal" Variable=a=1  Exten=100`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 4 || tokens[1] != "Channel=Local/100@from internal" {
+		t.Errorf("unexpected tokens %q", tokens)
+	}
+	if _, err = tokenize(`Command Command="core show`); err == nil {
+		t.Error("expected unclosed quote error")
+	}
+
+	req, err := parseAction(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ActionData["Action"] != "Originate" || req.ActionData["Exten"] != "100" || req.Variables["a"] != "1" {
+		t.Errorf("unexpected request %v %v", req.ActionData, req.Variables)
+	}
+	if _, err = parseAction([]string{"Hangup", "Channel"}); err == nil {
+		t.Error("expected parameter error")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	sh := &shell{actions: actionNames([]string{"PJSIPShowAors"})}
+	items := []struct {
+		line, completed string
+	}{
+		{"orig", "Originate "},
+		{"Originate Cha", "Originate Channel"},
+		{"Originate Prio", "Originate Priority="},
+		{"Originate Channel=PJSIP/100 Ex", "Originate Channel=PJSIP/100 Exten="},
+		{"PJSIPShowA", "PJSIPShowAors "},
+		{"PJSIPShowE", "PJSIPShowEndpoint"},
+	}
+	for _, item := range items {
+		if completed, _ := sh.complete(item.line); completed != item.completed {
+			t.Errorf("%q: expected %q, given %q", item.line, item.completed, completed)
+		}
+	}
+	if _, candidates := sh.complete("Hangup "); len(candidates) != 2 {
+		t.Errorf("unexpected candidates %v", candidates)
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	filter, err := parseEventFilter("Newchannel, Hangup", []string{"Channel=PJSIP/100-*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := ami.Event{ActionData: ami.ActionData{"Event": "Hangup", "Channel": "PJSIP/100-00000001"}}
+	if !filter.match(e) {
+		t.Error("expected event match")
+	}
+	e.ActionData["Channel"] = "PJSIP/101-00000001"
+	if filter.match(e) {
+		t.Error("unexpected event match")
+	}
+	// "*" matches the "/" of the channel name
+	if filter, _ = parseEventFilter("", []string{"Channel=PJSIP*"}); !filter.match(e) {
+		t.Error("expected event match")
+	}
+	if _, err = parseEventFilter("", []string{"Channel"}); err == nil {
+		t.Error("expected filter error")
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	p := newPrinter(&buf, false)
+	resp := ami.Response{ActionData: ami.ActionData{"Response": "Success", "ActionID": "1", "Ping": "Pong"}}
+	p.Response(resp, nil)
+	if !strings.HasPrefix(buf.String(), "Response : Success\nActionID : 1\nPing     : Pong\n") {
+		t.Errorf("unexpected text output %q", buf.String())
+	}
+	buf.Reset()
+	p.SetJSON(true)
+	p.Response(resp, []ami.Event{{ActionData: ami.ActionData{"Event": "Test"}}})
+	if buf.String() != "{\"ActionID\":\"1\",\"Ping\":\"Pong\",\"Response\":\"Success\"}\n{\"Event\":\"Test\"}\n" {
+		t.Errorf("unexpected json output %q", buf.String())
+	}
+}
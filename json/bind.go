@@ -0,0 +1,247 @@
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/fcg-xvii/go-tools/value"
+)
+
+// BindError contains errors of the fields failed by Bind. Key of the field error is the path
+// of the value (see ParsePath)
+type BindError struct {
+	Fields []*KeyError
+}
+
+func (s *BindError) Error() string {
+	list := make([]string, len(s.Fields))
+	for i, field := range s.Fields {
+		list[i] = field.Error()
+	}
+	return "json bind: " + strings.Join(list, "; ")
+}
+
+// Bind fills target (pointer to the struct, map or slice) by the map values. Struct fields are
+// matched by the encoding/json tag rules, unknown keys are ignored. Values are converted leniently
+// by value.Value.Setup, numeric strings are parsed to numbers, JSON strings are decoded to structs,
+// slices, maps and bool.
+// Fields that can't be converted (including out of range numbers and fractional numbers
+// of the integer fields) are skipped and returned in *BindError
+func (s Map) Bind(target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("json bind: expected not nil pointer, given %T", target)
+	}
+	var errs []*KeyError
+	bind("", map[string]interface{}(s), rv.Elem(), &errs)
+	if len(errs) > 0 {
+		return &BindError{Fields: errs}
+	}
+	return nil
+}
+
+func bindKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func bind(path string, src interface{}, rv reflect.Value, errs *[]*KeyError) {
+	if src == nil {
+		return
+	}
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		bind(path, src, rv.Elem(), errs)
+		return
+	}
+	fail := func() {
+		*errs = append(*errs, typeError(path, rv.Type().String(), src))
+	}
+	t := rv.Type()
+	if unmarshaler(t) {
+		// time.Time and the other types decoded by themselves
+		src, err := json.Marshal(src)
+		if err == nil {
+			err = json.Unmarshal(src, rv.Addr().Interface())
+		}
+		if err != nil {
+			*errs = append(*errs, &KeyError{Key: path, Err: err})
+		}
+		return
+	}
+	m, isMap := childMap(src)
+	sl, isSlice := src.([]interface{})
+	switch rv.Kind() {
+	case reflect.Interface:
+		if sv := reflect.ValueOf(src); sv.Type().AssignableTo(t) {
+			rv.Set(sv)
+		} else {
+			fail()
+		}
+		return
+	case reflect.Struct:
+		if isMap {
+			fields := cachedFields(t)
+			for _, key := range sortedKeys(m) {
+				if field, check := fields.find(key); check {
+					if fv, check := fieldByIndex(rv, field.index); check {
+						bind(bindKey(path, key), m[key], fv, errs)
+					}
+				}
+			}
+			return
+		}
+	case reflect.Map:
+		if isMap && t.Key().Kind() == reflect.String {
+			if rv.IsNil() {
+				rv.Set(reflect.MakeMap(t))
+			}
+			for _, key := range sortedKeys(m) {
+				elem := reflect.New(t.Elem()).Elem()
+				bind(bindKey(path, key), m[key], elem, errs)
+				rv.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+			}
+			return
+		}
+	case reflect.Slice:
+		if isSlice {
+			res := reflect.MakeSlice(t, len(sl), len(sl))
+			for i, val := range sl {
+				bind(path+"["+strconv.Itoa(i)+"]", val, res.Index(i), errs)
+			}
+			rv.Set(res)
+			return
+		}
+	case reflect.Array:
+		if isSlice {
+			for i := 0; i < rv.Len() && i < len(sl); i++ {
+				bind(path+"["+strconv.Itoa(i)+"]", sl[i], rv.Index(i), errs)
+			}
+			return
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if !bindNumber(src, rv) {
+			fail()
+		}
+		return
+	case reflect.String:
+		if str, check := src.(string); check {
+			rv.SetString(str)
+			return
+		}
+	}
+	if isMap || isSlice {
+		fail()
+		return
+	}
+	if str, check := src.(string); check {
+		// JSON source of the struct, slice, map or bool
+		res := reflect.New(t)
+		if err := json.Unmarshal([]byte(str), res.Interface()); err != nil {
+			fail()
+			return
+		}
+		rv.Set(res.Elem())
+		return
+	}
+	if val := value.ValueOf(src); !val.Setup(rv.Addr().Interface()) {
+		fail()
+	}
+}
+
+// bindNumber sets the number field by the number or the numeric string. Out of range numbers
+// and fractional numbers of the integer fields are not converted
+func bindNumber(src interface{}, rv reflect.Value) bool {
+	sv := reflect.ValueOf(src)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var val int64
+		switch sv.Kind() {
+		case reflect.String:
+			// string or json.Number
+			var err error
+			if val, err = strconv.ParseInt(sv.String(), 10, 64); err != nil {
+				return false
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			val = sv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if sv.Uint() > math.MaxInt64 {
+				return false
+			}
+			val = int64(sv.Uint())
+		case reflect.Float32, reflect.Float64:
+			f := sv.Float()
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return false
+			}
+			val = int64(f)
+		default:
+			return false
+		}
+		if rv.OverflowInt(val) {
+			return false
+		}
+		rv.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var val uint64
+		switch sv.Kind() {
+		case reflect.String:
+			var err error
+			if val, err = strconv.ParseUint(sv.String(), 10, 64); err != nil {
+				return false
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if sv.Int() < 0 {
+				return false
+			}
+			val = uint64(sv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			val = sv.Uint()
+		case reflect.Float32, reflect.Float64:
+			f := sv.Float()
+			if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+				return false
+			}
+			val = uint64(f)
+		default:
+			return false
+		}
+		if rv.OverflowUint(val) {
+			return false
+		}
+		rv.SetUint(val)
+	default:
+		var val float64
+		switch sv.Kind() {
+		case reflect.String:
+			var err error
+			if val, err = strconv.ParseFloat(sv.String(), 64); err != nil {
+				return false
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			val = float64(sv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			val = float64(sv.Uint())
+		case reflect.Float32, reflect.Float64:
+			val = sv.Float()
+		default:
+			return false
+		}
+		if rv.OverflowFloat(val) {
+			return false
+		}
+		rv.SetFloat(val)
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package json
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Canonical returns RFC 8785 (JCS) canonical JSON of the value: object keys are sorted by UTF-16 code units,
+// numbers are serialized as ECMAScript double values, strings are escaped minimally.
+// Values other than the decoded JSON types (Map, []interface{}, string, float64, json.Number, bool, nil)
+// are encoded by the JSONEncoder first
+func Canonical(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := canonical(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Canonical returns RFC 8785 canonical JSON of the map
+func (s Map) Canonical() ([]byte, error) { return Canonical(s) }
+
+// Hash returns digest of the canonical JSON of the value, like Hash(v, sha256.New)
+func Hash(v interface{}, newHash func() hash.Hash) ([]byte, error) {
+	src, err := Canonical(v)
+	if err != nil {
+		return nil, err
+	}
+	h := newHash()
+	h.Write(src)
+	return h.Sum(nil), nil
+}
+
+// HMAC returns HMAC of the canonical JSON of the value
+func HMAC(v interface{}, newHash func() hash.Hash, key []byte) ([]byte, error) {
+	src, err := Canonical(v)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(newHash, key)
+	h.Write(src)
+	return h.Sum(nil), nil
+}
+
+// VerifyHMAC compares mac with HMAC of the canonical JSON of the value in constant time
+func VerifyHMAC(v interface{}, newHash func() hash.Hash, key, mac []byte) (bool, error) {
+	expected, err := HMAC(v, newHash, key)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(expected, mac), nil
+}
+
+// Hash returns digest of the canonical JSON of the map
+func (s Map) Hash(newHash func() hash.Hash) ([]byte, error) { return Hash(s, newHash) }
+
+// HMAC returns HMAC of the canonical JSON of the map
+func (s Map) HMAC(newHash func() hash.Hash, key []byte) ([]byte, error) { return HMAC(s, newHash, key) }
+
+func canonical(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(val))
+	case string:
+		return canonicalString(buf, val)
+	case float64:
+		return canonicalNumber(buf, val)
+	case json.Number:
+		f, err := strconv.ParseFloat(string(val), 64)
+		if err != nil {
+			return fmt.Errorf("json canonical: invalid number %q", val)
+		}
+		return canonicalNumber(buf, f)
+	case Map:
+		return canonicalObject(buf, val)
+	case map[string]interface{}:
+		return canonicalObject(buf, val)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := canonical(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		// other types are normalized to the decoded JSON values
+		src, err := EncodeBytes(v)
+		if err != nil {
+			return err
+		}
+		var decoded interface{}
+		dec := json.NewDecoder(bytes.NewReader(src))
+		dec.UseNumber()
+		if err = dec.Decode(&decoded); err != nil {
+			return err
+		}
+		return canonical(buf, decoded)
+	}
+	return nil
+}
+
+func canonicalObject(buf *bytes.Buffer, m map[string]interface{}) error {
+	keys := make([]string, 0, len(m))
+	units := make(map[string][]uint16, len(m))
+	for key := range m {
+		keys = append(keys, key)
+		units[key] = utf16.Encode([]rune(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		l, r := units[keys[i]], units[keys[j]]
+		for k := 0; k < len(l) && k < len(r); k++ {
+			if l[k] != r[k] {
+				return l[k] < r[k]
+			}
+		}
+		return len(l) < len(r)
+	})
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := canonicalString(buf, key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := canonical(buf, m[key]); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// canonicalNumber writes number in the ECMAScript format, encoding/json uses the same format for float64
+func canonicalNumber(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("json canonical: unsupported number %v", f)
+	}
+	if f == 0 {
+		// negative zero
+		buf.WriteByte('0')
+		return nil
+	}
+	src, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	buf.Write(src)
+	return nil
+}
+
+// canonicalString escapes quote, backslash and control characters only
+func canonicalString(buf *bytes.Buffer, s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("json canonical: invalid UTF-8 string %q", s)
+	}
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return nil
+}
@@ -5,9 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/fcg-xvii/go-tools/containers"
 )
@@ -93,9 +93,13 @@ func InitJSONDecoderFromSource(src []byte) *JSONDecoder {
 }
 
 func InitJSONDecoder(r io.Reader) *JSONDecoder {
+	reader := &lineReader{r: r, lastLine: -1}
+	dec := json.NewDecoder(reader)
+	reader.offset = dec.InputOffset
 	return &JSONDecoder{
-		Decoder:  json.NewDecoder(r),
+		Decoder:  dec,
 		embedded: containers.NewStack(0),
+		reader:   reader,
 	}
 }
 
@@ -109,11 +113,9 @@ type JSONDecoder struct {
 	parentObj    reflect.Value
 	err          error
 	counter      int
-}
-
-func (s *JSONDecoder) buf() {
-	b, _ := ioutil.ReadAll(s.Buffered())
-	log.Println(">>>", string(b))
+	reader       *lineReader
+	path         []pathElem
+	tokenOffset  int64
 }
 
 func (s *JSONDecoder) IsObjectKey() bool      { return s.objectkey }
@@ -123,6 +125,7 @@ func (s *JSONDecoder) EmbeddedLevel() int     { return s.embedded.Len() }
 
 func (s *JSONDecoder) Token() (t json.Token, err error) {
 	s.objectClosed = false
+	s.tokenOffset = s.valueOffset()
 	if t, err = s.Decoder.Token(); err == nil {
 		if delim, check := t.(json.Delim); check {
 			s.objectkey = false
@@ -130,11 +133,16 @@ func (s *JSONDecoder) Token() (t json.Token, err error) {
 			case '{':
 				s.embedded.Push(JSON_OBJECT)
 				s.current = JSON_OBJECT
+				s.path = append(s.path, pathElem{})
 			case '[':
 				s.embedded.Push(JSON_ARRAY)
 				s.current = JSON_ARRAY
+				s.path = append(s.path, pathElem{array: true, index: -1})
 			case '}', ']':
 				s.embedded.Pop()
+				if len(s.path) > 0 {
+					s.path = s.path[:len(s.path)-1]
+				}
 				s.objectClosed, s.current = true, JSON_INVALID
 				if s.embedded.Len() > 0 {
 					s.current = s.embedded.Peek().(JSONTokenType)
@@ -172,12 +180,13 @@ func (s *JSONDecoder) Next() error {
 }
 
 func (s *JSONDecoder) DecodeRaw(v interface{}) error {
-	return s.Decoder.Decode(v)
+	return s.decodeValue(v)
 }
 
+// Decode decodes value, errors are returned as *DecodeError with location of the failed value
 func (s *JSONDecoder) Decode(v interface{}) (err error) {
 	rv := reflect.ValueOf(v)
-	err = s.decodeReflect(&rv)
+	err = s.wrapError(s.decodeReflect(&rv))
 	return
 }
 
@@ -214,22 +223,33 @@ func (s *JSONDecoder) decodeReflect(rv *reflect.Value) (err error) {
 				} else {
 					if ev.Kind() == reflect.Ptr {
 						return s.decodeReflect(&ev)
-					} else if ev.Kind() == reflect.Slice {
+					} else if unmarshaler(ev.Type()) {
+						// types with custom unmarshal like time.Time
+						return s.decodeValue(rv.Interface())
+					} else if ev.Kind() == reflect.Slice && ev.Type().Elem().Kind() != reflect.Uint8 {
+						// byte slice is decoded from base64 string
 						return s.decodeSlice(&ev)
 					} else if ev.Kind() == reflect.Struct {
 						return s.decodeRawObject(rv)
+					} else if ev.Kind() == reflect.Map {
+						return s.decodeMap(&ev)
+					} else if d := discriminator(ev.Type()); d != nil {
+						return s.decodeDiscriminated(d, ev)
 					}
 				}
 			}
-			return s.Decoder.Decode(rv.Interface())
+			return s.decodeValue(rv.Interface())
 		}
 	}
 }
 
-func fieldConvert(v reflect.Value, t reflect.Type, fieldName string) (val reflect.Value, err error) {
+// fieldConvert converts value of the current field, error has location of the last token
+func (s *JSONDecoder) fieldConvert(v reflect.Value, t reflect.Type) (val reflect.Value, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("%v :: %v", fieldName, r.(string))
+			res := s.newError(s.tokenOffset, fmt.Errorf("%v", r))
+			res.Expected, res.Actual = t.String(), v.Type().String()
+			err = res
 		}
 	}()
 	val = v.Convert(t)
@@ -251,7 +271,7 @@ func (s *JSONDecoder) decodeRawObject(rv *reflect.Value) (err error) {
 			return
 		}
 		// token is not object
-		return fmt.Errorf("EXPCTED OBJECT, NOT %T", t)
+		return s.typeError("object", t)
 	}
 	if rv.Kind() == reflect.Ptr {
 		if rv.IsNil() {
@@ -260,30 +280,135 @@ func (s *JSONDecoder) decodeRawObject(rv *reflect.Value) (err error) {
 		elem := rv.Elem()
 		rv = &elem
 	}
+	fields := cachedFields(rv.Type())
 	el := s.EmbeddedLevel()
 	for el <= s.EmbeddedLevel() {
 		if t, err = s.Token(); err != nil {
 			return
 		}
 		if s.Current() == JSON_VALUE && s.IsObjectKey() {
-			s.buf()
-			if f := rv.FieldByName(t.(string)); f.IsValid() {
-				rrv := reflect.New(f.Type())
-				if err = s.decodeReflect(&rrv); err != nil {
+			s.setKey(t.(string))
+			field, check := fields.find(t.(string))
+			var f reflect.Value
+			if check {
+				f, check = fieldByIndex(*rv, field.index)
+			}
+			if !check {
+				if err = s.Next(); err != nil {
 					return
 				}
-				if !rrv.IsNil() {
-					f.Set(rrv.Elem())
+				continue
+			}
+			if field.quoted {
+				if err = s.decodeQuoted(f, field.name); err != nil {
+					return
 				}
-			} else {
-				var i interface{}
-				s.Decoder.Decode(&i)
+				continue
+			}
+			rrv := reflect.New(f.Type())
+			if f.Kind() == reflect.Map || f.Kind() == reflect.Ptr {
+				// existing map is updated like encoding/json
+				rrv.Elem().Set(f)
+			}
+			if err = s.decodeReflect(&rrv); err != nil {
+				return
+			}
+			if !rrv.IsNil() {
+				f.Set(rrv.Elem())
 			}
 		}
 	}
 	return
 }
 
+// decodeQuoted decodes value of the field with ",string" tag option
+func (s *JSONDecoder) decodeQuoted(f reflect.Value, name string) (err error) {
+	var t json.Token
+	if t, err = s.Token(); err != nil || t == nil {
+		return
+	}
+	str, check := t.(string)
+	if !check {
+		return s.typeError("string", t)
+	}
+	if err = json.Unmarshal([]byte(str), f.Addr().Interface()); err != nil {
+		return s.newError(s.tokenOffset, err)
+	}
+	return
+}
+
+// map
+func (s *JSONDecoder) decodeMap(rv *reflect.Value) (err error) {
+	keyType := rv.Type().Key()
+	switch keyType.Kind() {
+	case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return s.decodeValue(rv.Addr().Interface())
+	}
+	if reflect.PtrTo(keyType).Implements(textUnmarshalerType) {
+		return s.decodeValue(rv.Addr().Interface())
+	}
+	var t json.Token
+	if t, err = s.Token(); err != nil {
+		return
+	}
+	if s.current != JSON_OBJECT {
+		if t == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return
+		}
+		return s.typeError("object", t)
+	}
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(rv.Type()))
+	}
+	elemType := rv.Type().Elem()
+	for s.More() {
+		if t, err = s.Token(); err != nil {
+			return
+		}
+		s.setKey(t.(string))
+		var key reflect.Value
+		if key, err = mapKey(t.(string), keyType); err != nil {
+			return s.newError(s.tokenOffset, err)
+		}
+		em := reflect.New(elemType)
+		if err = s.decodeReflect(&em); err != nil {
+			return
+		}
+		rv.SetMapIndex(key, em.Elem())
+	}
+	if _, err = s.Token(); err != nil {
+		return
+	}
+	if d, check := s.token.(json.Delim); !check || d != '}' {
+		return s.typeError("'}'", s.token)
+	}
+	return
+}
+
+func mapKey(src string, t reflect.Type) (res reflect.Value, err error) {
+	res = reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		res.SetString(src)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var val int64
+		if val, err = strconv.ParseInt(src, 10, 64); err != nil || res.OverflowInt(val) {
+			return res, fmt.Errorf("INVALID MAP KEY %q FOR %v", src, t)
+		}
+		res.SetInt(val)
+	default:
+		var val uint64
+		if val, err = strconv.ParseUint(src, 10, 64); err != nil || res.OverflowUint(val) {
+			return res, fmt.Errorf("INVALID MAP KEY %q FOR %v", src, t)
+		}
+		res.SetUint(val)
+	}
+	return
+}
+
 // slice
 func (s *JSONDecoder) decodeSlice(rv *reflect.Value) (err error) {
 	var t json.Token
@@ -297,14 +422,15 @@ func (s *JSONDecoder) decodeSlice(rv *reflect.Value) (err error) {
 			}
 			return
 		}
-		return fmt.Errorf("EXPCTED SLICE, NOT %T", t)
+		return s.typeError("array", t)
 	}
 	// check slice is nil
 	if rv.IsNil() {
 		rv.Set(reflect.MakeSlice(rv.Type(), 0, 0))
 	}
 	elemType := reflect.TypeOf(rv.Interface()).Elem()
-	for s.More() {
+	for i := 0; s.More(); i++ {
+		s.setIndex(i)
 		em := reflect.New(elemType)
 		if err = s.decodeReflect(&em); err != nil {
 			return
@@ -315,7 +441,7 @@ func (s *JSONDecoder) decodeSlice(rv *reflect.Value) (err error) {
 		return
 	}
 	if d, check := s.token.(json.Delim); !check || d != ']' {
-		return fmt.Errorf("JSON PARSE ERROR :: EXPECTED ']', NOT %v", d)
+		return s.typeError("']'", s.token)
 	}
 	return
 }
@@ -337,7 +463,7 @@ func (s *JSONDecoder) decodeJSONObject(rv *reflect.Value) (err error) {
 			return
 		}
 		// token is not object
-		return fmt.Errorf("EXPCTED OBJECT, NOT %T", t)
+		return s.typeError("object", t)
 	}
 	// check null pounter in source object
 	if rv.Kind() == reflect.Ptr && rv.IsNil() {
@@ -351,6 +477,7 @@ func (s *JSONDecoder) decodeJSONObject(rv *reflect.Value) (err error) {
 			return
 		}
 		if s.Current() == JSON_VALUE && s.IsObjectKey() {
+			s.setKey(t.(string))
 			if fieldPtr, err = obj.JSONField(t.(string), store); err != nil {
 				return
 			}
@@ -371,3 +498,99 @@ func (s *JSONDecoder) decodeJSONObject(rv *reflect.Value) (err error) {
 	}
 	return
 }
+
+////////////////////////////////////////
+
+// seekArray moves decoder to the first element of the array found by path. Path elements are keys
+// of the objects or indexes of the arrays, empty path means the top level array
+func (s *JSONDecoder) seekArray(path []string) (err error) {
+	var t json.Token
+	if t, err = s.Token(); err != nil {
+		return
+	}
+	for i, key := range path {
+		found := false
+		switch t {
+		case json.Delim('{'):
+			for s.More() {
+				if t, err = s.Token(); err != nil {
+					return
+				}
+				s.setKey(t.(string))
+				if t.(string) == key {
+					found = true
+					break
+				}
+				if err = s.Next(); err != nil {
+					return
+				}
+			}
+		case json.Delim('['):
+			index, convErr := strconv.Atoi(key)
+			if convErr != nil {
+				return s.newError(s.tokenOffset, fmt.Errorf("JSON PATH %v :: EXPECTED ARRAY INDEX, NOT %q", strings.Join(path[:i+1], "."), key))
+			}
+			skipped := 0
+			for ; skipped < index && s.More(); skipped++ {
+				s.setIndex(skipped)
+				if err = s.Next(); err != nil {
+					return
+				}
+			}
+			s.setIndex(index)
+			found = skipped == index && s.More()
+		default:
+			return s.typeError("object or array", t)
+		}
+		if !found {
+			return s.newError(s.valueOffset(), fmt.Errorf("JSON PATH %v NOT FOUND", strings.Join(path[:i+1], ".")))
+		}
+		if t, err = s.Token(); err != nil {
+			return
+		}
+	}
+	if t != json.Delim('[') {
+		return s.typeError("array", t)
+	}
+	return
+}
+
+// ArrayEach walks the array found by path and calls fn for each element. The fn must read exactly one
+// value by the decoder (Decode, DecodeRaw or Next). Iteration is stopped if fn returns false or error,
+// the rest of the array is not read in this case
+func (s *JSONDecoder) ArrayEach(path []string, fn func(index int, dec *JSONDecoder) (next bool, err error)) (err error) {
+	if err = s.seekArray(path); err != nil {
+		return
+	}
+	level := s.EmbeddedLevel()
+	var next bool
+	for index := 0; s.More(); index++ {
+		s.setIndex(index)
+		if next, err = fn(index, s); err != nil || !next {
+			return
+		}
+		if s.EmbeddedLevel() != level {
+			return s.newError(s.valueOffset(), fmt.Errorf("JSON ARRAY ELEMENT %v IS NOT READ COMPLETELY", index))
+		}
+	}
+	_, err = s.Token()
+	return
+}
+
+// ArrayDecode decodes elements of the array found by path one by one into the elem pointer and calls fn
+// after each element. The elem value is reset before each element
+func (s *JSONDecoder) ArrayDecode(path []string, elem interface{}, fn func(index int) (next bool, err error)) error {
+	rv := reflect.ValueOf(elem)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("EXPECTED NOT NIL POINTER, NOT %T", elem)
+	}
+	ev := rv.Elem()
+	zero := reflect.Zero(ev.Type())
+	return s.ArrayEach(path, func(index int, dec *JSONDecoder) (bool, error) {
+		ev.Set(zero)
+		if err := dec.Decode(elem); err != nil {
+			return false, err
+		}
+		return fn(index)
+	})
+}
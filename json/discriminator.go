@@ -0,0 +1,159 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Discriminator maps values of the type field of the JSON object to the concrete types of the interface.
+// JSONDecoder decodes fields, slice elements and map values of the registered interface type
+// to the concrete type by the discriminator field, JSONEncoder adds the field to the encoded object
+type Discriminator struct {
+	field string
+	iface reflect.Type
+	mutex sync.RWMutex
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}
+
+var discriminators sync.Map
+
+// RegisterInterface registers the interface type by the pointer to the interface, like (*Message)(nil).
+// Existing discriminator of the interface is returned if it is registered already
+func RegisterInterface(ifacePtr interface{}, field string) *Discriminator {
+	t := reflect.TypeOf(ifacePtr)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+		panic(fmt.Errorf("json discriminator: expected pointer to interface, given %T", ifacePtr))
+	}
+	res, _ := discriminators.LoadOrStore(t.Elem(), &Discriminator{
+		field: field,
+		iface: t.Elem(),
+		types: make(map[string]reflect.Type),
+		names: make(map[reflect.Type]string),
+	})
+	return res.(*Discriminator)
+}
+
+// discriminator returns discriminator of the registered interface type
+func discriminator(t reflect.Type) *Discriminator {
+	if t.Kind() != reflect.Interface {
+		return nil
+	}
+	if res, check := discriminators.Load(t); check {
+		return res.(*Discriminator)
+	}
+	return nil
+}
+
+// Field returns name of the discriminator field
+func (s *Discriminator) Field() string { return s.field }
+
+// Register maps the discriminator value to the type of v. The type (struct or pointer to struct)
+// must implement the interface
+func (s *Discriminator) Register(name string, v interface{}) *Discriminator {
+	t := reflect.TypeOf(v)
+	if t == nil || !t.Implements(s.iface) {
+		panic(fmt.Errorf("json discriminator: %T is not implements %v", v, s.iface))
+	}
+	s.mutex.Lock()
+	s.types[name], s.names[t] = t, name
+	s.mutex.Unlock()
+	return s
+}
+
+func (s *Discriminator) typeByName(name string) (t reflect.Type, check bool) {
+	s.mutex.RLock()
+	t, check = s.types[name]
+	s.mutex.RUnlock()
+	return
+}
+
+func (s *Discriminator) nameByType(t reflect.Type) (name string, check bool) {
+	s.mutex.RLock()
+	name, check = s.names[t]
+	s.mutex.RUnlock()
+	return
+}
+
+// decodeDiscriminated decodes object to the registered type of the discriminator field value
+func (s *JSONDecoder) decodeDiscriminated(d *Discriminator, rv reflect.Value) (err error) {
+	start := s.valueOffset()
+	var raw json.RawMessage
+	if err = s.decodeValue(&raw); err != nil {
+		return
+	}
+	if bytes.Equal(raw, []byte("null")) {
+		rv.Set(reflect.Zero(rv.Type()))
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return s.newError(start, err)
+	}
+	var name string
+	if src, check := fields[d.field]; !check {
+		return s.newError(start, fmt.Errorf("discriminator field %q of %v not found", d.field, d.iface))
+	} else if err = json.Unmarshal(src, &name); err != nil {
+		return s.newError(start, fmt.Errorf("discriminator field %q of %v: %v", d.field, d.iface, err))
+	}
+	t, check := d.typeByName(name)
+	if !check {
+		return s.newError(start, fmt.Errorf("unknown %q value %q of %v", d.field, name, d.iface))
+	}
+	var val reflect.Value
+	if t.Kind() == reflect.Ptr {
+		val = reflect.New(t.Elem())
+	} else {
+		val = reflect.New(t)
+	}
+	if err = InitJSONDecoderFromSource(raw).Decode(val.Interface()); err != nil {
+		// location of the nested decoder is relative to the object
+		var decErr *DecodeError
+		if !errors.As(err, &decErr) {
+			return s.newError(start, err)
+		}
+		res := s.newError(start+decErr.Offset, decErr.Err)
+		res.Path += decErr.Path
+		res.Expected, res.Actual = decErr.Expected, decErr.Actual
+		return res
+	}
+	if t.Kind() != reflect.Ptr {
+		val = val.Elem()
+	}
+	rv.Set(val)
+	return
+}
+
+// encodeDiscriminated writes value of the registered interface with the discriminator field
+func (s *JSONEncoder) encodeDiscriminated(d *Discriminator, rv reflect.Value) error {
+	name, check := d.nameByType(rv.Type())
+	if !check {
+		return s.fail(fmt.Errorf("JSONEncoder: type %v is not registered for %v", rv.Type(), d.iface))
+	}
+	src, err := EncodeBytes(rv.Interface())
+	if err != nil {
+		return s.fail(err)
+	}
+	if len(src) < 2 || src[0] != '{' {
+		return s.fail(fmt.Errorf("JSONEncoder: %v is not encoded as object", rv.Type()))
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(src, &fields); err != nil {
+		return s.fail(err)
+	}
+	if _, check = fields[d.field]; check {
+		// the field is encoded by the type itself
+		return s.EncodeRaw(src)
+	}
+	field, _ := json.Marshal(d.field)
+	value, _ := json.Marshal(name)
+	res := append(append(append([]byte("{"), field...), ':'), value...)
+	if len(fields) > 0 {
+		res = append(res, ',')
+	}
+	return s.EncodeRaw(append(res, src[1:]...))
+}
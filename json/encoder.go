@@ -0,0 +1,386 @@
+package json
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+func Encode(w io.Writer, v interface{}) error {
+	enc := InitJSONEncoder(w)
+	return enc.Encode(v)
+}
+
+func EncodeBytes(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// JSON encode interfaces
+
+// JSONEncodeInterface writes value by the encoder methods, the pair of JSONInterface
+type JSONEncodeInterface interface {
+	JSONEncode(*JSONEncoder) error
+}
+
+// JSONFieldEncoder writes field of the object
+type JSONFieldEncoder func(fieldName string, value interface{}) error
+
+// JSONObjectEncoder emits object fields one by one, the pair of JSONObject
+type JSONObjectEncoder interface {
+	JSONFields(field JSONFieldEncoder) error
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+///////////////////////////////////////////////
+
+type encoderLevel struct {
+	object bool
+	count  int
+	// key is written, the value of the object field is expected
+	key bool
+}
+
+func InitJSONEncoder(w io.Writer) *JSONEncoder {
+	return &JSONEncoder{w: w}
+}
+
+// JSONEncoder writes JSON to the writer. Values are written by Encode, objects and arrays can be written
+// incrementally by the BeginObject/Field/EndObject and BeginArray/Encode/EndArray calls.
+// The top level values are separated by the newline
+type JSONEncoder struct {
+	w      io.Writer
+	prefix string
+	indent string
+	levels []*encoderLevel
+	err    error
+}
+
+// SetIndent sets indentation like the json.MarshalIndent
+func (s *JSONEncoder) SetIndent(prefix, indent string) {
+	s.prefix, s.indent = prefix, indent
+}
+
+func (s *JSONEncoder) EmbeddedLevel() int { return len(s.levels) }
+
+func (s *JSONEncoder) write(src []byte) error {
+	if s.err == nil {
+		_, s.err = s.w.Write(src)
+	}
+	return s.err
+}
+
+func (s *JSONEncoder) fail(err error) error {
+	if s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+func (s *JSONEncoder) newline(level int) {
+	if s.prefix == "" && s.indent == "" {
+		return
+	}
+	s.write([]byte("\n" + s.prefix + strings.Repeat(s.indent, level)))
+}
+
+func (s *JSONEncoder) top() *encoderLevel {
+	if len(s.levels) == 0 {
+		return nil
+	}
+	return s.levels[len(s.levels)-1]
+}
+
+// beforeValue writes separator of the array element or checks the object key is written
+func (s *JSONEncoder) beforeValue() error {
+	if s.err != nil {
+		return s.err
+	}
+	level := s.top()
+	switch {
+	case level == nil:
+	case level.object:
+		if !level.key {
+			return s.fail(errors.New("JSONEncoder: object key expected"))
+		}
+		level.key = false
+	default:
+		if level.count > 0 {
+			s.write([]byte(","))
+		}
+		level.count++
+		s.newline(len(s.levels))
+	}
+	return s.err
+}
+
+// afterValue finishes the top level value
+func (s *JSONEncoder) afterValue() error {
+	if len(s.levels) == 0 {
+		s.write([]byte("\n"))
+	}
+	return s.err
+}
+
+// Key writes key of the current object field
+func (s *JSONEncoder) Key(name string) error {
+	if s.err != nil {
+		return s.err
+	}
+	level := s.top()
+	if level == nil || !level.object || level.key {
+		return s.fail(errors.New("JSONEncoder: unexpected object key " + name))
+	}
+	if level.count > 0 {
+		s.write([]byte(","))
+	}
+	level.count, level.key = level.count+1, true
+	s.newline(len(s.levels))
+	key, _ := json.Marshal(name)
+	s.write(key)
+	if s.indent != "" || s.prefix != "" {
+		return s.write([]byte(": "))
+	}
+	return s.write([]byte(":"))
+}
+
+// Field writes key and value of the current object field
+func (s *JSONEncoder) Field(name string, v interface{}) error {
+	if err := s.Key(name); err != nil {
+		return err
+	}
+	return s.Encode(v)
+}
+
+func (s *JSONEncoder) begin(object bool) error {
+	if err := s.beforeValue(); err != nil {
+		return err
+	}
+	if object {
+		s.write([]byte("{"))
+	} else {
+		s.write([]byte("["))
+	}
+	s.levels = append(s.levels, &encoderLevel{object: object})
+	return s.err
+}
+
+func (s *JSONEncoder) end(object bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	level := s.top()
+	if level == nil || level.object != object || level.key {
+		return s.fail(errors.New("JSONEncoder: unexpected end of the object or array"))
+	}
+	s.levels = s.levels[:len(s.levels)-1]
+	if level.count > 0 {
+		s.newline(len(s.levels))
+	}
+	if object {
+		s.write([]byte("}"))
+	} else {
+		s.write([]byte("]"))
+	}
+	return s.afterValue()
+}
+
+func (s *JSONEncoder) BeginObject() error { return s.begin(true) }
+func (s *JSONEncoder) EndObject() error   { return s.end(true) }
+func (s *JSONEncoder) BeginArray() error  { return s.begin(false) }
+func (s *JSONEncoder) EndArray() error    { return s.end(false) }
+
+// EncodeRaw writes encoded JSON value
+func (s *JSONEncoder) EncodeRaw(src []byte) error {
+	if err := s.beforeValue(); err != nil {
+		return err
+	}
+	if (s.indent != "" || s.prefix != "") && len(src) > 0 && (src[0] == '{' || src[0] == '[') {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, src, s.prefix+strings.Repeat(s.indent, len(s.levels)), s.indent); err != nil {
+			return s.fail(err)
+		}
+		src = buf.Bytes()
+	}
+	s.write(src)
+	return s.afterValue()
+}
+
+func (s *JSONEncoder) marshal(v interface{}) error {
+	src, err := json.Marshal(v)
+	if err != nil {
+		return s.fail(err)
+	}
+	return s.EncodeRaw(src)
+}
+
+// Encode writes value. Inside object Key must be called before
+func (s *JSONEncoder) Encode(v interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.encodeReflect(reflect.ValueOf(v))
+}
+
+// custom returns value implemented encode interfaces, pointer receivers are checked for the addressable values
+func custom(rv reflect.Value) (iface interface{}, check bool) {
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return
+	}
+	if rv.CanInterface() {
+		switch iface = rv.Interface(); iface.(type) {
+		case JSONEncodeInterface, JSONObjectEncoder:
+			return iface, true
+		}
+	}
+	if rv.CanAddr() && rv.Kind() != reflect.Ptr {
+		return custom(rv.Addr())
+	}
+	return nil, false
+}
+
+func (s *JSONEncoder) encodeReflect(rv reflect.Value) error {
+	if !rv.IsValid() {
+		return s.EncodeRaw([]byte("null"))
+	}
+	if rv.Kind() == reflect.Interface && !rv.IsNil() {
+		if d := discriminator(rv.Type()); d != nil {
+			return s.encodeDiscriminated(d, rv.Elem())
+		}
+	}
+	if iface, check := custom(rv); check {
+		switch enc := iface.(type) {
+		case JSONEncodeInterface:
+			if err := enc.JSONEncode(s); err != nil {
+				return s.fail(err)
+			}
+			return s.err
+		case JSONObjectEncoder:
+			if err := s.BeginObject(); err != nil {
+				return err
+			}
+			if err := enc.JSONFields(s.Field); err != nil {
+				return s.fail(err)
+			}
+			return s.EndObject()
+		}
+	}
+	t := rv.Type()
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) ||
+		(rv.CanAddr() && (reflect.PtrTo(t).Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType))) {
+		if rv.CanAddr() {
+			return s.marshal(rv.Addr().Interface())
+		}
+		return s.marshal(rv.Interface())
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return s.EncodeRaw([]byte("null"))
+		}
+		return s.encodeReflect(rv.Elem())
+	case reflect.Struct:
+		if err := s.BeginObject(); err != nil {
+			return err
+		}
+		if err := s.encodeFields(rv); err != nil {
+			return err
+		}
+		return s.EndObject()
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return s.marshal(rv.Interface())
+		}
+		if rv.IsNil() {
+			return s.EncodeRaw([]byte("null"))
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		if err := s.BeginObject(); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := s.Key(key.String()); err != nil {
+				return err
+			}
+			if err := s.encodeReflect(rv.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		return s.EndObject()
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && (rv.IsNil() || t.Elem().Kind() == reflect.Uint8) {
+			// nil slice is null, byte slice is base64 string
+			return s.marshal(rv.Interface())
+		}
+		if err := s.BeginArray(); err != nil {
+			return err
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := s.encodeReflect(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return s.EndArray()
+	default:
+		if !rv.CanInterface() {
+			return s.fail(errors.New("JSONEncoder: unexported value"))
+		}
+		return s.marshal(rv.Interface())
+	}
+}
+
+// encodeFields writes exported fields of the struct by the encoding/json tags rules.
+// Embedded structs without tag name are flattened, hidden and conflicting fields are skipped
+func (s *JSONEncoder) encodeFields(rv reflect.Value) error {
+	for _, field := range cachedFields(rv.Type()).list {
+		fv, check := fieldValue(rv, field.index)
+		if !check || (field.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		if err := s.Key(field.name); err != nil {
+			return err
+		}
+		if field.quoted {
+			src, _ := json.Marshal(fv.Interface())
+			if err := s.marshal(string(src)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.encodeReflect(fv); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
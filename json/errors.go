@@ -0,0 +1,232 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// DecodeError is the error of the JSONDecoder with location of the failed value
+type DecodeError struct {
+	// Offset is the byte offset of the value in the source
+	Offset int64
+	// Line and Column (in bytes) of the value, starting from 1
+	Line   int
+	Column int
+	// Path is the JSON Pointer of the value
+	Path string
+	// Expected and Actual kinds of the value for the type errors
+	Expected string
+	Actual   string
+	Err      error
+}
+
+func (s *DecodeError) Error() string {
+	path := s.Path
+	if path == "" {
+		path = "/"
+	}
+	msg := ""
+	if s.Expected != "" {
+		msg = fmt.Sprintf("expected %v, not %v", s.Expected, s.Actual)
+	} else if s.Err != nil {
+		msg = s.Err.Error()
+	}
+	return fmt.Sprintf("json: line %v, column %v (offset %v), path %v: %v", s.Line, s.Column, s.Offset, path, msg)
+}
+
+func (s *DecodeError) Unwrap() error { return s.Err }
+
+// kind returns JSON kind of the token
+func kind(t json.Token) string {
+	switch v := t.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64, json.Number:
+		return "number"
+	case string:
+		return "string"
+	case json.Delim:
+		switch v {
+		case '{':
+			return "object"
+		case '[':
+			return "array"
+		}
+		return "'" + v.String() + "'"
+	}
+	return fmt.Sprintf("%T", t)
+}
+
+// lineReader counts lines of the source. Offsets of the new lines are stored only
+// for the data buffered by the decoder
+type lineReader struct {
+	r io.Reader
+	// offset returns current decoder offset
+	offset   func() int64
+	read     int64
+	lines    int
+	lastLine int64
+	newlines []int64
+}
+
+func (s *lineReader) Read(p []byte) (n int, err error) {
+	if s.offset != nil {
+		s.commit(s.offset())
+	}
+	n, err = s.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == '\n' {
+			s.newlines = append(s.newlines, s.read+int64(i))
+		}
+	}
+	s.read += int64(n)
+	return
+}
+
+func (s *lineReader) commit(offset int64) {
+	i := 0
+	for ; i < len(s.newlines) && s.newlines[i] < offset; i++ {
+		s.lines, s.lastLine = s.lines+1, s.newlines[i]
+	}
+	s.newlines = append(s.newlines[:0], s.newlines[i:]...)
+}
+
+func (s *lineReader) position(offset int64) (line, column int) {
+	line, last := s.lines+1, s.lastLine
+	for _, pos := range s.newlines {
+		if pos >= offset {
+			break
+		}
+		line, last = line+1, pos
+	}
+	return line, int(offset - last)
+}
+
+// pathElem is the element of the decoded value path
+type pathElem struct {
+	array bool
+	index int
+	key   string
+}
+
+// Path returns JSON Pointer of the current value
+func (s *JSONDecoder) Path() string {
+	var b strings.Builder
+	for _, elem := range s.path {
+		if elem.array {
+			if elem.index >= 0 {
+				b.WriteString("/" + strconv.Itoa(elem.index))
+			}
+		} else if elem.key != "" {
+			b.WriteString("/" + pointerEscape(elem.key))
+		}
+	}
+	return b.String()
+}
+
+func (s *JSONDecoder) setKey(key string) {
+	if len(s.path) > 0 {
+		s.path[len(s.path)-1].key = key
+	}
+}
+
+func (s *JSONDecoder) setIndex(index int) {
+	if len(s.path) > 0 {
+		s.path[len(s.path)-1].index = index
+	}
+}
+
+// bufferedReader is the reader of the buffered data of the encoding/json decoder (*bytes.Reader)
+type bufferedReader interface {
+	io.ByteReader
+	Len() int
+}
+
+// valueOffset returns offset of the next value, whitespaces and separators are skipped
+func (s *JSONDecoder) valueOffset() int64 {
+	r := s.Decoder.Buffered()
+	br, check := r.(bufferedReader)
+	if !check {
+		src, _ := ioutil.ReadAll(r)
+		return s.reader.read - int64(len(bytes.TrimLeft(src, " \t\r\n:,")))
+	}
+	// buffered data is scanned without the copy
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return s.reader.read
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n', ':', ',':
+		default:
+			return s.reader.read - int64(br.Len()) - 1
+		}
+	}
+}
+
+func (s *JSONDecoder) newError(offset int64, err error) *DecodeError {
+	res := &DecodeError{Offset: offset, Path: s.Path(), Err: err}
+	res.Line, res.Column = s.reader.position(offset)
+	return res
+}
+
+// typeError returns error of the unexpected token
+func (s *JSONDecoder) typeError(expected string, t json.Token) error {
+	res := s.newError(s.tokenOffset, nil)
+	res.Expected, res.Actual = expected, kind(t)
+	return res
+}
+
+// wrapError adds location to the error
+func (s *JSONDecoder) wrapError(err error) error {
+	switch e := err.(type) {
+	case nil, *DecodeError:
+		return err
+	case *json.SyntaxError:
+		return s.newError(s.syntaxOffset(e), err)
+	}
+	if err == io.EOF {
+		return err
+	}
+	return s.newError(s.Decoder.InputOffset(), err)
+}
+
+// syntaxOffset returns offset of the syntax error. Offset of the value errors of the stream decoder
+// is not relative to the source, so the buffered value is scanned again
+func (s *JSONDecoder) syntaxOffset(err *json.SyntaxError) int64 {
+	src, _ := ioutil.ReadAll(s.Decoder.Buffered())
+	value := bytes.TrimLeft(src, " \t\r\n:,")
+	var raw json.RawMessage
+	if rescan, check := json.NewDecoder(bytes.NewReader(value)).Decode(&raw).(*json.SyntaxError); check {
+		return s.reader.read - int64(len(value)) + rescan.Offset - 1
+	}
+	return err.Offset
+}
+
+// decodeValue decodes value by the encoding/json decoder
+func (s *JSONDecoder) decodeValue(v interface{}) error {
+	start := s.valueOffset()
+	err := s.Decoder.Decode(v)
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *json.UnmarshalTypeError:
+		// offset of the stream decoder is not relative to the source, the nested field is located by the path
+		res := s.newError(start, err)
+		res.Expected, res.Actual = e.Type.String(), e.Value
+		if e.Field != "" {
+			res.Path += "/" + strings.Replace(e.Field, ".", "/", -1)
+		}
+		return res
+	case *json.SyntaxError:
+		return s.newError(s.syntaxOffset(e), err)
+	}
+	return s.wrapError(err)
+}
@@ -0,0 +1,202 @@
+package json
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// unmarshaler returns true if type decodes itself by encoding/json
+func unmarshaler(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return pt.Implements(jsonUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+// structField is the field of the struct decoded by the tag rules of encoding/json
+type structField struct {
+	name      string
+	index     []int
+	tagged    bool
+	quoted    bool
+	omitEmpty bool
+}
+
+type structFields struct {
+	list   []structField
+	byName map[string]int
+}
+
+// find returns field by the exact name or the case-insensitive match
+func (s *structFields) find(name string) (field structField, check bool) {
+	var i int
+	if i, check = s.byName[name]; check {
+		return s.list[i], true
+	}
+	for _, field = range s.list {
+		if strings.EqualFold(field.name, name) {
+			return field, true
+		}
+	}
+	return field, false
+}
+
+var fieldsCache sync.Map
+
+func cachedFields(t reflect.Type) *structFields {
+	if res, check := fieldsCache.Load(t); check {
+		return res.(*structFields)
+	}
+	res, _ := fieldsCache.LoadOrStore(t, typeFields(t))
+	return res.(*structFields)
+}
+
+// typeFields returns fields of the struct type. Embedded structs without tag name are flattened,
+// the field of the less depth hides the others, fields with the same name at the same depth
+// are ignored if only one of them is not tagged
+func typeFields(t reflect.Type) *structFields {
+	type level struct {
+		t     reflect.Type
+		index []int
+	}
+	var found []structField
+	current, visited := []level{{t: t}}, map[reflect.Type]bool{}
+	for len(current) > 0 {
+		var next []level
+		var depthFields []structField
+		for _, l := range current {
+			if visited[l.t] {
+				continue
+			}
+			visited[l.t] = true
+			for i := 0; i < l.t.NumField(); i++ {
+				f := l.t.Field(i)
+				tag := f.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts := tag, ""
+				if pos := strings.Index(tag, ","); pos >= 0 {
+					name, opts = tag[:pos], tag[pos:]
+				}
+				index := append(append([]int(nil), l.index...), i)
+				ft := f.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					next = append(next, level{t: ft, index: index})
+					continue
+				}
+				if f.PkgPath != "" {
+					// unexported field
+					continue
+				}
+				field := structField{name: name, index: index, tagged: name != "", omitEmpty: strings.Contains(opts, ",omitempty")}
+				if name == "" {
+					field.name = f.Name
+				}
+				if strings.Contains(opts, ",string") {
+					switch ft.Kind() {
+					case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+						reflect.Float32, reflect.Float64, reflect.String:
+						field.quoted = f.Type.Kind() != reflect.Ptr
+					}
+				}
+				depthFields = append(depthFields, field)
+			}
+		}
+		// fields of the current depth hide fields of the deeper levels
+		names := make(map[string][]structField)
+		for _, field := range depthFields {
+			names[field.name] = append(names[field.name], field)
+		}
+		for _, field := range found {
+			delete(names, field.name)
+		}
+		for _, list := range names {
+			if dominant, check := dominantField(list); check {
+				found = append(found, dominant)
+			} else {
+				// conflicting fields hide the deeper fields too
+				found = append(found, structField{name: list[0].name})
+			}
+		}
+		current = next
+	}
+	res := &structFields{byName: make(map[string]int)}
+	for _, field := range found {
+		if field.index != nil {
+			res.list = append(res.list, field)
+		}
+	}
+	sort.Slice(res.list, func(i, j int) bool { return lessIndex(res.list[i].index, res.list[j].index) })
+	for i, field := range res.list {
+		res.byName[field.name] = i
+	}
+	return res
+}
+
+func dominantField(list []structField) (res structField, check bool) {
+	if len(list) == 1 {
+		return list[0], true
+	}
+	for _, field := range list {
+		if field.tagged {
+			if check {
+				return res, false
+			}
+			res, check = field, true
+		}
+	}
+	return
+}
+
+func lessIndex(l, r []int) bool {
+	for i := 0; i < len(l) && i < len(r); i++ {
+		if l[i] != r[i] {
+			return l[i] < r[i]
+		}
+	}
+	return len(l) < len(r)
+}
+
+// fieldValue returns the field of the struct for the read, check is false if the embedded pointer is nil
+func fieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndex returns the field of the struct, nil embedded pointers are allocated
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					// unexported embedded pointer
+					return v, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, v.CanSet()
+}
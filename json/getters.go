@@ -0,0 +1,217 @@
+package json
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrKeyNotFound is the error of the strict getters for the missing keys, check it by errors.Is
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyError is the error of the strict getters
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (s *KeyError) Error() string { return fmt.Sprintf("json key %q: %v", s.Key, s.Err) }
+func (s *KeyError) Unwrap() error { return s.Err }
+
+func typeError(key, expected string, val interface{}) *KeyError {
+	return &KeyError{Key: key, Err: fmt.Errorf("expected %v, not %T (%v)", expected, val, val)}
+}
+
+// get returns value of the key or ErrKeyNotFound error
+func (s Map) get(key string) (interface{}, error) {
+	val, check := s[key]
+	if !check {
+		return nil, &KeyError{Key: key, Err: ErrKeyNotFound}
+	}
+	return val, nil
+}
+
+// GetInt returns integer value. Float values (decoded from JSON) must not have the fractional part
+func (s Map) GetInt(key string) (int64, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return 0, err
+	}
+	switch v := val.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case json.Number:
+		if res, err := v.Int64(); err == nil {
+			return res, nil
+		}
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), nil
+		}
+	default:
+		if f, check := number(val); check && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), nil
+		}
+	}
+	return 0, typeError(key, "integer", val)
+}
+
+// GetFloat returns number value
+func (s Map) GetFloat(key string) (float64, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return 0, err
+	}
+	if f, check := number(val); check {
+		return f, nil
+	}
+	return 0, typeError(key, "number", val)
+}
+
+// GetBool returns bool value
+func (s Map) GetBool(key string) (bool, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return false, err
+	}
+	if b, check := val.(bool); check {
+		return b, nil
+	}
+	return false, typeError(key, "bool", val)
+}
+
+// GetString returns string value
+func (s Map) GetString(key string) (string, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return "", err
+	}
+	if str, check := val.(string); check {
+		return str, nil
+	}
+	return "", typeError(key, "string", val)
+}
+
+// GetTime returns time value. Strings are parsed by layout (time.RFC3339 if layout is empty),
+// numbers are the unix time in seconds
+func (s Map) GetTime(key, layout string) (time.Time, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	switch v := val.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		res, err := time.Parse(layout, v)
+		if err != nil {
+			return res, &KeyError{Key: key, Err: err}
+		}
+		return res, nil
+	}
+	if f, check := number(val); check {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Time{}, typeError(key, "time", val)
+}
+
+// GetDuration returns duration value. Strings are parsed by time.ParseDuration ("1m30s"),
+// numbers are the count of seconds
+func (s Map) GetDuration(key string) (time.Duration, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return 0, err
+	}
+	switch v := val.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		res, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, &KeyError{Key: key, Err: err}
+		}
+		return res, nil
+	}
+	if f, check := number(val); check {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return 0, typeError(key, "duration", val)
+}
+
+// GetMap returns object value
+func (s Map) GetMap(key string) (Map, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if m, check := childMap(val); check {
+		return Map(m), nil
+	}
+	return nil, typeError(key, "object", val)
+}
+
+// GetSlice returns array value
+func (s Map) GetSlice(key string) ([]interface{}, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if sl, check := val.([]interface{}); check {
+		return sl, nil
+	}
+	return nil, typeError(key, "array", val)
+}
+
+// GetMaps returns array of the objects
+func (s Map) GetMaps(key string) ([]Map, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	switch v := val.(type) {
+	case []Map:
+		return v, nil
+	case []interface{}:
+		res := make([]Map, len(v))
+		for i, item := range v {
+			m, check := childMap(item)
+			if !check {
+				return nil, typeError(fmt.Sprintf("%v[%v]", key, i), "object", item)
+			}
+			res[i] = Map(m)
+		}
+		return res, nil
+	}
+	return nil, typeError(key, "array of objects", val)
+}
+
+// GetStrings returns array of the strings
+func (s Map) GetStrings(key string) ([]string, error) {
+	val, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	switch v := val.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		res := make([]string, len(v))
+		for i, item := range v {
+			str, check := item.(string)
+			if !check {
+				return nil, typeError(fmt.Sprintf("%v[%v]", key, i), "string", item)
+			}
+			res[i] = str
+		}
+		return res, nil
+	}
+	return nil, typeError(key, "array of strings", val)
+}
@@ -0,0 +1,178 @@
+package json
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrLineTooLong is the error of the line exceeding the max line size of the LinesReader
+var ErrLineTooLong = errors.New("line too long")
+
+// LineError is the error of the JSON Lines value
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (s *LineError) Error() string { return fmt.Sprintf("json line %v: %v", s.Line, s.Err) }
+func (s *LineError) Unwrap() error { return s.Err }
+
+// NewLinesReader returns reader of the JSON Lines (NDJSON) source
+func NewLinesReader(r io.Reader) *LinesReader {
+	return &LinesReader{r: bufio.NewReader(r)}
+}
+
+// LinesReader decodes one value per line, blank lines are skipped
+type LinesReader struct {
+	r           *bufio.Reader
+	line        int
+	maxSize     int
+	skipInvalid bool
+	skipped     []*LineError
+	buf         []byte
+}
+
+// SetMaxLineSize limits length of the line in bytes, zero size is unlimited
+func (s *LinesReader) SetMaxLineSize(size int) { s.maxSize = size }
+
+// SkipInvalid sets malformed and too long lines are skipped. Errors of the skipped lines
+// are available by the Skipped method
+func (s *LinesReader) SkipInvalid(skip bool) { s.skipInvalid = skip }
+
+// Line returns number of the last read line, starting from 1
+func (s *LinesReader) Line() int { return s.line }
+
+// Skipped returns errors of the skipped lines
+func (s *LinesReader) Skipped() []*LineError { return s.skipped }
+
+// readLine returns the next line without the line break. The rest of the too long line is discarded
+func (s *LinesReader) readLine() (line []byte, err error) {
+	s.buf = s.buf[:0]
+	tooLong, read := false, false
+	for {
+		chunk, rErr := s.r.ReadSlice('\n')
+		read = read || len(chunk) > 0
+		if !tooLong {
+			s.buf = append(s.buf, chunk...)
+			// line break is not counted
+			tooLong = s.maxSize > 0 && len(bytes.TrimRight(s.buf, "\r\n")) > s.maxSize
+		}
+		switch rErr {
+		case nil:
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if !read {
+				return nil, io.EOF
+			}
+		default:
+			return nil, rErr
+		}
+		break
+	}
+	s.line++
+	if tooLong {
+		return nil, &LineError{Line: s.line, Err: ErrLineTooLong}
+	}
+	return bytes.TrimRight(s.buf, "\r\n"), nil
+}
+
+// Decode decodes value of the next not blank line by the JSONDecoder. Returns io.EOF at the end of the source,
+// errors of the lines are *LineError
+func (s *LinesReader) Decode(v interface{}) error {
+	for {
+		line, err := s.readLine()
+		if err == nil {
+			if line = bytes.TrimSpace(line); len(line) == 0 {
+				continue
+			}
+			err = s.decodeLine(line, v)
+		}
+		var lineErr *LineError
+		if err == nil || !errors.As(err, &lineErr) {
+			return err
+		}
+		if !s.skipInvalid {
+			return err
+		}
+		s.skipped = append(s.skipped, lineErr)
+	}
+}
+
+func (s *LinesReader) decodeLine(line []byte, v interface{}) (err error) {
+	dec := InitJSONDecoderFromSource(line)
+	if err = dec.Decode(v); err == nil {
+		if _, tErr := dec.Token(); tErr != io.EOF {
+			err = fmt.Errorf("unexpected data after the value")
+		}
+	}
+	if err != nil {
+		return &LineError{Line: s.line, Err: err}
+	}
+	return
+}
+
+// Map decodes object of the next line
+func (s *LinesReader) Map() (res Map, err error) {
+	err = s.Decode(&res)
+	return
+}
+
+///////////////////////////////////////////////
+
+// NewLinesWriter returns writer of the JSON Lines
+func NewLinesWriter(w io.Writer) *LinesWriter {
+	return &LinesWriter{w: bufio.NewWriter(w)}
+}
+
+// LinesWriter encodes values to the lines. Values are encoded before the write, so the line is
+// written entirely or not written. Writer is safe for the concurrent use, written lines are
+// buffered before the Flush call
+type LinesWriter struct {
+	mutex sync.Mutex
+	w     *bufio.Writer
+}
+
+// Encode writes value as the line
+func (s *LinesWriter) Encode(v interface{}) error {
+	src, err := EncodeBytes(v)
+	if err != nil {
+		return err
+	}
+	return s.WriteRaw(src)
+}
+
+// WriteRaw writes encoded JSON value as the line, line breaks of the value are removed
+func (s *LinesWriter) WriteRaw(src []byte) error {
+	if bytes.ContainsAny(src, "\r\n") {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, src); err != nil {
+			return err
+		}
+		src = buf.Bytes()
+	}
+	// the line break is written together with the value, the source slice is not changed
+	line := append(src[:len(src):len(src)], '\n')
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.w.Available() < len(line) && s.w.Buffered() > 0 {
+		// line is not split between the writes of the buffer, the long line is written by one call
+		if err := s.w.Flush(); err != nil {
+			return err
+		}
+	}
+	_, err := s.w.Write(line)
+	return err
+}
+
+// Flush writes buffered lines to the writer
+func (s *LinesWriter) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.w.Flush()
+}
@@ -62,6 +62,9 @@ func (s Map) KeysExists(keys []string) string {
 func val(l, r interface{}) (res reflect.Value) {
 	lVal, rVal := reflect.ValueOf(l), reflect.ValueOf(r)
 	if lVal.Kind() == reflect.Ptr && rVal.Kind() != reflect.Ptr {
+		if lVal.IsNil() {
+			return rVal
+		}
 		return val(lVal.Elem().Interface(), r)
 	}
 	defer func() {
@@ -84,7 +87,7 @@ func (s Map) Int(key string, defaultVal int64) int64 {
 
 func (s Map) Int32(key string, defaultVal int) int {
 	if iface, check := s[key]; check {
-		return val(iface, defaultVal).Interface().(int)
+		return int(val(iface, defaultVal).Int())
 	}
 	return defaultVal
 }
@@ -210,7 +213,7 @@ func (s Map) JSON() (res []byte) {
 // ToMap returns map[string]interface{} of the self object
 func (s Map) ToMap() map[string]interface{} { return map[string]interface{}(s) }
 
-// Copy returns copied map (todo dipcopy)
+// Copy returns shallow copy of the map, nested objects and arrays are shared (see DeepCopy)
 func (s Map) Copy() (res Map) {
 	res = make(Map)
 	for key, val := range s {
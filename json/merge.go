@@ -0,0 +1,144 @@
+package json
+
+import (
+	"strconv"
+)
+
+// deepCopy copies objects and arrays of the JSON document
+func deepCopy(v interface{}) interface{} {
+	switch val := v.(type) {
+	case Map:
+		res := make(Map, len(val))
+		for key, item := range val {
+			res[key] = deepCopy(item)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for key, item := range val {
+			res[key] = deepCopy(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, item := range val {
+			res[i] = deepCopy(item)
+		}
+		return res
+	}
+	return v
+}
+
+// DeepCopy returns copy of the map with copied nested objects and arrays
+func (s Map) DeepCopy() Map {
+	return deepCopy(s).(Map)
+}
+
+// SliceStrategy defines merge of the arrays
+type SliceStrategy byte
+
+const (
+	// SliceReplace - arrays are conflicting values resolved by the ConflictStrategy
+	SliceReplace SliceStrategy = iota
+	// SliceAppend - the right array is appended to the left
+	SliceAppend
+	// SliceMergeByKey - objects with the same value of the MergeOptions.SliceKey field are merged,
+	// other items are appended if the left array doesn't contain them
+	SliceMergeByKey
+)
+
+// ConflictStrategy defines result of the different values by the same path
+type ConflictStrategy byte
+
+const (
+	ConflictRightWins ConflictStrategy = iota
+	ConflictLeftWins
+	// ConflictCallback - the MergeOptions.Resolve result is used
+	ConflictCallback
+)
+
+// MergeOptions of the DeepMerge. Default options: arrays are replaced, right values win
+type MergeOptions struct {
+	Slices   SliceStrategy
+	SliceKey string
+	// Conflicts strategy, used for the values which are not objects (and not arrays for the
+	// SliceAppend and SliceMergeByKey strategies)
+	Conflicts ConflictStrategy
+	// Resolve returns result value of the conflict. Path is the JSON Pointer of the value
+	Resolve func(path string, left, right interface{}) interface{}
+}
+
+// DeepMerge returns new map with the values of the src merged into the copy of the map recursively.
+// Used for the layered configuration: defaults.DeepMerge(overrides, opts)
+func (s Map) DeepMerge(src Map, opts MergeOptions) Map {
+	return opts.merge("", s, src).(Map)
+}
+
+func (s MergeOptions) merge(path string, left, right interface{}) interface{} {
+	lm, lCheck := childMap(left)
+	rm, rCheck := childMap(right)
+	if lCheck && rCheck {
+		res := deepCopy(left)
+		resMap, _ := childMap(res)
+		for key, val := range rm {
+			if prev, exists := lm[key]; exists {
+				resMap[key] = s.merge(path+"/"+pointerEscape(key), prev, val)
+			} else {
+				resMap[key] = deepCopy(val)
+			}
+		}
+		return res
+	}
+	ls, lCheck := left.([]interface{})
+	rs, rCheck := right.([]interface{})
+	if lCheck && rCheck {
+		switch s.Slices {
+		case SliceAppend:
+			return append(deepCopy(ls).([]interface{}), deepCopy(rs).([]interface{})...)
+		case SliceMergeByKey:
+			return s.mergeByKey(path, ls, rs)
+		}
+	}
+	if jsonEqual(left, right) {
+		return deepCopy(left)
+	}
+	switch {
+	case s.Conflicts == ConflictLeftWins:
+		return deepCopy(left)
+	case s.Conflicts == ConflictCallback && s.Resolve != nil:
+		return s.Resolve(path, left, right)
+	default:
+		return deepCopy(right)
+	}
+}
+
+func (s MergeOptions) mergeByKey(path string, left, right []interface{}) []interface{} {
+	res := deepCopy(left).([]interface{})
+	keyValue := func(item interface{}) (interface{}, bool) {
+		m, check := childMap(item)
+		if !check {
+			return nil, false
+		}
+		val, check := m[s.SliceKey]
+		return val, check
+	}
+	for _, item := range right {
+		found := false
+		rKey, rCheck := keyValue(item)
+		for i, prev := range res {
+			if rCheck {
+				if lKey, lCheck := keyValue(prev); lCheck && jsonEqual(lKey, rKey) {
+					res[i], found = s.merge(path+"/"+strconv.Itoa(i), prev, item), true
+					break
+				}
+			} else if jsonEqual(prev, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, deepCopy(item))
+		}
+	}
+	return res
+}
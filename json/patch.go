@@ -0,0 +1,433 @@
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is the operation of the RFC 6902 JSON Patch
+type PatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// Patch is the RFC 6902 JSON Patch document
+type Patch []PatchOperation
+
+// ParsePatch parses JSON Patch document
+func ParsePatch(src []byte) (res Patch, err error) {
+	err = json.Unmarshal(src, &res)
+	return
+}
+
+func (s PatchOperation) MarshalJSON() ([]byte, error) {
+	m := Map{"op": s.Op, "path": s.Path}
+	switch s.Op {
+	case "move", "copy":
+		m["from"] = s.From
+	case "add", "replace", "test":
+		m["value"] = s.Value
+	}
+	return json.Marshal(m)
+}
+
+func (s *PatchOperation) UnmarshalJSON(src []byte) (err error) {
+	var m map[string]json.RawMessage
+	if err = json.Unmarshal(src, &m); err != nil {
+		return
+	}
+	if err = json.Unmarshal(m["op"], &s.Op); err != nil {
+		return fmt.Errorf("json patch: invalid op: %v", err)
+	}
+	if err = json.Unmarshal(m["path"], &s.Path); err != nil {
+		return fmt.Errorf("json patch %v: invalid path: %v", s.Op, err)
+	}
+	switch s.Op {
+	case "add", "replace", "test":
+		raw, check := m["value"]
+		if !check {
+			return fmt.Errorf("json patch %v %v: value is not defined", s.Op, s.Path)
+		}
+		err = json.Unmarshal(raw, &s.Value)
+	case "move", "copy":
+		err = json.Unmarshal(m["from"], &s.From)
+	case "remove":
+	default:
+		return fmt.Errorf("json patch: unknown op %q", s.Op)
+	}
+	return
+}
+
+// pointerTokens parses JSON Pointer, the dotted paths are not allowed in patches
+func pointerTokens(path string) ([]pathToken, error) {
+	if path != "" && path[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON Pointer %q", path)
+	}
+	return parsePath(path)
+}
+
+// arrayIndex returns index of the array element, "-" is the index after the last element
+func arrayIndex(key string, length int, allowEnd bool) (int, error) {
+	if key == "-" && allowEnd {
+		return length, nil
+	}
+	if !isIndex(key) || (len(key) > 1 && key[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", key)
+	}
+	index, err := strconv.Atoi(key)
+	if err != nil || index > length || (index == length && !allowEnd) {
+		return 0, fmt.Errorf("array index %q is out of range", key)
+	}
+	return index, nil
+}
+
+// patchParent calls fn for the parent of the path and returns updated node
+func patchParent(node interface{}, tokens []pathToken, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(node, tokens[0].key)
+	}
+	key := tokens[0].key
+	if m, check := childMap(node); check {
+		child, exists := m[key]
+		if !exists {
+			return nil, fmt.Errorf("key %q not found", key)
+		}
+		child, err := patchParent(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = child
+		return node, nil
+	}
+	sl, check := node.([]interface{})
+	if !check {
+		return nil, fmt.Errorf("key %q: %T is not an object or array", key, node)
+	}
+	index, err := arrayIndex(key, len(sl), false)
+	if err != nil {
+		return nil, err
+	}
+	if sl[index], err = patchParent(sl[index], tokens[1:], fn); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func patchAdd(node interface{}, tokens []pathToken, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return patchParent(node, tokens, func(parent interface{}, key string) (interface{}, error) {
+		if m, check := childMap(parent); check {
+			m[key] = value
+			return parent, nil
+		}
+		sl, check := parent.([]interface{})
+		if !check {
+			return nil, fmt.Errorf("key %q: %T is not an object or array", key, parent)
+		}
+		index, err := arrayIndex(key, len(sl), true)
+		if err != nil {
+			return nil, err
+		}
+		sl = append(sl, nil)
+		copy(sl[index+1:], sl[index:])
+		sl[index] = value
+		return sl, nil
+	})
+}
+
+func patchRemove(node interface{}, tokens []pathToken) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("root can't be removed")
+	}
+	return patchParent(node, tokens, func(parent interface{}, key string) (interface{}, error) {
+		if m, check := childMap(parent); check {
+			if _, exists := m[key]; !exists {
+				return nil, fmt.Errorf("key %q not found", key)
+			}
+			delete(m, key)
+			return parent, nil
+		}
+		sl, check := parent.([]interface{})
+		if !check {
+			return nil, fmt.Errorf("key %q: %T is not an object or array", key, parent)
+		}
+		index, err := arrayIndex(key, len(sl), false)
+		if err != nil {
+			return nil, err
+		}
+		return append(sl[:index], sl[index+1:]...), nil
+	})
+}
+
+func patchReplace(node interface{}, tokens []pathToken, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return patchParent(node, tokens, func(parent interface{}, key string) (interface{}, error) {
+		if m, check := childMap(parent); check {
+			if _, exists := m[key]; !exists {
+				return nil, fmt.Errorf("key %q not found", key)
+			}
+			m[key] = value
+			return parent, nil
+		}
+		sl, check := parent.([]interface{})
+		if !check {
+			return nil, fmt.Errorf("key %q: %T is not an object or array", key, parent)
+		}
+		index, err := arrayIndex(key, len(sl), false)
+		if err != nil {
+			return nil, err
+		}
+		sl[index] = value
+		return sl, nil
+	})
+}
+
+func (s PatchOperation) apply(doc interface{}) (interface{}, error) {
+	tokens, err := pointerTokens(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch s.Op {
+	case "add":
+		return patchAdd(doc, tokens, deepCopy(s.Value))
+	case "remove":
+		return patchRemove(doc, tokens)
+	case "replace":
+		return patchReplace(doc, tokens, deepCopy(s.Value))
+	case "test":
+		val, check := pathGet(doc, tokens)
+		if !check {
+			return nil, fmt.Errorf("value not found")
+		}
+		if !jsonEqual(val, s.Value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	case "move", "copy":
+		fromTokens, err := pointerTokens(s.From)
+		if err != nil {
+			return nil, err
+		}
+		val, check := pathGet(doc, fromTokens)
+		if !check {
+			return nil, fmt.Errorf("from value %q not found", s.From)
+		}
+		if s.Op == "copy" {
+			return patchAdd(doc, tokens, deepCopy(val))
+		}
+		if s.From == s.Path {
+			return doc, nil
+		}
+		if strings.HasPrefix(s.Path, s.From+"/") {
+			return nil, fmt.Errorf("value can't be moved into its child")
+		}
+		if doc, err = patchRemove(doc, fromTokens); err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, tokens, val)
+	default:
+		return nil, fmt.Errorf("unknown op %q", s.Op)
+	}
+}
+
+// ApplyPatch applies patch to the copy of the document and returns the result. The source document is not changed
+func ApplyPatch(doc interface{}, patch Patch) (res interface{}, err error) {
+	res = deepCopy(doc)
+	for i, op := range patch {
+		if res, err = op.apply(res); err != nil {
+			return nil, fmt.Errorf("json patch operation %v (%v %v): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return
+}
+
+// ApplyPatch applies JSON Patch. The operations are applied atomically: if one of them fails,
+// the map is not changed
+func (s Map) ApplyPatch(patch Patch) error {
+	res, err := ApplyPatch(s, patch)
+	if err != nil {
+		return err
+	}
+	m, check := childMap(res)
+	if !check {
+		return fmt.Errorf("json patch: result %T is not an object", res)
+	}
+	for key := range s {
+		delete(s, key)
+	}
+	for key, val := range m {
+		s[key] = val
+	}
+	return nil
+}
+
+// MergePatch applies RFC 7386 JSON Merge Patch: null values remove keys, objects are merged
+// recursively, other values replace the existing ones
+func (s Map) MergePatch(patch Map) {
+	for key, val := range patch {
+		if val == nil {
+			delete(s, key)
+		} else {
+			s[key] = mergePatch(s[key], val)
+		}
+	}
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	pm, check := childMap(patch)
+	if !check {
+		return deepCopy(patch)
+	}
+	tm, check := childMap(target)
+	if !check {
+		tm = Map{}
+	}
+	Map(tm).MergePatch(Map(pm))
+	return tm
+}
+
+// CreatePatch returns JSON Patch converting the from map to the to map
+func CreatePatch(from, to Map) Patch {
+	return diff(nil, "", from, to)
+}
+
+func pointerEscape(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func diff(res Patch, path string, from, to interface{}) Patch {
+	fm, fCheck := childMap(from)
+	tm, tCheck := childMap(to)
+	if fCheck && tCheck {
+		for _, key := range sortedKeys(fm) {
+			if _, exists := tm[key]; !exists {
+				res = append(res, PatchOperation{Op: "remove", Path: path + "/" + pointerEscape(key)})
+			}
+		}
+		for _, key := range sortedKeys(tm) {
+			if val, exists := fm[key]; exists {
+				res = diff(res, path+"/"+pointerEscape(key), val, tm[key])
+			} else {
+				res = append(res, PatchOperation{Op: "add", Path: path + "/" + pointerEscape(key), Value: deepCopy(tm[key])})
+			}
+		}
+		return res
+	}
+	fs, fCheck := from.([]interface{})
+	ts, tCheck := to.([]interface{})
+	if fCheck && tCheck {
+		for i := 0; i < len(fs) && i < len(ts); i++ {
+			res = diff(res, path+"/"+strconv.Itoa(i), fs[i], ts[i])
+		}
+		// removed from the end to keep indexes
+		for i := len(fs) - 1; i >= len(ts); i-- {
+			res = append(res, PatchOperation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := len(fs); i < len(ts); i++ {
+			res = append(res, PatchOperation{Op: "add", Path: path + "/-", Value: deepCopy(ts[i])})
+		}
+		return res
+	}
+	if !jsonEqual(from, to) {
+		res = append(res, PatchOperation{Op: "replace", Path: path, Value: deepCopy(to)})
+	}
+	return res
+}
+
+// CreateMergePatch returns JSON Merge Patch converting the from map to the to map
+func CreateMergePatch(from, to Map) Map {
+	res := Map{}
+	for key := range from {
+		if _, exists := to[key]; !exists {
+			res[key] = nil
+		}
+	}
+	for key, val := range to {
+		prev, exists := from[key]
+		if !exists {
+			res[key] = deepCopy(val)
+			continue
+		}
+		pm, pCheck := childMap(prev)
+		vm, vCheck := childMap(val)
+		if pCheck && vCheck {
+			if sub := CreateMergePatch(pm, vm); len(sub) > 0 {
+				res[key] = sub
+			}
+		} else if !jsonEqual(prev, val) {
+			res[key] = deepCopy(val)
+		}
+	}
+	return res
+}
+
+// jsonEqual compares values of the JSON documents, numbers of different types are compared by value
+func jsonEqual(l, r interface{}) bool {
+	lm, lCheck := childMap(l)
+	rm, rCheck := childMap(r)
+	if lCheck || rCheck {
+		if !lCheck || !rCheck || len(lm) != len(rm) {
+			return false
+		}
+		for key, val := range lm {
+			if rVal, exists := rm[key]; !exists || !jsonEqual(val, rVal) {
+				return false
+			}
+		}
+		return true
+	}
+	ls, lCheck := l.([]interface{})
+	rs, rCheck := r.([]interface{})
+	if lCheck || rCheck {
+		if !lCheck || !rCheck || len(ls) != len(rs) {
+			return false
+		}
+		for i := range ls {
+			if !jsonEqual(ls[i], rs[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if lNum, check := number(l); check {
+		rNum, check := number(r)
+		return check && lNum == rNum
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+func number(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	if n, check := v.(json.Number); check {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
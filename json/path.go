@@ -0,0 +1,281 @@
+package json
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathToken is the element of the path, index is true for the "[n]" elements
+// and the array tokens of the JSON Pointer ("-" and numbers)
+type pathToken struct {
+	key   string
+	index bool
+}
+
+// ParsePath checks path syntax. Path can be RFC 6901 JSON Pointer ("/items/2/price")
+// or dotted path with indexes ("items[2].price"). Empty path refers to the root
+func ParsePath(path string) error {
+	_, err := parsePath(path)
+	return err
+}
+
+func parsePath(path string) (res []pathToken, err error) {
+	switch {
+	case path == "":
+		return nil, nil
+	case path[0] == '/':
+		replacer := strings.NewReplacer("~1", "/", "~0", "~")
+		for _, key := range strings.Split(path[1:], "/") {
+			key = replacer.Replace(key)
+			res = append(res, pathToken{key: key, index: key == "-" || isIndex(key)})
+		}
+		return
+	}
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 || !isIndex(path[i+1:i+end]) {
+				return nil, fmt.Errorf("json path %q: invalid index at %v", path, i)
+			}
+			res = append(res, pathToken{key: path[i+1 : i+end], index: true})
+			i += end + 1
+			if i < len(path) && path[i] != '.' && path[i] != '[' {
+				return nil, fmt.Errorf("json path %q: unexpected symbol at %v", path, i)
+			}
+		case '.':
+			if i == 0 || i == len(path)-1 || path[i+1] == '.' || path[i+1] == '[' {
+				return nil, fmt.Errorf("json path %q: empty key at %v", path, i)
+			}
+			i++
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			res = append(res, pathToken{key: path[i : i+end]})
+			i += end
+		}
+	}
+	return
+}
+
+func isIndex(src string) bool {
+	if src == "" {
+		return false
+	}
+	for _, c := range src {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// childMap returns map of the node if it is Map or map[string]interface{}
+func childMap(node interface{}) (res map[string]interface{}, check bool) {
+	switch m := node.(type) {
+	case Map:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	}
+	return
+}
+
+func pathGet(node interface{}, tokens []pathToken) (interface{}, bool) {
+	for _, token := range tokens {
+		if m, check := childMap(node); check {
+			if node, check = m[token.key]; !check {
+				return nil, false
+			}
+			continue
+		}
+		sl, check := node.([]interface{})
+		if !check {
+			return nil, false
+		}
+		index, err := strconv.Atoi(token.key)
+		if err != nil || index < 0 || index >= len(sl) {
+			return nil, false
+		}
+		node = sl[index]
+	}
+	return node, true
+}
+
+// pathSet sets value and returns updated node. Nil nodes are created as map or slice
+// by the token type. Index equal to the slice length appends the value, greater index is the error
+func pathSet(node interface{}, tokens []pathToken, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	token := tokens[0]
+	if node == nil {
+		if token.index {
+			node = []interface{}{}
+		} else {
+			node = Map{}
+		}
+	}
+	if m, check := childMap(node); check {
+		child, err := pathSet(m[token.key], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		m[token.key] = child
+		return node, nil
+	}
+	sl, check := node.([]interface{})
+	if !check {
+		return nil, fmt.Errorf("key %q: %T is not an object or array", token.key, node)
+	}
+	index := len(sl)
+	if token.key != "-" {
+		var err error
+		if index, err = strconv.Atoi(token.key); err != nil || index < 0 {
+			return nil, fmt.Errorf("key %q: invalid array index", token.key)
+		}
+		if index > len(sl) {
+			return nil, fmt.Errorf("key %q: array index is out of range", token.key)
+		}
+	}
+	if index == len(sl) {
+		sl = append(sl, nil)
+	}
+	child, err := pathSet(sl[index], tokens[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	sl[index] = child
+	return sl, nil
+}
+
+// pathDelete removes value and returns updated node
+func pathDelete(node interface{}, tokens []pathToken) (interface{}, bool) {
+	token := tokens[0]
+	if m, check := childMap(node); check {
+		child, exists := m[token.key]
+		if !exists {
+			return node, false
+		}
+		if len(tokens) == 1 {
+			delete(m, token.key)
+			return node, true
+		}
+		if child, check = pathDelete(child, tokens[1:]); check {
+			m[token.key] = child
+		}
+		return node, check
+	}
+	sl, check := node.([]interface{})
+	if !check {
+		return node, false
+	}
+	index, err := strconv.Atoi(token.key)
+	if err != nil || index < 0 || index >= len(sl) {
+		return node, false
+	}
+	if len(tokens) == 1 {
+		return append(sl[:index], sl[index+1:]...), true
+	}
+	child, check := pathDelete(sl[index], tokens[1:])
+	if check {
+		sl[index] = child
+	}
+	return sl, check
+}
+
+// PathGet returns value by path (see ParsePath)
+func (s Map) PathGet(path string) (interface{}, bool) {
+	tokens, err := parsePath(path)
+	if err != nil {
+		return nil, false
+	}
+	return pathGet(s, tokens)
+}
+
+// PathExists check value exists by path
+func (s Map) PathExists(path string) bool {
+	_, check := s.PathGet(path)
+	return check
+}
+
+// PathSet sets value by path. Intermediate objects and arrays are created, the array is created
+// if the next path element is an index. "-" token of the JSON Pointer or index equal to the array
+// length appends value to the array, greater index is the error
+func (s Map) PathSet(path string, value interface{}) error {
+	tokens, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("json path: root can't be replaced")
+	}
+	if _, err = pathSet(s, tokens, value); err != nil {
+		return fmt.Errorf("json path %q: %v", path, err)
+	}
+	return nil
+}
+
+// PathDelete removes value by path. Returns false if value is not exists
+func (s Map) PathDelete(path string) bool {
+	tokens, err := parsePath(path)
+	if err != nil || len(tokens) == 0 {
+		return false
+	}
+	_, check := pathDelete(s, tokens)
+	return check
+}
+
+// pathMap returns Map with the value of path stored by the empty key, used by the typed getters
+func (s Map) pathMap(path string) Map {
+	if val, check := s.PathGet(path); check {
+		return Map{"": val}
+	}
+	return Map{}
+}
+
+// PathValue works as Value by path
+func (s Map) PathValue(path string, defaultVal interface{}) interface{} {
+	return s.pathMap(path).Value("", defaultVal)
+}
+
+// PathBool works as Bool by path
+func (s Map) PathBool(path string, defaultVal bool) bool {
+	return s.pathMap(path).Bool("", defaultVal)
+}
+
+// PathInt works as Int by path
+func (s Map) PathInt(path string, defaultVal int64) int64 {
+	return s.pathMap(path).Int("", defaultVal)
+}
+
+// PathInt32 works as Int32 by path
+func (s Map) PathInt32(path string, defaultVal int) int {
+	return s.pathMap(path).Int32("", defaultVal)
+}
+
+// PathString works as String by path
+func (s Map) PathString(path, defaultVal string) string {
+	return s.pathMap(path).String("", defaultVal)
+}
+
+// PathSlice works as Slice by path
+func (s Map) PathSlice(path string, defaultVal []interface{}) []interface{} {
+	return s.pathMap(path).Slice("", defaultVal)
+}
+
+// PathStringSlice works as StringSlice by path
+func (s Map) PathStringSlice(path string, defaultVal []string) []string {
+	return s.pathMap(path).StringSlice("", defaultVal)
+}
+
+// PathMap works as Map by path
+func (s Map) PathMap(path string, defaultVal Map) Map {
+	if path == "" {
+		return s
+	}
+	return s.pathMap(path).Map("", defaultVal)
+}
@@ -0,0 +1,606 @@
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaError is the violation of the JSON Schema
+type SchemaError struct {
+	// Path is the JSON Pointer of the invalid value, empty for the root
+	Path    string
+	Keyword string
+	Message string
+}
+
+func (s SchemaError) Error() string {
+	path := s.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%v: %v: %v", path, s.Keyword, s.Message)
+}
+
+// schemaNode is the compiled schema or subschema
+type schemaNode struct {
+	// always is the result of the boolean schema
+	always *bool
+	types  []string
+	ref    *schemaNode
+
+	properties           map[string]*schemaNode
+	patternProperties    []schemaPattern
+	additionalProperties *schemaNode
+	required             []string
+	minProperties        int
+	maxProperties        int
+
+	enum       []interface{}
+	constValue interface{}
+	hasConst   bool
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength, maxLength int
+	pattern              *regexp.Regexp
+
+	items       *schemaNode
+	prefixItems []*schemaNode
+	minItems    int
+	maxItems    int
+	uniqueItems bool
+
+	allOf, anyOf, oneOf        []*schemaNode
+	not                        *schemaNode
+	ifNode, thenNode, elseNode *schemaNode
+}
+
+type schemaPattern struct {
+	re   *regexp.Regexp
+	node *schemaNode
+}
+
+// Schema is the compiled JSON Schema. Supported are the core validation keywords of the draft 7
+// and 2020-12: type, enum, const, properties, patternProperties, additionalProperties, required,
+// min/maxProperties, minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf, min/maxLength,
+// pattern, items, prefixItems, additionalItems, min/maxItems, uniqueItems, allOf, anyOf, oneOf, not,
+// if/then/else and $ref to the document definitions by JSON Pointer ("#/definitions/name").
+// Other keywords (format, $schema, title...) are ignored
+type Schema struct {
+	root     *schemaNode
+	document interface{}
+	refs     map[string]*schemaNode
+}
+
+// ParseSchema compiles schema source
+func ParseSchema(src []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(src, &doc); err != nil {
+		return nil, fmt.Errorf("json schema: %v", err)
+	}
+	return compileSchema(doc)
+}
+
+// ParseSchemaFile compiles schema from file
+func ParseSchemaFile(fileName string) (*Schema, error) {
+	src, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchema(src)
+}
+
+// NewSchema compiles schema from the map
+func NewSchema(m Map) (*Schema, error) {
+	return compileSchema(m)
+}
+
+func compileSchema(doc interface{}) (res *Schema, err error) {
+	res = &Schema{document: doc, refs: make(map[string]*schemaNode)}
+	if res.root, err = res.ref("#"); err != nil {
+		return nil, err
+	}
+	if err = res.checkCycles(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// checkCycles returns error if the reference leads to itself without the move to the nested value,
+// validation of such schema never ends. Each cycle passes the referenced node, so the search starts from them
+func (s *Schema) checkCycles() error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*schemaNode]byte)
+	var visit func(node *schemaNode) bool
+	visit = func(node *schemaNode) bool {
+		switch state[node] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+		state[node] = visiting
+		for _, next := range node.inPlace() {
+			if visit(next) {
+				return true
+			}
+		}
+		state[node] = visited
+		return false
+	}
+	refs := make([]string, 0, len(s.refs))
+	for ref := range s.refs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		if visit(s.refs[ref]) {
+			return fmt.Errorf("json schema: $ref %q is recursive without the nested value", ref)
+		}
+	}
+	return nil
+}
+
+// ref returns node of the reference, the node is compiled once, so recursive references are allowed
+func (s *Schema) ref(ref string) (*schemaNode, error) {
+	if node, check := s.refs[ref]; check {
+		return node, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("json schema: external $ref %q is not supported", ref)
+	}
+	tokens, err := pointerTokens(ref[1:])
+	if err != nil {
+		return nil, fmt.Errorf("json schema: $ref %q: %v", ref, err)
+	}
+	raw, check := pathGet(s.document, tokens)
+	if !check {
+		return nil, fmt.Errorf("json schema: $ref %q not found", ref)
+	}
+	node := new(schemaNode)
+	s.refs[ref] = node
+	if err = s.compile(node, raw, ref); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (s *Schema) subschema(raw interface{}, location string) (*schemaNode, error) {
+	node := new(schemaNode)
+	return node, s.compile(node, raw, location)
+}
+
+func (s *Schema) subschemas(raw interface{}, location string) (res []*schemaNode, err error) {
+	list, check := raw.([]interface{})
+	if !check {
+		return nil, fmt.Errorf("json schema %v: array expected", location)
+	}
+	for i, item := range list {
+		var node *schemaNode
+		if node, err = s.subschema(item, location+"/"+strconv.Itoa(i)); err != nil {
+			return
+		}
+		res = append(res, node)
+	}
+	return
+}
+
+func (s *Schema) compile(node *schemaNode, raw interface{}, location string) (err error) {
+	if b, check := raw.(bool); check {
+		node.always = &b
+		return
+	}
+	m, check := childMap(raw)
+	if !check {
+		return fmt.Errorf("json schema %v: object or boolean expected, not %T", location, raw)
+	}
+	node.minProperties, node.maxProperties = -1, -1
+	node.minLength, node.maxLength = -1, -1
+	node.minItems, node.maxItems = -1, -1
+	sub := func(key string) (res *schemaNode) {
+		if val, check := m[key]; check && err == nil {
+			res, err = s.subschema(val, location+"/"+key)
+		}
+		return
+	}
+	list := func(key string) (res []*schemaNode) {
+		if val, check := m[key]; check && err == nil {
+			res, err = s.subschemas(val, location+"/"+key)
+		}
+		return
+	}
+	num := func(key string) *float64 {
+		if val, check := number(m[key]); check {
+			return &val
+		}
+		return nil
+	}
+	count := func(key string) int {
+		if val, check := number(m[key]); check {
+			return int(val)
+		}
+		return -1
+	}
+	re := func(key, src string) (res *regexp.Regexp) {
+		if err == nil {
+			if res, err = regexp.Compile(src); err != nil {
+				err = fmt.Errorf("json schema %v/%v: %v", location, key, err)
+			}
+		}
+		return
+	}
+
+	if ref, check := m["$ref"].(string); check {
+		if node.ref, err = s.ref(ref); err != nil {
+			return
+		}
+	}
+	switch t := m["type"].(type) {
+	case string:
+		node.types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			node.types = append(node.types, fmt.Sprint(item))
+		}
+	}
+
+	// object
+	if props, check := childMap(m["properties"]); check {
+		node.properties = make(map[string]*schemaNode)
+		for _, key := range sortedKeys(props) {
+			if node.properties[key], err = s.subschema(props[key], location+"/properties/"+pointerEscape(key)); err != nil {
+				return
+			}
+		}
+	}
+	if props, check := childMap(m["patternProperties"]); check {
+		for _, key := range sortedKeys(props) {
+			p := schemaPattern{re: re("patternProperties", key)}
+			if err == nil {
+				p.node, err = s.subschema(props[key], location+"/patternProperties/"+pointerEscape(key))
+			}
+			node.patternProperties = append(node.patternProperties, p)
+		}
+	}
+	node.additionalProperties = sub("additionalProperties")
+	if required, check := m["required"].([]interface{}); check {
+		for _, key := range required {
+			node.required = append(node.required, fmt.Sprint(key))
+		}
+	}
+	node.minProperties, node.maxProperties = count("minProperties"), count("maxProperties")
+
+	// values
+	if enum, check := m["enum"].([]interface{}); check {
+		node.enum = enum
+	}
+	node.constValue, node.hasConst = m["const"]
+
+	// numbers
+	node.minimum, node.maximum, node.multipleOf = num("minimum"), num("maximum"), num("multipleOf")
+	node.exclusiveMinimum, node.exclusiveMaximum = num("exclusiveMinimum"), num("exclusiveMaximum")
+	// draft 4 boolean exclusive bounds
+	if b, _ := m["exclusiveMinimum"].(bool); b {
+		node.exclusiveMinimum, node.minimum = node.minimum, nil
+	}
+	if b, _ := m["exclusiveMaximum"].(bool); b {
+		node.exclusiveMaximum, node.maximum = node.maximum, nil
+	}
+
+	// strings
+	node.minLength, node.maxLength = count("minLength"), count("maxLength")
+	if pattern, check := m["pattern"].(string); check {
+		node.pattern = re("pattern", pattern)
+	}
+
+	// arrays
+	node.prefixItems = list("prefixItems")
+	if _, check := m["items"].([]interface{}); check {
+		// draft 7 tuple validation
+		node.prefixItems = list("items")
+		node.items = sub("additionalItems")
+	} else {
+		node.items = sub("items")
+	}
+	node.minItems, node.maxItems = count("minItems"), count("maxItems")
+	node.uniqueItems, _ = m["uniqueItems"].(bool)
+
+	// combinations
+	node.allOf, node.anyOf, node.oneOf = list("allOf"), list("anyOf"), list("oneOf")
+	node.not = sub("not")
+	node.ifNode, node.thenNode, node.elseNode = sub("if"), sub("then"), sub("else")
+	return
+}
+
+////////////////////////////////////////////////////////////////// validation
+
+// Validate checks value and returns all violations. Value can be Map, map[string]interface{}
+// or the other values decoded from JSON, Go slices, maps and structs are converted by JSON encoding
+func (s *Schema) Validate(v interface{}) (res []SchemaError) {
+	return s.root.validate(normalize(v), "", res)
+}
+
+// ValidateBytes decodes and checks JSON source
+func (s *Schema) ValidateBytes(src []byte) ([]SchemaError, error) {
+	var v interface{}
+	if err := json.Unmarshal(src, &v); err != nil {
+		return nil, err
+	}
+	return s.Validate(v), nil
+}
+
+// ValidateReader decodes and checks JSON source of the reader
+func (s *Schema) ValidateReader(r io.Reader) ([]SchemaError, error) {
+	var v interface{}
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	return s.Validate(v), nil
+}
+
+// ValidateSchema checks map by schema
+func (s Map) ValidateSchema(schema *Schema) []SchemaError {
+	return schema.Validate(s)
+}
+
+// normalize converts values of non JSON types by encoding/json
+func normalize(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, bool, string, float64, json.Number, Map, map[string]interface{}, []interface{}:
+		return v
+	}
+	if _, check := number(v); check {
+		return v
+	}
+	src, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var res interface{}
+	json.Unmarshal(src, &res)
+	return res
+}
+
+func jsonType(v interface{}) string {
+	if _, check := childMap(v); check {
+		return "object"
+	}
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	if n, check := number(v); check {
+		if n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// inPlace returns subschemas applied to the same value as the node
+func (s *schemaNode) inPlace() (res []*schemaNode) {
+	for _, node := range []*schemaNode{s.ref, s.not, s.ifNode, s.thenNode, s.elseNode} {
+		if node != nil {
+			res = append(res, node)
+		}
+	}
+	res = append(res, s.allOf...)
+	res = append(res, s.anyOf...)
+	return append(res, s.oneOf...)
+}
+
+func (s *schemaNode) valid(v interface{}, path string) bool {
+	return len(s.validate(v, path, nil)) == 0
+}
+
+func (s *schemaNode) validate(v interface{}, path string, res []SchemaError) []SchemaError {
+	fail := func(keyword, format string, args ...interface{}) {
+		res = append(res, SchemaError{Path: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.always != nil {
+		if !*s.always {
+			fail("false", "value is not allowed")
+		}
+		return res
+	}
+	v = normalize(v)
+	if s.ref != nil {
+		res = s.ref.validate(v, path, res)
+	}
+	vType := jsonType(v)
+	if len(s.types) > 0 {
+		match := false
+		for _, t := range s.types {
+			if t == vType || (t == "number" && vType == "integer") {
+				match = true
+			}
+		}
+		if !match {
+			fail("type", "expected %v, not %v", strings.Join(s.types, " or "), vType)
+			// other keywords are not checked for the invalid type
+			return res
+		}
+	}
+	if s.enum != nil {
+		match := false
+		for _, item := range s.enum {
+			if jsonEqual(v, item) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			fail("enum", "value is not one of the allowed values")
+		}
+	}
+	if s.hasConst && !jsonEqual(v, s.constValue) {
+		fail("const", "value must be %v", s.constValue)
+	}
+	switch vType {
+	case "object":
+		res = s.validateObject(v, path, res)
+	case "array":
+		res = s.validateArray(v.([]interface{}), path, res)
+	case "string":
+		str := v.(string)
+		length := utf8.RuneCountInString(str)
+		if s.minLength >= 0 && length < s.minLength {
+			fail("minLength", "length %v is less than %v", length, s.minLength)
+		}
+		if s.maxLength >= 0 && length > s.maxLength {
+			fail("maxLength", "length %v is more than %v", length, s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			fail("pattern", "value doesn't match %v", s.pattern)
+		}
+	case "integer", "number":
+		n, _ := number(v)
+		if s.minimum != nil && n < *s.minimum {
+			fail("minimum", "%v is less than %v", n, *s.minimum)
+		}
+		if s.maximum != nil && n > *s.maximum {
+			fail("maximum", "%v is more than %v", n, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum {
+			fail("exclusiveMinimum", "%v is not more than %v", n, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum {
+			fail("exclusiveMaximum", "%v is not less than %v", n, *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil && *s.multipleOf > 0 {
+			if q := n / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+				fail("multipleOf", "%v is not multiple of %v", n, *s.multipleOf)
+			}
+		}
+	}
+	for _, node := range s.allOf {
+		res = node.validate(v, path, res)
+	}
+	if len(s.anyOf) > 0 {
+		match := false
+		for _, node := range s.anyOf {
+			if node.valid(v, path) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			fail("anyOf", "value doesn't match any schema")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, node := range s.oneOf {
+			if node.valid(v, path) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("oneOf", "value matches %v schemas, expected exactly one", matches)
+		}
+	}
+	if s.not != nil && s.not.valid(v, path) {
+		fail("not", "value must not match the schema")
+	}
+	if s.ifNode != nil {
+		if s.ifNode.valid(v, path) {
+			if s.thenNode != nil {
+				res = s.thenNode.validate(v, path, res)
+			}
+		} else if s.elseNode != nil {
+			res = s.elseNode.validate(v, path, res)
+		}
+	}
+	return res
+}
+
+func (s *schemaNode) validateObject(v interface{}, path string, res []SchemaError) []SchemaError {
+	m, _ := childMap(v)
+	for _, key := range s.required {
+		if _, check := m[key]; !check {
+			res = append(res, SchemaError{Path: path + "/" + pointerEscape(key), Keyword: "required", Message: "value is required"})
+		}
+	}
+	if s.minProperties >= 0 && len(m) < s.minProperties {
+		res = append(res, SchemaError{Path: path, Keyword: "minProperties", Message: fmt.Sprintf("properties count %v is less than %v", len(m), s.minProperties)})
+	}
+	if s.maxProperties >= 0 && len(m) > s.maxProperties {
+		res = append(res, SchemaError{Path: path, Keyword: "maxProperties", Message: fmt.Sprintf("properties count %v is more than %v", len(m), s.maxProperties)})
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		keyPath, matched := path+"/"+pointerEscape(key), false
+		if node, check := s.properties[key]; check {
+			res, matched = node.validate(m[key], keyPath, res), true
+		}
+		for _, p := range s.patternProperties {
+			if p.re.MatchString(key) {
+				res, matched = p.node.validate(m[key], keyPath, res), true
+			}
+		}
+		if !matched && s.additionalProperties != nil {
+			if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+				res = append(res, SchemaError{Path: keyPath, Keyword: "additionalProperties", Message: "property is not allowed"})
+			} else {
+				res = s.additionalProperties.validate(m[key], keyPath, res)
+			}
+		}
+	}
+	return res
+}
+
+func (s *schemaNode) validateArray(list []interface{}, path string, res []SchemaError) []SchemaError {
+	if s.minItems >= 0 && len(list) < s.minItems {
+		res = append(res, SchemaError{Path: path, Keyword: "minItems", Message: fmt.Sprintf("items count %v is less than %v", len(list), s.minItems)})
+	}
+	if s.maxItems >= 0 && len(list) > s.maxItems {
+		res = append(res, SchemaError{Path: path, Keyword: "maxItems", Message: fmt.Sprintf("items count %v is more than %v", len(list), s.maxItems)})
+	}
+	for i, item := range list {
+		itemPath := path + "/" + strconv.Itoa(i)
+		if i < len(s.prefixItems) {
+			res = s.prefixItems[i].validate(item, itemPath, res)
+		} else if s.items != nil {
+			if s.items.always != nil && !*s.items.always {
+				res = append(res, SchemaError{Path: itemPath, Keyword: "items", Message: "item is not allowed"})
+			} else {
+				res = s.items.validate(item, itemPath, res)
+			}
+		}
+	}
+	if s.uniqueItems {
+		for i := 1; i < len(list); i++ {
+			for j := 0; j < i; j++ {
+				if jsonEqual(list[i], list[j]) {
+					res = append(res, SchemaError{Path: path + "/" + strconv.Itoa(i), Keyword: "uniqueItems", Message: fmt.Sprintf("item is equal to item %v", j)})
+					break
+				}
+			}
+		}
+	}
+	return res
+}
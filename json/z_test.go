@@ -1,11 +1,20 @@
 package json
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestJSON(t *testing.T) {
@@ -193,3 +202,820 @@ func TestMapVariable(t *testing.T) {
 	check := m.Variable("one", &val)
 	t.Log(check, val)
 }
+
+func (s TimeIntervals) JSONEncode(enc *JSONEncoder) (err error) {
+	if s == nil {
+		return enc.Encode(nil)
+	}
+	enc.BeginArray()
+	for _, interval := range s {
+		enc.Encode([]int{interval.Start, interval.Finish})
+	}
+	return enc.EndArray()
+}
+
+func (s *TObject) JSONFields(field JSONFieldEncoder) (err error) {
+	field("id", s.ID)
+	field("name", s.Name)
+	field("embedded", s.Embedded)
+	return field("intervals", s.Intervals)
+}
+
+func TestEncoder(t *testing.T) {
+	obj := &TObject{ID: 1, Name: "Test", Intervals: TimeIntervals{{11, 22}, {33, 44}}}
+	src, err := EncodeBytes(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != `{"id":1,"name":"Test","embedded":null,"intervals":[[11,22],[33,44]]}` {
+		t.Fatal(string(src))
+	}
+	var res TObject
+	if err = DecodeBytes(src, &res); err != nil || res.Name != "Test" || len(res.Intervals) != 2 || res.Intervals[1].Finish != 44 {
+		t.Fatal(err, res)
+	}
+
+	// nested custom encoders in maps and structs, indentation is the same as json.MarshalIndent
+	type Plain struct {
+		Name    string `json:"name"`
+		Skip    int    `json:"-"`
+		Empty   string `json:",omitempty"`
+		Count   int64  `json:"count,string"`
+		Objects []*TObject
+	}
+	val := Map{"plain": Plain{Name: "plain", Count: 5, Objects: []*TObject{obj, nil}}, "list": []interface{}{1, "two", nil}}
+	var buf bytes.Buffer
+	enc := InitJSONEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(val); err != nil {
+		t.Fatal(err)
+	}
+	std, _ := json.MarshalIndent(Map{
+		"plain": Map{"name": "plain", "count": "5", "Objects": []interface{}{Map{"id": 1, "name": "Test", "embedded": nil, "intervals": [][]int{{11, 22}, {33, 44}}}, nil}},
+		"list":  []interface{}{1, "two", nil},
+	}, "", "  ")
+	// key order of the std map differs, compare decoded values
+	var l, r interface{}
+	json.Unmarshal(buf.Bytes(), &l)
+	json.Unmarshal(std, &r)
+	if !reflect.DeepEqual(l, r) || !strings.Contains(buf.String(), "\n    \"Objects\": [\n      {\n        \"id\": 1,") {
+		t.Fatal(buf.String())
+	}
+}
+
+type shadowInner struct {
+	ID   int
+	Name string
+}
+
+type shadowOther struct {
+	Name  string
+	Title string `json:"title"`
+}
+
+type shadowOuter struct {
+	shadowInner
+	ID int
+}
+
+type shadowConflict struct {
+	shadowInner
+	*shadowOther
+	ID int
+}
+
+func TestEncoderShadowedFields(t *testing.T) {
+	// the outer field hides the embedded one
+	src, err := EncodeBytes(shadowOuter{shadowInner: shadowInner{ID: 1, Name: "x"}, ID: 2})
+	if err != nil || string(src) != `{"Name":"x","ID":2}` {
+		t.Fatal(err, string(src))
+	}
+	// conflicting names of the same depth are dropped, nil embedded pointer is skipped
+	for _, v := range []interface{}{
+		shadowConflict{shadowInner: shadowInner{ID: 1, Name: "x"}, ID: 2},
+		shadowConflict{shadowInner: shadowInner{ID: 1, Name: "x"}, shadowOther: &shadowOther{Name: "y", Title: "t"}, ID: 2},
+	} {
+		src, err = EncodeBytes(v)
+		std, _ := json.Marshal(v)
+		if err != nil || string(src) != string(std) {
+			t.Fatal(err, string(src), string(std))
+		}
+	}
+	if src, _ = EncodeBytes(shadowConflict{shadowOther: &shadowOther{Name: "y", Title: "t"}, ID: 2}); string(src) != `{"title":"t","ID":2}` {
+		t.Fatal(string(src))
+	}
+}
+
+func TestEncoderStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := InitJSONEncoder(&buf)
+	enc.BeginObject()
+	enc.Field("total", 3)
+	enc.Key("items")
+	enc.BeginArray()
+	for i := 0; i < 3; i++ {
+		enc.Encode(Map{"i": i})
+	}
+	enc.EndArray()
+	if err := enc.EndObject(); err != nil {
+		t.Fatal(err)
+	}
+	enc.Encode("next")
+	if buf.String() != "{\"total\":3,\"items\":[{\"i\":0},{\"i\":1},{\"i\":2}]}\n\"next\"\n" {
+		t.Fatal(buf.String())
+	}
+	// value without key inside object
+	enc = InitJSONEncoder(&buf)
+	enc.BeginObject()
+	if err := enc.Encode(1); err == nil || enc.EndObject() == nil {
+		t.Fatal("expected key error")
+	}
+}
+
+type TaggedBase struct {
+	ID      int64 `json:"id,string"`
+	Created time.Time
+}
+
+type TaggedNested struct {
+	Value string
+}
+
+type Tagged struct {
+	TaggedBase
+	*TaggedNested
+	Name    string `json:"name"`
+	Skip    string `json:"-"`
+	Objects map[string]*TObject
+	Counts  map[int]int
+	Data    []byte
+}
+
+func TestDecoderTags(t *testing.T) {
+	src := `{
+		"id": "15", "created": "2020-01-02T03:04:05Z", "VALUE": "nested", "name": "tagged", "Skip": "skip",
+		"objects": {"one": {"id": 1, "intervals": [[1, 2]]}, "two": null}, "counts": {"1": 10}, "data": "AQI=", "unknown": [1, {"a": 2}]
+	}`
+	var res Tagged
+	if err := DecodeBytes([]byte(src), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != 15 || res.Created.Year() != 2020 || res.TaggedNested == nil || res.Value != "nested" || res.Name != "tagged" || res.Skip != "" {
+		t.Fatal(res)
+	}
+	if len(res.Objects) != 2 || res.Objects["one"].ID != 1 || len(res.Objects["one"].Intervals) != 1 || res.Objects["two"] != nil {
+		t.Fatal(res.Objects)
+	}
+	if res.Counts[1] != 10 || !bytes.Equal(res.Data, []byte{1, 2}) {
+		t.Fatal(res.Counts, res.Data)
+	}
+	var std Tagged
+	json.Unmarshal([]byte(src), &std)
+	std.Objects = res.Objects
+	if !reflect.DeepEqual(res, std) {
+		t.Fatal(res, std)
+	}
+}
+
+type streamItem struct {
+	ID     int   `json:"id"`
+	Values []int `json:"values,omitempty"`
+}
+
+func TestArrayDecode(t *testing.T) {
+	// big array is streamed by the encoder
+	r, w := io.Pipe()
+	go func() {
+		enc := InitJSONEncoder(w)
+		enc.BeginObject()
+		enc.Field("total", 20000)
+		enc.Key("data")
+		enc.BeginObject()
+		enc.Field("skip", Map{"items": []int{1, 2}})
+		enc.Key("items")
+		enc.BeginArray()
+		for i := 0; i < 20000; i++ {
+			enc.Encode(streamItem{ID: i, Values: []int{i, i + 1}})
+		}
+		enc.EndArray()
+		enc.EndObject()
+		enc.EndObject()
+		w.Close()
+	}()
+	var obj streamItem
+	sum := 0
+	err := InitJSONDecoder(r).ArrayDecode([]string{"data", "items"}, &obj, func(index int) (bool, error) {
+		if obj.ID != index || len(obj.Values) != 2 || obj.Values[1] != index+1 {
+			return false, fmt.Errorf("unexpected object %v: %v", index, obj)
+		}
+		sum++
+		return true, nil
+	})
+	if err != nil || sum != 20000 {
+		t.Fatal(err, sum)
+	}
+
+	// index path and early stop
+	dec := InitJSONDecoderFromSource([]byte(`[{"a": [1, 2]}, {"a": [3, 4, 5]}]`))
+	var values []int
+	err = dec.ArrayEach([]string{"1", "a"}, func(index int, dec *JSONDecoder) (bool, error) {
+		var v int
+		err := dec.Decode(&v)
+		values = append(values, v)
+		return index < 1, err
+	})
+	if err != nil || !reflect.DeepEqual(values, []int{3, 4}) {
+		t.Fatal(err, values)
+	}
+	if err = InitJSONDecoderFromSource([]byte(`{"a": {"b": 1}}`)).ArrayEach([]string{"a", "c"}, nil); err == nil {
+		t.Fatal("path error expected")
+	}
+	if err = InitJSONDecoderFromSource([]byte(`{"a": {"b": 1}}`)).ArrayEach([]string{"a", "b"}, nil); err == nil {
+		t.Fatal("array error expected")
+	}
+}
+
+func TestPath(t *testing.T) {
+	var m Map
+	json.Unmarshal([]byte(`{"items": [{"price": 10.5, "name": "one"}, {"price": 20, "tags": ["a", "b"]}], "a/b": {"c~d": true}}`), &m)
+	m["raw"] = map[string]interface{}{"inner": Map{"value": "5"}}
+	if m.PathInt("items[1].price", 0) != 20 || m.PathString("/items/0/name", "") != "one" || m.PathString("items[1].tags[1]", "") != "b" {
+		t.Fatal(m)
+	}
+	if !m.PathBool("/a~1b/c~0d", false) || m.PathInt32("raw.inner.value", 0) != 0 || m.PathString("raw.inner.value", "") != "5" {
+		t.Fatal(m)
+	}
+	if m.PathExists("items[2]") || m.PathExists("items.x") || !m.PathExists("/items/1/tags/0") || m.PathMap("raw.inner", nil) == nil {
+		t.Fatal("exists")
+	}
+	if err := m.PathSet("items[1].tags[2]", "c"); err != nil || len(m.PathSlice("items[1].tags", nil)) != 3 {
+		t.Fatal(err, m.PathSlice("items[1].tags", nil))
+	}
+	if err := m.PathSet("items[1].tags[1000000000]", "d"); err == nil || len(m.PathSlice("items[1].tags", nil)) != 3 {
+		t.Fatal("out of range index", err)
+	}
+	if err := m.PathSet("/new/list/-", 1); err != nil || m.PathInt("new.list[0]", 0) != 1 {
+		t.Fatal(err, m["new"])
+	}
+	m.PathSet("/new/list/-", 2)
+	if err := m.PathSet("created.deep[0].key", "v"); err != nil || m.PathString("/created/deep/0/key", "") != "v" || m.PathExists("created.deep[1]") {
+		t.Fatal(err, m["created"])
+	}
+	if err := m.PathSet("other.deep[1]", "v"); err == nil {
+		t.Fatal("out of range index of the created array")
+	}
+	if err := m.PathSet("items[0].name.x", 1); err == nil {
+		t.Fatal("set into string")
+	}
+	if !m.PathDelete("items[0]") || m.PathInt("items[0].price", 0) != 20 || !m.PathDelete("raw.inner.value") || m.PathExists("raw.inner.value") {
+		t.Fatal(m)
+	}
+	if m.PathDelete("items[5]") || ParsePath("a..b") == nil || ParsePath("a[x]") == nil || ParsePath("a[1]b") == nil {
+		t.Fatal("invalid path")
+	}
+}
+
+func TestPatch(t *testing.T) {
+	var doc Map
+	json.Unmarshal([]byte(`{"a": {"b": [1, 2, 3]}, "c": "value", "d/e": 5}`), &doc)
+	patch, err := ParsePatch([]byte(`[
+		{"op": "test", "path": "/d~1e", "value": 5.0},
+		{"op": "add", "path": "/a/b/1", "value": 10},
+		{"op": "add", "path": "/a/b/-", "value": null},
+		{"op": "remove", "path": "/a/b/0"},
+		{"op": "replace", "path": "/c", "value": {"x": 1}},
+		{"op": "copy", "from": "/c", "path": "/copy"},
+		{"op": "move", "from": "/d~1e", "path": "/a/moved"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = doc.ApplyPatch(patch); err != nil {
+		t.Fatal(err)
+	}
+	var expected Map
+	json.Unmarshal([]byte(`{"a": {"b": [10, 2, 3, null], "moved": 5}, "c": {"x": 1}, "copy": {"x": 1}}`), &expected)
+	if !jsonEqual(doc, expected) {
+		t.Fatal(doc)
+	}
+	// copy is not shared
+	doc.PathSet("copy.x", 2)
+	if doc.PathInt("c.x", 0) != 1 {
+		t.Fatal(doc)
+	}
+
+	// failed operation rolls back the whole patch
+	src, _ := json.Marshal(doc)
+	err = doc.ApplyPatch(Patch{{Op: "remove", Path: "/c"}, {Op: "test", Path: "/a/moved", Value: 6}})
+	if res, _ := json.Marshal(doc); err == nil || string(res) != string(src) {
+		t.Fatal(err, string(res))
+	}
+	for _, op := range []PatchOperation{{Op: "add", Path: "/a/b/9", Value: 1}, {Op: "remove", Path: "/none"}, {Op: "move", From: "/a", Path: "/a/b/x"}, {Op: "replace", Path: "/a/b/01", Value: 1}} {
+		if err = doc.ApplyPatch(Patch{op}); err == nil {
+			t.Fatal(op)
+		}
+	}
+	if _, err = ParsePatch([]byte(`[{"op": "add", "path": "/x"}]`)); err == nil {
+		t.Fatal("value is required")
+	}
+
+	// created patch converts documents
+	var from, to Map
+	json.Unmarshal([]byte(`{"a": 1, "b": {"c": [1, 2, 3], "d": "x"}, "e": true}`), &from)
+	json.Unmarshal([]byte(`{"a": 1, "b": {"c": [1, 5], "f": null}, "g/h": [1]}`), &to)
+	patch = CreatePatch(from, to)
+	if err = from.Copy().ApplyPatch(patch); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := ApplyPatch(from, patch)
+	if !jsonEqual(res, to) || len(patch) != 6 {
+		t.Fatal(res, patch)
+	}
+	if src, _ := json.Marshal(patch[0]); string(src) != `{"op":"remove","path":"/e"}` {
+		t.Fatal(string(src))
+	}
+
+	merge := CreateMergePatch(from, to)
+	from.MergePatch(merge)
+	// merge patch can't set null values
+	to.PathDelete("b.f")
+	if !jsonEqual(from, to) {
+		t.Fatal(from, merge)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"required": ["id", "name"],
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"name": {"type": "string", "minLength": 2, "pattern": "^[A-Z]"},
+			"status": {"enum": ["new", "done"]},
+			"price": {"type": "number", "exclusiveMinimum": 0, "multipleOf": 0.01},
+			"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3},
+			"point": {"type": "array", "items": [{"type": "number"}, {"type": "number"}], "additionalItems": false},
+			"contact": {"oneOf": [{"$ref": "#/definitions/phone"}, {"$ref": "#/definitions/email"}]},
+			"children": {"type": "array", "items": {"$ref": "#"}}
+		},
+		"additionalProperties": false,
+		"definitions": {
+			"phone": {"type": "object", "required": ["phone"], "properties": {"phone": {"type": "string", "pattern": "^[0-9]+$"}}},
+			"email": {"type": "object", "required": ["email"], "properties": {"email": {"type": "string"}}}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := Map{"id": 1, "name": "Item", "status": "new", "price": 10.25, "tags": []string{"a", "b"}, "point": []interface{}{1, 2.5},
+		"contact": Map{"phone": "123"}, "children": []interface{}{map[string]interface{}{"id": 2, "name": "Child"}}}
+	if errs := valid.ValidateSchema(schema); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	errs, err := schema.ValidateBytes([]byte(`{
+		"id": 1.5, "status": "old", "price": 0, "tags": ["a", "a", "b", "c"], "point": [1, 2, 3],
+		"contact": {"phone": "1", "email": "a@b"}, "children": [{"id": 0, "name": "x"}], "extra": true
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(map[string]string)
+	for _, e := range errs {
+		paths[e.Path+" "+e.Keyword] = e.Message
+	}
+	for _, key := range []string{
+		"/id type", "/name required", "/status enum", "/price exclusiveMinimum", "/tags maxItems", "/tags/1 uniqueItems",
+		"/point/2 items", "/contact oneOf", "/children/0/id minimum", "/children/0/name minLength", "/children/0/name pattern",
+		"/extra additionalProperties",
+	} {
+		if _, check := paths[key]; !check {
+			t.Error(key, errs)
+		}
+	}
+	if len(errs) != 12 {
+		t.Fatal(errs)
+	}
+	if _, err = ParseSchema([]byte(`{"properties": {"a": {"$ref": "#/definitions/none"}}}`)); err == nil {
+		t.Fatal("ref error expected")
+	}
+	// reference cycles without the nested value
+	for _, src := range []string{
+		`{"$ref": "#"}`,
+		`{"$ref": "#/definitions/a", "definitions": {"a": {"$ref": "#/definitions/a"}}}`,
+		`{"definitions": {"a": {"allOf": [{"$ref": "#/definitions/b"}]}, "b": {"not": {"$ref": "#/definitions/a"}}}, "properties": {"x": {"$ref": "#/definitions/a"}}}`,
+	} {
+		if _, err = ParseSchema([]byte(src)); err == nil || !strings.Contains(err.Error(), "recursive") {
+			t.Error(src, err)
+		}
+	}
+	// error of the earlier keyword is not replaced by the tuple items
+	if _, err = ParseSchema([]byte(`{"pattern": "(", "items": [{"type": "string"}]}`)); err == nil || !strings.Contains(err.Error(), "/pattern") {
+		t.Fatal("pattern error expected", err)
+	}
+	schema, _ = NewSchema(Map{"if": Map{"properties": Map{"kind": Map{"const": "a"}}}, "then": Map{"required": []interface{}{"a"}}, "else": false})
+	if errs = schema.Validate(Map{"kind": "a"}); len(errs) != 1 || errs[0].Error() != "/a: required: value is required" {
+		t.Fatal(errs)
+	}
+	if errs = schema.Validate(Map{"kind": "b"}); len(errs) != 1 {
+		t.Fatal(errs)
+	}
+}
+
+func TestDeepMerge(t *testing.T) {
+	var defaults, overrides Map
+	json.Unmarshal([]byte(`{"server": {"host": "localhost", "port": 80, "tags": ["a"]}, "users": [{"name": "admin", "role": "admin"}, {"name": "guest"}], "debug": false}`), &defaults)
+	json.Unmarshal([]byte(`{"server": {"port": 8080, "tags": ["b"]}, "users": [{"name": "guest", "role": "user"}, {"name": "new"}], "debug": true}`), &overrides)
+
+	copied := defaults.DeepCopy()
+	copied.PathSet("server.port", 1)
+	copied.PathSet("users[0].name", "root")
+	if defaults.PathInt("server.port", 0) != 80 || defaults.PathString("users[0].name", "") != "admin" {
+		t.Fatal(defaults)
+	}
+
+	res := defaults.DeepMerge(overrides, MergeOptions{})
+	if res.PathInt("server.port", 0) != 8080 || res.PathString("server.host", "") != "localhost" || len(res.PathSlice("server.tags", nil)) != 1 || len(res.PathSlice("users", nil)) != 2 || !res.Bool("debug", false) {
+		t.Fatal(res)
+	}
+	// sources are not changed
+	if defaults.PathInt("server.port", 0) != 80 || overrides.PathExists("server.host") {
+		t.Fatal(defaults, overrides)
+	}
+
+	res = defaults.DeepMerge(overrides, MergeOptions{Slices: SliceAppend, Conflicts: ConflictLeftWins})
+	if res.PathInt("server.port", 0) != 80 || res.PathString("server.tags[1]", "") != "b" || len(res.PathSlice("users", nil)) != 4 || res.Bool("debug", true) {
+		t.Fatal(res)
+	}
+
+	var conflicts []string
+	res = defaults.DeepMerge(overrides, MergeOptions{Slices: SliceMergeByKey, SliceKey: "name", Conflicts: ConflictCallback,
+		Resolve: func(path string, left, right interface{}) interface{} {
+			conflicts = append(conflicts, path)
+			return right
+		},
+	})
+	if len(res.PathSlice("users", nil)) != 3 || res.PathString("users[1].role", "") != "user" || res.PathString("users[2].name", "") != "new" {
+		t.Fatal(res)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"/debug", "/server/port"}) && !reflect.DeepEqual(conflicts, []string{"/server/port", "/debug"}) {
+		t.Fatal(conflicts)
+	}
+}
+
+func TestGetters(t *testing.T) {
+	var m Map
+	json.Unmarshal([]byte(`{"int": 10, "float": 10.5, "bool": true, "str": "text", "time": "2021-03-04T05:06:07Z", "unix": 1600000000,
+		"duration": "1m30s", "seconds": 2.5, "map": {"a": 1}, "maps": [{"a": 1}, {"b": 2}], "mixed": [{"a": 1}, 2], "strings": ["a", "b"], "null": null}`), &m)
+	var ptr *int
+	m["ptr"] = ptr
+	if m.Int32("ptr", 5) != 5 || m.Int32("float", 0) != 10 || m.Int32("str", 3) != 3 || m.Int("null", 7) != 7 {
+		t.Fatal("default getters")
+	}
+	if v, err := m.GetInt("int"); err != nil || v != 10 {
+		t.Fatal(v, err)
+	}
+	if _, err := m.GetInt("float"); err == nil || err.Error() != `json key "float": expected integer, not float64 (10.5)` {
+		t.Fatal(err)
+	}
+	if _, err := m.GetInt("none"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatal(err)
+	}
+	if _, err := m.GetString("int"); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Fatal(err)
+	}
+	if v, err := m.GetFloat("float"); err != nil || v != 10.5 {
+		t.Fatal(v, err)
+	}
+	if v, err := m.GetBool("bool"); err != nil || !v {
+		t.Fatal(v, err)
+	}
+	if v, err := m.GetTime("time", ""); err != nil || v.Month() != time.March {
+		t.Fatal(v, err)
+	}
+	if v, err := m.GetTime("unix", ""); err != nil || v.Unix() != 1600000000 {
+		t.Fatal(v, err)
+	}
+	if _, err := m.GetTime("str", "2006-01-02"); err == nil {
+		t.Fatal("time parse error expected")
+	}
+	if v, err := m.GetDuration("duration"); err != nil || v != 90*time.Second {
+		t.Fatal(v, err)
+	}
+	if v, err := m.GetDuration("seconds"); err != nil || v != 2500*time.Millisecond {
+		t.Fatal(v, err)
+	}
+	if v, err := m.GetMap("map"); err != nil || v.Int("a", 0) != 1 {
+		t.Fatal(v, err)
+	}
+	if v, err := m.GetMaps("maps"); err != nil || len(v) != 2 || v[1].Int("b", 0) != 2 {
+		t.Fatal(v, err)
+	}
+	if _, err := m.GetMaps("mixed"); err == nil || err.Error() != `json key "mixed[1]": expected object, not float64 (2)` {
+		t.Fatal(err)
+	}
+	if v, err := m.GetStrings("strings"); err != nil || !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Fatal(v, err)
+	}
+	if v, err := m.GetSlice("maps"); err != nil || len(v) != 2 {
+		t.Fatal(v, err)
+	}
+	if _, err := m.GetMap("null"); err == nil {
+		t.Fatal("null is not a map")
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	src := "{\n  \"id\": 1,\n  \"embedded\": {\"id\": 2, \"name\": 5},\n  \"intervals\": []\n}"
+	var obj TObject
+	err := DecodeBytes([]byte(src), &obj)
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) {
+		t.Fatal(err)
+	}
+	if decErr.Line != 3 || decErr.Column != 33 || decErr.Path != "/embedded/name" || decErr.Expected != "string" || decErr.Actual != "number" {
+		t.Fatal(decErr)
+	}
+	if err.Error() != "json: line 3, column 33 (offset 45), path /embedded/name: expected string, not number" {
+		t.Fatal(err)
+	}
+
+	// token type error in the array of raw structs
+	var list []*Tagged
+	err = DecodeBytes([]byte("[\n{\"name\": \"a\"},\n{\"objects\": {\"x\": [1]}}\n]"), &list)
+	if !errors.As(err, &decErr) || decErr.Path != "/1/objects/x" || decErr.Expected != "object" || decErr.Actual != "array" || decErr.Line != 3 || decErr.Column != 19 {
+		t.Fatal(err)
+	}
+
+	// syntax error
+	err = DecodeBytes([]byte("{\n\"id\": 1,\n\"name\": tru\n}"), &obj)
+	if !errors.As(err, &decErr) || decErr.Line != 3 || decErr.Column != 12 || decErr.Path != "/name" {
+		t.Fatal(err)
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatal(err)
+	}
+	var ints []int
+	err = DecodeBytes([]byte("[1,\n 2 x]"), &ints)
+	if !errors.As(err, &decErr) || decErr.Line != 2 || decErr.Column != 4 {
+		t.Fatal(err)
+	}
+	// path errors of the array iteration have location
+	dec := InitJSONDecoderFromSource([]byte("{\n\"data\": {\"items\": 5}}"))
+	err = dec.ArrayEach([]string{"data", "items"}, nil)
+	if !errors.As(err, &decErr) || decErr.Line != 2 || decErr.Column != 19 || decErr.Path != "/data/items" || decErr.Expected != "array" || decErr.Actual != "number" {
+		t.Fatal(err)
+	}
+	dec = InitJSONDecoderFromSource([]byte("{\n\"data\": {\"list\": []}}"))
+	err = dec.ArrayEach([]string{"data", "items"}, nil)
+	if !errors.As(err, &decErr) || decErr.Line != 2 || !strings.Contains(err.Error(), "NOT FOUND") {
+		t.Fatal(err)
+	}
+	dec = InitJSONDecoderFromSource([]byte("[\n{\"id\": 1}, {\"id\": 2}]"))
+	err = dec.ArrayEach(nil, func(index int, dec *JSONDecoder) (bool, error) {
+		_, err := dec.Token()
+		return true, err
+	})
+	if !errors.As(err, &decErr) || decErr.Line != 2 || decErr.Path != "/0" {
+		t.Fatal(err)
+	}
+}
+
+type bindTarget struct {
+	Tagged
+	Port     int               `json:"port"`
+	Ratio    float32           `json:"ratio"`
+	Enabled  bool              `json:"enabled"`
+	Interval *TimeInterval     `json:"interval"`
+	Nested   TaggedNested      `json:"nested"`
+	Items    []TimeInterval    `json:"items"`
+	Labels   map[string]string `json:"labels"`
+	Extra    Map               `json:"extra"`
+}
+
+func TestBind(t *testing.T) {
+	m := Map{
+		"id":       15,
+		"created":  "2020-01-02T03:04:05Z",
+		"name":     "bind",
+		"port":     "5060",
+		"ratio":    0.5,
+		"enabled":  "true",
+		"interval": `{"Start": 1, "Finish": 2}`,
+		"nested":   map[string]interface{}{"Value": 10},
+		"items":    []interface{}{Map{"Start": "3"}, Map{"Start": 4, "Finish": "x"}, "y"},
+		"labels":   Map{"a": "b", "c": 5},
+		"extra":    Map{"k": []interface{}{1}},
+		"unknown":  true,
+	}
+	var target bindTarget
+	err := m.Bind(&target)
+	var bindErr *BindError
+	if !errors.As(err, &bindErr) || len(bindErr.Fields) != 2 || bindErr.Fields[0].Key != "items[1].Finish" || bindErr.Fields[1].Key != "items[2]" {
+		t.Fatal(err)
+	}
+	if target.ID != 15 || target.Created.Year() != 2020 || target.Name != "bind" || target.Port != 5060 || target.Ratio != 0.5 || !target.Enabled {
+		t.Fatal(target)
+	}
+	if target.Interval == nil || target.Interval.Finish != 2 || target.Nested.Value != "10" || len(target.Items) != 3 || target.Items[0].Start != 3 || target.Items[1].Start != 4 {
+		t.Fatal(target)
+	}
+	if target.Labels["c"] != "5" || len(target.Extra.Slice("k", nil)) != 1 {
+		t.Fatal(target)
+	}
+	if err = m.Bind(target); err == nil {
+		t.Fatal("expected pointer error")
+	}
+	// numbers are not wrapped or truncated
+	var nums struct {
+		Small int8
+		U     uint
+		I     int
+		S     uint8
+		F     float32
+	}
+	err = Map{"Small": 300.0, "U": -1.0, "I": 42.7, "S": "256", "F": 1e300}.Bind(&nums)
+	if !errors.As(err, &bindErr) || len(bindErr.Fields) != 5 || nums.Small != 0 || nums.U != 0 || nums.I != 0 || nums.S != 0 || nums.F != 0 {
+		t.Fatal(err, nums)
+	}
+	err = Map{"Small": 300, "U": "-1", "I": uint64(1 << 63), "S": -1, "F": "1e300"}.Bind(&nums)
+	if !errors.As(err, &bindErr) || len(bindErr.Fields) != 5 || nums.Small != 0 || nums.U != 0 || nums.I != 0 || nums.S != 0 || nums.F != 0 {
+		t.Fatal(err, nums)
+	}
+	if err = (Map{"Small": -128.0, "U": "7", "I": 42.0, "S": json.Number("255")}).Bind(&nums); err != nil || nums.Small != -128 || nums.U != 7 || nums.I != 42 || nums.S != 255 {
+		t.Fatal(err, nums)
+	}
+}
+
+type shape interface {
+	Area() float64
+}
+
+type circle struct {
+	R float64 `json:"r"`
+}
+
+func (s circle) Area() float64 { return 3 * s.R * s.R }
+
+type rect struct {
+	Kind string `json:"kind"`
+	W, H float64
+}
+
+func (s *rect) Area() float64 { return s.W * s.H }
+
+type drawing struct {
+	Main   shape            `json:"main"`
+	Shapes []shape          `json:"shapes"`
+	Named  map[string]shape `json:"named"`
+	Empty  shape            `json:"empty"`
+}
+
+func TestDiscriminator(t *testing.T) {
+	RegisterInterface((*shape)(nil), "kind").Register("circle", circle{}).Register("rect", &rect{})
+	src := `{
+		"main": {"r": 1, "kind": "circle"},
+		"shapes": [{"kind": "rect", "W": 2, "H": 3}, {"kind": "circle", "r": 2}],
+		"named": {"x": {"kind": "rect", "W": 1, "H": 1}},
+		"empty": null
+	}`
+	var d drawing
+	if err := DecodeBytes([]byte(src), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Main.Area() != 3 || len(d.Shapes) != 2 || d.Shapes[0].Area() != 6 || d.Shapes[1].(circle).R != 2 || d.Named["x"].(*rect).Kind != "rect" || d.Empty != nil {
+		t.Fatal(d)
+	}
+	d.Shapes[0].(*rect).Kind = ""
+	res, err := EncodeBytes(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"main":{"kind":"circle","r":1},"shapes":[{"kind":"","W":2,"H":3},{"kind":"circle","r":2}],"named":{"x":{"kind":"rect","W":1,"H":1}},"empty":null}`
+	if string(res) != expected {
+		t.Fatal(string(res))
+	}
+	err = DecodeBytes([]byte(`{"shapes": [{"kind": "circle", "r": 1}, {"kind": "line"}]}`), &d)
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) || decErr.Path != "/shapes/1" || decErr.Column != 41 {
+		t.Fatal(err)
+	}
+	err = DecodeBytes([]byte(`{"main": {"kind": "circle", "r": "x"}}`), &d)
+	if !errors.As(err, &decErr) || decErr.Path != "/main/r" || decErr.Column != 34 || decErr.Expected != "float64" {
+		t.Fatal(err)
+	}
+}
+
+func TestLines(t *testing.T) {
+	src := "{\"id\": 1}\n\n  \r\n{\"id\": 2, \"name\": \"x\"}\r\n{\"id\": \nnull\n{\"id\": 3} 5\n{\"id\": 4, \"name\": \"" + strings.Repeat("a", 5000) + "\"}\n{\"id\": 5}"
+	r := NewLinesReader(strings.NewReader(src))
+	r.SetMaxLineSize(4096)
+	var ids []int64
+	for {
+		m, err := r.Map()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			var lineErr *LineError
+			if !errors.As(err, &lineErr) || lineErr.Line != 5 {
+				t.Fatal(err)
+			}
+			r.SkipInvalid(true)
+			continue
+		}
+		ids = append(ids, m.Int("id", 0))
+	}
+	if len(ids) != 4 || ids[0] != 1 || ids[1] != 2 || ids[2] != 0 || ids[3] != 5 {
+		t.Fatal(ids)
+	}
+	skipped := r.Skipped()
+	if len(skipped) != 2 || skipped[0].Line != 7 || skipped[1].Line != 8 || !errors.Is(skipped[1], ErrLineTooLong) || r.Line() != 9 {
+		t.Fatal(skipped, r.Line())
+	}
+
+	var buf bytes.Buffer
+	w := NewLinesWriter(&buf)
+	if err := w.Encode(Map{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Encode(func() {}); err == nil {
+		t.Fatal("expected encode error")
+	}
+	if err := w.WriteRaw([]byte("{\n  \"id\": 2\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("expected buffered lines")
+	}
+	if err := w.Flush(); err != nil || buf.String() != "{\"id\":1}\n{\"id\":2}\n" {
+		t.Fatal(err, buf.String())
+	}
+}
+
+// writesRecorder stores the chunks of the writes
+type writesRecorder struct {
+	writes [][]byte
+}
+
+func (s *writesRecorder) Write(p []byte) (int, error) {
+	s.writes = append(s.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestLinesWriterLongLine(t *testing.T) {
+	rec := &writesRecorder{}
+	w := NewLinesWriter(rec)
+	long := `"` + strings.Repeat("a", 10000) + `"`
+	w.Encode(1)
+	src := []byte(long)
+	w.WriteRaw(src)
+	w.Encode(2)
+	w.Flush()
+	// every write ends with the complete line
+	if len(rec.writes) != 3 || string(rec.writes[0]) != "1\n" || string(rec.writes[1]) != long+"\n" || string(rec.writes[2]) != "2\n" || string(src) != long {
+		t.Fatal(len(rec.writes))
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	// RFC 8785 examples
+	var m Map
+	json.Unmarshal([]byte(`{"numbers":[333333333.33333329,1E30,4.50,2e-3,0.000000000000000000000000001],"string":"\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/","literals":[null,true,false]}`), &m)
+	res, err := m.Canonical()
+	if expected := `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`; err != nil || string(res) != expected {
+		t.Fatal(err, string(res))
+	}
+	dec := json.NewDecoder(strings.NewReader(`{"\u20ac": 1, "\r": -0, "\ufb33": 3, "1": 1e21, "\ud83d\ude00": 5, "\u0080": 6, "\u00f6": 7.0}`))
+	dec.UseNumber()
+	m = nil
+	dec.Decode(&m)
+	res, err = Canonical(m)
+	if expected := "{\"\\r\":0,\"1\":1e+21,\"\u0080\":6,\"\u00f6\":7,\"\u20ac\":1,\"\U0001F600\":5,\"\ufb33\":3}"; err != nil || string(res) != expected {
+		t.Fatal(err, string(res))
+	}
+	// other types are normalized
+	res, err = Canonical(struct {
+		B []int `json:"b"`
+		A Map   `json:"a"`
+	}{B: []int{1, 2}, A: Map{"y": float32(0.1), "x": "<>"}})
+	if err != nil || string(res) != `{"a":{"x":"<>","y":0.1},"b":[1,2]}` {
+		t.Fatal(err, string(res))
+	}
+	if _, err = Canonical(Map{"nan": math.NaN()}); err == nil {
+		t.Fatal("expected NaN error")
+	}
+
+	h1, _ := Map{"a": 1, "b": []interface{}{"x"}}.Hash(sha256.New)
+	h2, _ := Hash(map[string]interface{}{"b": []interface{}{"x"}, "a": 1.0}, sha256.New)
+	if !bytes.Equal(h1, h2) || hex.EncodeToString(h1) != fmt.Sprintf("%x", sha256.Sum256([]byte(`{"a":1,"b":["x"]}`))) {
+		t.Fatal(hex.EncodeToString(h1), hex.EncodeToString(h2))
+	}
+	mac, _ := m.HMAC(sha256.New, []byte("secret"))
+	if check, err := VerifyHMAC(m, sha256.New, []byte("secret"), mac); !check || err != nil {
+		t.Fatal(check, err)
+	}
+	if check, _ := VerifyHMAC(m, sha256.New, []byte("other"), mac); check {
+		t.Fatal("expected HMAC mismatch")
+	}
+}
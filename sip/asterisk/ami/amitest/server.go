@@ -0,0 +1,241 @@
+// Package amitest provides a local AMI server for tests of the ami based packages
+package amitest
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami/internal/protocol"
+)
+
+// DefaultBanner is the greeting of the asterisk 16 server
+const DefaultBanner = "Asterisk Call Manager/5.0.1"
+
+// HandlerFunc returns answer on the action. If answer action is not an event,
+// the request ActionID is set automatically
+type HandlerFunc func(req ami.ActionData) []ami.ActionData
+
+// Response returns handler answering with single response
+func Response(fields ami.ActionData) HandlerFunc {
+	return func(req ami.ActionData) []ami.ActionData {
+		res := make(ami.ActionData, len(fields))
+		for key, val := range fields {
+			res[key] = val
+		}
+		return []ami.ActionData{res}
+	}
+}
+
+// List returns handler answering with event list. The list item events and the
+// complete event receive the request ActionID
+func List(itemEvent, completeEvent string, items ...ami.ActionData) HandlerFunc {
+	return func(req ami.ActionData) []ami.ActionData {
+		res := []ami.ActionData{{"Response": "Success", "EventList": "start", "Message": "Events will follow"}}
+		for _, item := range items {
+			e := ami.ActionData{"Event": itemEvent, "ActionID": req.ActionID()}
+			for key, val := range item {
+				e[key] = val
+			}
+			res = append(res, e)
+		}
+		return append(res, ami.ActionData{
+			"Event":     completeEvent,
+			"ActionID":  req.ActionID(),
+			"EventList": "Complete",
+			"ListItems": strconv.Itoa(len(items)),
+		})
+	}
+}
+
+type conn struct {
+	net.Conn
+	locker sync.Mutex
+}
+
+func (s *conn) write(data []byte) {
+	s.locker.Lock()
+	s.Conn.Write(data)
+	s.locker.Unlock()
+}
+
+// NewServer starts server on the random local port.
+// Login action is accepted for any user, CoreSettings and Ping actions are answered
+// as asterisk 16, other actions without handler are answered by error
+func NewServer(banner string) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{
+		ln:       ln,
+		banner:   banner,
+		handlers: make(map[string]HandlerFunc),
+		locker:   new(sync.Mutex),
+	}
+	s.Handle("Login", Response(ami.ActionData{"Response": "Success", "Message": "Authentication accepted"}))
+	s.Handle("CoreSettings", Response(ami.ActionData{"Response": "Success", "AMIversion": "5.0.1", "AsteriskVersion": "16.2.1"}))
+	s.Handle("Ping", Response(ami.ActionData{"Response": "Success", "Ping": "Pong"}))
+	s.Handle("Logoff", Response(ami.ActionData{"Response": "Goodbye", "Message": "Thanks for all the fish."}))
+	go s.accept()
+	return s
+}
+
+// Server is the local AMI server
+type Server struct {
+	ln       net.Listener
+	banner   string
+	handlers map[string]HandlerFunc
+	conns    []*conn
+	received []ami.ActionData
+	locker   *sync.Mutex
+}
+
+// Addr returns server address
+func (s *Server) Addr() string { return s.ln.Addr().String() }
+
+// Handle setup action handler. Action name is case insensitive
+func (s *Server) Handle(action string, h HandlerFunc) {
+	s.locker.Lock()
+	s.handlers[strings.ToLower(action)] = h
+	s.locker.Unlock()
+}
+
+// Send writes action (usually an event) to all connected clients
+func (s *Server) Send(action ami.ActionData) {
+	s.locker.Lock()
+	conns := append([]*conn(nil), s.conns...)
+	s.locker.Unlock()
+	data, err := protocol.Encode(action)
+	if err != nil {
+		panic(err)
+	}
+	for _, c := range conns {
+		c.write(data)
+	}
+}
+
+// Received returns all received actions
+func (s *Server) Received() (res []ami.ActionData) {
+	s.locker.Lock()
+	res = append(res, s.received...)
+	s.locker.Unlock()
+	return
+}
+
+// ConnCount returns count of the connected clients
+func (s *Server) ConnCount() (res int) {
+	s.locker.Lock()
+	res = len(s.conns)
+	s.locker.Unlock()
+	return
+}
+
+// Close stops server and closes all connections
+func (s *Server) Close() {
+	s.ln.Close()
+	s.locker.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+	s.locker.Unlock()
+}
+
+func (s *Server) accept() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: nc}
+		s.locker.Lock()
+		s.conns = append(s.conns, c)
+		s.locker.Unlock()
+		go s.serve(c)
+	}
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.locker.Lock()
+	for i, v := range s.conns {
+		if v == c {
+			s.conns = append(s.conns[:i], s.conns[i+1:]...)
+			break
+		}
+	}
+	s.locker.Unlock()
+	c.Close()
+}
+
+func (s *Server) serve(c *conn) {
+	defer s.removeConn(c)
+	c.write([]byte(s.banner + "\r\n"))
+	var data []byte
+	buf := make([]byte, 4096)
+	for {
+		count, err := c.Read(buf)
+		if err != nil {
+			return
+		}
+		data = protocol.Parse(append(data, buf[:count]...), func(req map[string]string) {
+			s.locker.Lock()
+			s.received = append(s.received, req)
+			h, check := s.handlers[strings.ToLower(req["Action"])]
+			s.locker.Unlock()
+			var answer []ami.ActionData
+			if check {
+				answer = h(ami.ActionData(req))
+			} else {
+				answer = []ami.ActionData{{"Response": "Error", "Message": "Invalid/unknown command"}}
+			}
+			var raw []byte
+			for _, action := range answer {
+				if _, event := action["Event"]; !event && req["ActionID"] != "" {
+					action["ActionID"] = req["ActionID"]
+				}
+				src, err := protocol.Encode(action)
+				if err != nil {
+					panic(err)
+				}
+				raw = append(raw, src...)
+			}
+			c.write(raw)
+		})
+	}
+}
+
+// StartClient connects client to the server and waits authorization
+func StartClient(addr string) (*ami.Client, error) {
+	auth := make(chan error, 1)
+	cl := ami.New(addr, "admin", "secret", nil, func(state ami.State, err error) {
+		switch state {
+		case ami.StateAuth:
+			auth <- nil
+		case ami.StateStopped:
+			if err == nil {
+				err = errors.New("client stopped")
+			}
+			select {
+			case auth <- err:
+			default:
+			}
+		}
+	})
+	go cl.Start()
+	select {
+	case err := <-auth:
+		if err != nil {
+			cl.Close()
+			return nil, err
+		}
+		return cl, nil
+	case <-time.After(time.Second * 5):
+		cl.Close()
+		return nil, errors.New("client auth timeout")
+	}
+}
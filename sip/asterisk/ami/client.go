@@ -1,14 +1,14 @@
 package ami
 
 import (
-	"container/list"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -50,14 +50,13 @@ func New(host, login, password string, ctxGlobal context.Context, stateChanged f
 			password:       password,
 			stateChanged:   stateChanged,
 			state:          StateStopped,
-			request:        make(chan Request),
-			response:       make(chan Response),
-			event:          make(chan Event),
-			requestsWork:   list.New(),
-			socketClosed:   make(chan error),
+			event:          make(chan Event, eventQueueSize),
+			pending:        make(map[string]*pendingRequest),
+			pendingLocker:  new(sync.Mutex),
 			actionIDPrefix: fmt.Sprint(time.Now().UnixNano()),
-			eventListeners: make(map[int64]*EventListener),
+			listeners:      newListenerRegistry(),
 			locker:         new(sync.RWMutex),
+			shutdownChan:   make(chan struct{}),
 		},
 	}
 	if ctxGlobal == nil {
@@ -65,7 +64,8 @@ func New(host, login, password string, ctxGlobal context.Context, stateChanged f
 	} else {
 		cl.ctx, cl.ctxCancel = context.WithCancel(ctxGlobal)
 	}
-	go cl.eventListenersCleaner()
+	cl.goroutines.Add(1)
+	go cl.eventLoop()
 	runtime.SetFinalizer(cl, destroyClient)
 	return
 }
@@ -82,139 +82,219 @@ type client struct {
 	login           string
 	password        string
 	conn            net.Conn
-	request         chan Request
-	response        chan Response
 	event           chan Event
 	clientSideEvent chan Event
 	stateChanged    func(State, error)
 	state           State
-	requestsWork    *list.List
-	socketClosed    chan error
+	pending         map[string]*pendingRequest
+	unsent          []*pendingRequest
+	session         *session
+	pendingLocker   *sync.Mutex
 	actionIDPrefix  string
 	actionUUID      uint64
-	eventListeners  map[int64]*EventListener
+	listeners       *listenerRegistry
 	locker          *sync.RWMutex
+	amiVersion      Version
+	version         Version
+	dialect         *Dialect
+	shutdownChan    chan struct{}
+	shuttingDown    bool
+	eventClosed     bool
+	inflight        sync.WaitGroup // requests accepted before shutdown
+	goroutines      sync.WaitGroup
+	keepalive       KeepaliveConfig
+	latency         time.Duration
+	lastPong        time.Time
 }
 
-func (s *client) State() State {
-	return s.state
+func (s *client) State() (res State) {
+	s.locker.RLock()
+	res = s.state
+	s.locker.RUnlock()
+	return
 }
 
-func (s *client) removeEventListener(uuid int64) {
-	s.locker.Lock()
-	if listener, check := s.eventListeners[uuid]; check {
-		listener.close()
-		delete(s.eventListeners, uuid)
+// AMIVersion returns ami protocol version received from the greeting banner
+func (s *client) AMIVersion() (res Version) {
+	s.locker.RLock()
+	res = s.amiVersion
+	s.locker.RUnlock()
+	return
+}
+
+// Version returns asterisk server version detected after login
+func (s *client) Version() (res Version) {
+	s.locker.RLock()
+	res = s.version
+	s.locker.RUnlock()
+	return
+}
+
+// Dialect returns protocol dialect of the connected server.
+// Before the first login the modern dialect will be returned
+func (s *client) Dialect() (res *Dialect) {
+	s.locker.RLock()
+	if res = s.dialect; res == nil {
+		res = DialectForVersion(Version{})
 	}
+	s.locker.RUnlock()
+	return
+}
+
+func (s *client) setVersion(amiVersion, version Version) {
+	s.locker.Lock()
+	s.amiVersion, s.version, s.dialect = amiVersion, version, DialectForVersion(version)
 	s.locker.Unlock()
 }
 
-func (s *client) eventListenersCleaner() {
-	ctx, _ := context.WithCancel(s.ctx)
+// detectVersion reads asterisk version using CoreSettings action.
+// If action is not permitted, asterisk version is calculated from ami protocol version
+func (s *client) detectVersion(sess *session, amiVersion Version) (err error) {
+	version := asteriskVersionFromAMI(amiVersion)
+	req := InitRequest("CoreSettings")
+	req.ActionData["ActionID"] = s.InitActionID()
+	err = sess.sendSingle(req, func(action ActionData) {
+		if action.isEvent() {
+			// events are normalized by the receive loop with the detected dialect
+			sess.received = append(sess.received, action)
+		} else if resp := (Response{action}); !resp.IsError() {
+			if v := ParseVersion(action["AsteriskVersion"]); v.IsValid() {
+				version = v
+			}
+			if v := ParseVersion(action["AMIversion"]); v.IsValid() && !amiVersion.IsValid() {
+				amiVersion = v
+			}
+		}
+	})
+	s.setVersion(amiVersion, version)
+	return
+}
+
+// eventLoop sends received events to the client side channel and call listeners.
+// After the client context is done all event listeners and the client side channel are closed
+func (s *client) eventLoop() {
+	defer s.goroutines.Done()
 	for {
 		select {
-		case <-time.After(time.Minute * 30):
-			{
-				now := time.Now()
-				s.locker.Lock()
-				for uuid, v := range s.eventListeners {
-					if now.After(v.timeActual) {
-						v.close()
-						delete(s.eventListeners, uuid)
-					}
-				}
-				s.locker.Unlock()
-			}
-		case <-ctx.Done():
-			{
-				for _, v := range s.eventListeners {
-					v.close()
-				}
-				return
+		case event := <-s.event:
+			s.eventAccepted(event)
+		case <-s.ctx.Done():
+			s.listeners.closeAll()
+			// event loop is the only sender of the client side channel
+			s.locker.Lock()
+			if s.clientSideEvent != nil {
+				close(s.clientSideEvent)
 			}
+			s.eventClosed = true
+			s.locker.Unlock()
+			return
 		}
 	}
 }
 
-func (s *client) registerEventListener(uuid int64) <-chan Event {
-	listener := &EventListener{
-		uuid:      uuid,
-		eventChan: make(chan Event),
+// ListenUniqueid registers listener of the single channel events
+func (s *client) ListenUniqueid(uniqueid string, config ListenerConfig) *EventListener {
+	listener := newEventListener(ListenUniqueid, uniqueid, config)
+	s.listeners.add(listener)
+	return listener
+}
+
+// ListenLinkedid registers listener of the events of all call legs
+func (s *client) ListenLinkedid(linkedid string, config ListenerConfig) *EventListener {
+	listener := newEventListener(ListenLinkedid, linkedid, config)
+	s.listeners.add(listener)
+	return listener
+}
+
+// ListenChannel registers listener of the channels matched with name pattern.
+// Symbol '*' in the pattern matches any sequence of characters, '?' matches any single character.
+// Example: "PJSIP/trunk-*"
+func (s *client) ListenChannel(pattern string, config ListenerConfig) (*EventListener, error) {
+	expr, err := globRegexp(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("AMI channel pattern error: %v", err.Error())
 	}
-	s.locker.Lock()
-	s.eventListeners[uuid] = listener
-	s.locker.Unlock()
-	return listener.eventChan
+	listener := newEventListener(ListenChannel, pattern, config)
+	listener.pattern = expr
+	s.listeners.add(listener)
+	return listener, nil
 }
 
-func (s *client) initActionID() (res string) {
-	res = fmt.Sprintf("%v%v", s.actionIDPrefix, s.actionUUID)
-	if s.actionUUID < max_client_uuid {
-		s.actionUUID++
-	} else {
-		s.actionUUID = 0
+// ListenEvents registers listener of the events with defined names, for example
+// ContactStatus and PeerStatus. Names are case insensitive
+func (s *client) ListenEvents(names []string, config ListenerConfig) *EventListener {
+	listener := newEventListener(ListenEvent, strings.Join(names, ","), config)
+	listener.names = make(map[string]bool, len(names))
+	for _, name := range names {
+		listener.names[strings.ToLower(name)] = true
 	}
-	return
+	s.listeners.add(listener)
+	return listener
 }
 
-func (s *client) requestByActionID(actionID string) (req Request, elem *list.Element, check bool) {
-	for elem = s.requestsWork.Front(); elem != nil; elem = elem.Next() {
-		req = elem.Value.(Request)
-		if req.ActionID() == actionID {
-			check = true
-			return
-		}
+// ListenActionID registers listener of the asynchronous events of the request with ActionID,
+// for example OriginateResponse. The ActionID should be issued by InitActionID
+func (s *client) ListenActionID(actionID string, config ListenerConfig) *EventListener {
+	listener := newEventListener(ListenActionID, actionID, config)
+	s.listeners.add(listener)
+	return listener
+}
+
+// InitActionID returns the new unique ActionID of the client requests. Used to know ActionID
+// of the request before the send, for example to match asynchronous events like OriginateResponse
+func (s *client) InitActionID() string {
+	return fmt.Sprintf("%v%v", s.actionIDPrefix, atomic.AddUint64(&s.actionUUID, 1))
+}
+
+// requestActionID returns ActionID of the pending request. ActionID of the request is used only if it is
+// issued by InitActionID, other values are replaced and restored in the response
+func (s *client) requestActionID(req Request) string {
+	if actionID := req.ActionData["ActionID"]; strings.HasPrefix(actionID, s.actionIDPrefix) {
+		return actionID
 	}
-	return
+	return s.InitActionID()
 }
 
 func (s *client) setState(state State, err error) {
+	s.locker.Lock()
 	oldState := s.state
 	s.state = state
+	s.locker.Unlock()
 	if s.stateChanged != nil && (state != oldState || err != nil) {
 		s.stateChanged(state, err)
 	}
-	s.state = state
 }
 
-func (s *client) Event() chan Event {
+// Event returns channel of the client events. Channel is closed after the client stop
+func (s *client) Event() (res chan Event) {
+	s.locker.Lock()
 	if s.clientSideEvent == nil {
 		s.clientSideEvent = make(chan Event)
+		if s.eventClosed {
+			close(s.clientSideEvent)
+		}
 	}
-	return s.clientSideEvent
+	res = s.clientSideEvent
+	s.locker.Unlock()
+	return
 }
 
 func (s *client) eventAccepted(event Event) {
-	switch event.Name() {
-	case "FullyBooted":
-		{
-			if s.state == StateAuth {
-				for elem := s.requestsWork.Front(); elem != nil; elem.Next() {
-					s.sendQueueRequest()
-				}
-			}
-		}
-	}
-
 	// send event to client side
-	if s.clientSideEvent != nil {
-		s.clientSideEvent <- event
+	s.locker.RLock()
+	clientSideEvent := s.clientSideEvent
+	s.locker.RUnlock()
+	if clientSideEvent != nil {
+		select {
+		case clientSideEvent <- event:
+		case <-s.ctx.Done():
+			return
+		}
 	}
 
-	if event.uuid > 0 {
-		var check bool
-		var listener *EventListener
-		s.locker.RLock()
-		if listener, check = s.eventListeners[event.uuid]; check {
-			listener.incomingEvent(event)
-		}
-		s.locker.RUnlock()
-		if check && event.Name() == "Hangup" {
-			s.locker.Lock()
-			listener.close()
-			delete(s.eventListeners, event.uuid)
-			s.locker.Unlock()
-		}
+	// send event to call listeners
+	for _, listener := range s.listeners.match(event) {
+		listener.incomingEvent(event)
 	}
 }
 
@@ -223,31 +303,67 @@ func (s *client) Start() {
 
 	var err error
 
-	// check state. StateStopped needed
+	// check client is not closed and state is StateStopped
+	s.locker.Lock()
+	if s.shuttingDown || s.ctx.Err() != nil {
+		state := s.state
+		s.locker.Unlock()
+		if s.stateChanged != nil {
+			s.stateChanged(state, ErrClosed)
+		}
+		return
+	}
 	if s.state != StateStopped {
-		err = errors.New("AMI start error: client already started")
-		s.stateChanged(s.state, err)
+		state := s.state
+		s.locker.Unlock()
+		if s.stateChanged != nil {
+			s.stateChanged(state, errors.New("AMI start error: client already started"))
+		}
 		return
 	}
+	s.state = StateConnection
+	s.goroutines.Add(1)
+	s.locker.Unlock()
+	defer s.goroutines.Done()
+	if s.stateChanged != nil {
+		s.stateChanged(StateConnection, nil)
+	}
 
+	var conn net.Conn
 	defer func() {
+		s.locker.Lock()
+		s.conn = nil
+		s.locker.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+		// client closed, requests will not be sended
+		if s.ctx.Err() != nil {
+			s.failRequests(ErrClosed)
+		}
 		s.setState(StateStopped, err)
 	}()
 
-	s.setState(StateConnection, nil)
-
 	// connection and read ami greetings message
-	if s.conn, err = net.Dial("tcp", s.host); err != nil {
+	dialer := new(net.Dialer)
+	if conn, err = dialer.DialContext(s.ctx, "tcp", s.host); err != nil {
 		err = fmt.Errorf("AMI connection socket connection error: %v", err.Error())
 		return
 	}
+	s.locker.Lock()
+	s.conn = conn
+	s.locker.Unlock()
 	s.setState(StateConnected, nil)
 
 	// socket connected. receive greetings text
-	if _, err = s.receiveSingle(); err != nil {
+	sess := newSession(conn)
+	sess.separator = s.Dialect().VariableSeparator
+	var greeting []byte
+	if greeting, err = sess.receiveSingle(); err != nil {
 		err = fmt.Errorf("AMI greetings receive error: %v", err.Error())
 		return
 	}
+	amiVersion, _ := versionFromGreeting(greeting)
 
 	// greetings received, make attempt to auth
 	auth := InitRequest("Login")
@@ -256,155 +372,174 @@ func (s *client) Start() {
 
 	actionCallback := func(action ActionData) {
 		if action.isEvent() {
-			s.eventAccepted(Event{action, 0})
+			// dialect is not detected yet, events are processed by the receive loop
+			sess.received = append(sess.received, action)
 		} else {
 			response := Response{action}
-			if !response.IsError() {
-				s.setState(StateAuth, nil)
-			} else {
+			if response.IsError() {
 				err = fmt.Errorf("AMI authentication error: %v", action["Message"])
 				return
 			}
 		}
 	}
 
-	if socketErr := s.sendSingleRequest(auth, actionCallback); socketErr != nil || err != nil {
+	if socketErr := sess.sendSingle(auth, actionCallback); socketErr != nil || err != nil {
 		if err == nil {
 			err = socketErr
 		}
 		return
 	}
 
-	go s.receiveLoop()
+	// authorized, detect server version and protocol dialect
+	if err = s.detectVersion(sess, amiVersion); err != nil {
+		err = fmt.Errorf("AMI version detect error: %v", err.Error())
+		return
+	}
+	sess.separator = s.Dialect().VariableSeparator
+
+	// start session goroutines, queued requests will be sended
+	keepalive := s.keepaliveConfig()
+	sess.goroutines.Add(2)
+	go s.receiveLoop(sess, keepalive.ReadTimeout)
+	go s.writeLoop(sess)
+	if keepalive.Interval > 0 {
+		sess.goroutines.Add(1)
+		go s.keepaliveLoop(sess, keepalive)
+	}
+	s.attachSession(sess)
+	s.setState(StateAuth, nil)
 
-loop:
-	for {
-		select {
-		case request := <-s.request:
-			{
-				actionID := s.initActionID()
-				request.ActionData["ActionID"] = actionID
-				s.requestsWork.PushFront(request)
-				if s.state == StateAuth {
-					if err := s.sendRequest(request); err != nil {
-						log.Println("SendRequestERROR")
-					}
-				}
-			}
-		case event := <-s.event:
-			s.eventAccepted(event)
-		case response := <-s.response:
-			{
-				if req, elem, check := s.requestByActionID(response.ActionID()); check {
-					req.chanResponse <- response
-					close(req.chanResponse)
-					s.requestsWork.Remove(elem)
-				}
-			}
-		case err = <-s.socketClosed:
-			break loop
-		}
+	select {
+	case err = <-sess.errChan:
+	case <-s.ctx.Done():
+		err = ErrClosed
 	}
 
-	return
+	close(sess.stopped)
+	conn.Close()
+	if s.ctx.Err() != nil {
+		s.failRequests(ErrClosed)
+	}
+	s.detachSession(sess, err)
+	sess.goroutines.Wait()
 }
 
-func (s *client) sendQueueRequest() error {
-	for elem := s.requestsWork.Front(); elem != nil; elem.Next() {
-		req := elem.Value.(Request)
-		if req.sended {
-			s.requestsWork.Remove(elem)
-		} else if err := s.sendRequest(req); err != nil {
-			return err
-		}
+// Request sends request and waits the response. ActionID of the request is generated if it is not defined,
+// defined value must be unique for the pending requests
+func (s *client) Request(req Request, timeout time.Duration) (resp Response, accepted bool) {
+	if dialect := s.Dialect(); !dialect.Supports(req.ActionData["Action"]) {
+		err := fmt.Errorf("AMI action %v is not supported by %v", req.ActionData["Action"], dialect.Name)
+		return initResponseError(err), true
 	}
-	return nil
+	// new requests are not accepted after shutdown
+	s.locker.RLock()
+	if s.shuttingDown {
+		s.locker.RUnlock()
+		return initResponseError(ErrClosed), true
+	}
+	s.inflight.Add(1)
+	s.locker.RUnlock()
+	defer s.inflight.Done()
+	return s.send(req, timeout)
 }
 
-func (s *client) receiveSingle() (data []byte, err error) {
-	count, buf := 0, make([]byte, 1024)
-	if count, err = s.conn.Read(buf); err == nil {
-		data = buf[:count]
-	}
-	return
+// RequestList sends request and waits the response with event list, for example
+// CoreShowChannels or PJSIPShowEndpoints. If action response is not an event list,
+// the events will be empty
+func (s *client) RequestList(req Request, timeout time.Duration) (resp Response, events []Event, accepted bool) {
+	return s.requestList(req, timeout, false)
 }
 
-func (s *client) sendSingleRequest(request Request, acceptCallback func(ActionData)) (err error) {
-	// send action
-	if err = s.sendRequest(request); err != nil {
-		return
-	}
+// RequestListRaw works like RequestList, but the list complete event is appended to the events.
+// Used to forward the event list to other AMI clients
+func (s *client) RequestListRaw(req Request, timeout time.Duration) (resp Response, events []Event, accepted bool) {
+	return s.requestList(req, timeout, true)
+}
 
-	// receive answer
-	var data []byte
-	for {
-		count, buf := 0, make([]byte, 1024)
-		if count, err = s.conn.Read(buf); err != nil {
-			return
+func (s *client) requestList(req Request, timeout time.Duration, withComplete bool) (resp Response, events []Event, accepted bool) {
+	if dialect := s.Dialect(); !dialect.Supports(req.ActionData["Action"]) {
+		err := fmt.Errorf("AMI action %v is not supported by %v", req.ActionData["Action"], dialect.Name)
+		return initResponseError(err), nil, true
+	}
+	s.locker.RLock()
+	if s.shuttingDown {
+		s.locker.RUnlock()
+		return initResponseError(ErrClosed), nil, true
+	}
+	s.inflight.Add(1)
+	s.locker.RUnlock()
+	defer s.inflight.Done()
+	p := newPendingRequest(s.requestActionID(req), req)
+	p.list = true
+	if resp, accepted = s.wait(p, timeout); accepted {
+		events = p.events
+		if withComplete && p.complete != nil {
+			events = append(events, *p.complete)
 		}
-		if data = actionsFromRaw(append(data, buf[:count]...), acceptCallback); len(data) == 0 {
-			return
+		for _, e := range events {
+			p.restoreActionID(e.ActionData)
 		}
 	}
+	return
 }
 
-func (s *client) sendRequest(req Request) (err error) {
-	if _, err := s.conn.Write(req.raw()); err != nil {
-		err = fmt.Errorf("AMI socket send data error: %v", err.Error())
-	} else {
-		req.sended = true
-	}
-	return
+// send push request to the write queue and wait response.
+// If timeout is 0, waits until response or client close
+func (s *client) send(req Request, timeout time.Duration) (resp Response, accepted bool) {
+	return s.wait(newPendingRequest(s.requestActionID(req), req), timeout)
 }
 
-func (s *client) receiveLoop() {
-	var (
-		data  []byte
-		count int
-		err   error
-	)
-	buf := make([]byte, 1024)
-	for {
-		if count, err = s.conn.Read(buf); err != nil {
-			err = fmt.Errorf("AMI socket receive data error: %v", err.Error())
-			s.socketClosed <- err
+func (s *client) wait(p *pendingRequest, timeout time.Duration) (resp Response, accepted bool) {
+	if s.ctx.Err() != nil {
+		return initResponseError(ErrClosed), true
+	}
+	if err := p.request.check(); err != nil {
+		return initResponseError(err), true
+	}
+	if !s.enqueue(p) {
+		return initResponseError(fmt.Errorf("AMI request with ActionID %v is already pending", p.actionID)), true
+	}
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case resp = <-p.response:
+		p.restoreActionID(resp.ActionData)
+		return resp, true
+	case <-timer:
+		if s.cancel(p) {
 			return
 		}
-		data = actionsFromRaw(
-			append(data, buf[:count]...),
-			func(action ActionData) {
-				if action.isEvent() {
-					s.event <- initEvent(action)
-				} else {
-					s.response <- Response{action}
-				}
-			},
-		)
-	}
-}
-
-func (s *client) Request(req Request, timeout time.Duration) (resp Response, accepted bool) {
-	req.chanResponse = make(chan Response)
-	s.request <- req
-	if timeout == 0 {
-		resp, accepted = <-req.chanResponse
-	} else {
-		select {
-		case resp, accepted = <-req.chanResponse:
-			accepted = true
-		case <-time.After(timeout):
-			break
+	case <-s.ctx.Done():
+		if s.cancel(p) {
+			return initResponseError(ErrClosed), true
 		}
 	}
-	return
+	// response is delivered while cancel, the request is removed from pending before the
+	// write to the buffered channel, so the wait is short
+	grace := time.NewTimer(responseGrace)
+	defer grace.Stop()
+	select {
+	case resp = <-p.response:
+		p.restoreActionID(resp.ActionData)
+		return resp, true
+	case <-grace.C:
+		return
+	}
 }
 
 // Close finish work with client
 func (s *client) Close() {
-	if s.state > StateStopped {
+	// context must be canceled before socket close, main loop checks it to fail pending requests
+	s.ctxCancel()
+	s.locker.RLock()
+	if s.conn != nil {
 		s.conn.Close()
 	}
-	s.ctxCancel()
+	s.locker.RUnlock()
 }
 
 // destructor for finalizer
@@ -3,9 +3,12 @@ package ami
 import "time"
 
 const (
-	max_client_uuid = ^uint64(0)
+	// eventQueueSize is the size of the received events buffer
+	eventQueueSize = 1024
 )
 
 var (
 	RequestTimeoutDefault = time.Second * 20
+	// LogoffTimeout is the max time of the Logoff response waiting while shutdown
+	LogoffTimeout = time.Second * 3
 )
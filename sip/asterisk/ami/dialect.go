@@ -0,0 +1,201 @@
+package ami
+
+import "strings"
+
+// eventRule renames legacy event to the modern name.
+// If field is defined, the rule is applied only when event field value equals to fieldValue
+type eventRule struct {
+	name       string
+	field      string
+	fieldValue string
+	newName    string
+}
+
+// Dialect describes protocol differences of asterisk versions.
+// Application code works with the modern (asterisk 13+) event and field names,
+// dialect converts incoming legacy events and rejects actions unsupported by the server
+type Dialect struct {
+	Name              string
+	MinVersion        Version
+	VariableSeparator string
+	eventRules        []eventRule
+	fieldRenames      map[string]map[string]string // field renames by the modern event name in lower case
+	removedActions    map[string]bool
+}
+
+// Supports returns false if action was removed or doesn't exist in the dialect
+func (s *Dialect) Supports(action string) bool {
+	return !s.removedActions[strings.ToLower(action)]
+}
+
+// EventName returns modern event name of the legacy event
+func (s *Dialect) EventName(data ActionData) string {
+	name := data["Event"]
+	for _, rule := range s.eventRules {
+		if !strings.EqualFold(rule.name, name) {
+			continue
+		}
+		if rule.field == "" || strings.EqualFold(data[rule.field], rule.fieldValue) {
+			return rule.newName
+		}
+	}
+	return name
+}
+
+// normalizeEvent converts event name and fields to the modern names. The field is not renamed
+// if the event already contains the modern field
+func (s *Dialect) normalizeEvent(data ActionData) {
+	if len(s.eventRules) > 0 {
+		data["Event"] = s.EventName(data)
+	}
+	for oldName, newName := range s.fieldRenames[strings.ToLower(data["Event"])] {
+		if val, check := data[oldName]; check {
+			if _, exists := data[newName]; !exists {
+				data[newName] = val
+				delete(data, oldName)
+			}
+		}
+	}
+}
+
+func actionsSet(names ...string) map[string]bool {
+	res := make(map[string]bool)
+	for _, name := range names {
+		res[strings.ToLower(name)] = true
+	}
+	return res
+}
+
+var (
+	// pjsip stack actions appeared in asterisk 12
+	pjsipActions = []string{
+		"PJSIPQualify", "PJSIPRegister", "PJSIPUnregister", "PJSIPNotify",
+		"PJSIPShowEndpoints", "PJSIPShowEndpoint", "PJSIPShowContacts",
+		"PJSIPShowAors", "PJSIPShowAuths", "PJSIPShowRegistrationsInbound",
+		"PJSIPShowRegistrationsOutbound", "PJSIPShowResourceLists", "PJSIPHangup",
+		"BridgeList", "BridgeInfo", "BridgeDestroy", "BridgeKick",
+		"BridgeTechnologyList", "BridgeTechnologySuspend", "BridgeTechnologyUnsuspend",
+	}
+
+	// chan_sip and res_monitor modules was removed in asterisk 21
+	chanSIPActions = []string{
+		"SIPpeers", "SIPshowpeer", "SIPqualifypeer", "SIPshowregistry",
+		"SIPnotify", "SIPpeerstatus",
+		"Monitor", "StopMonitor", "ChangeMonitor", "PauseMonitor", "UnpauseMonitor",
+	}
+
+	legacyEventRules = []eventRule{
+		{name: "Dial", field: "SubEvent", fieldValue: "Begin", newName: "DialBegin"},
+		{name: "Dial", field: "SubEvent", fieldValue: "End", newName: "DialEnd"},
+		{name: "Join", newName: "QueueCallerJoin"},
+		{name: "Leave", newName: "QueueCallerLeave"},
+		{name: "MusicOnHold", field: "State", fieldValue: "Start", newName: "MusicOnHoldStart"},
+		{name: "MusicOnHold", field: "State", fieldValue: "Stop", newName: "MusicOnHoldStop"},
+		{name: "Hold", field: "Status", fieldValue: "Off", newName: "Unhold"},
+		{name: "Transfer", field: "TransferType", fieldValue: "Blind", newName: "BlindTransfer"},
+		{name: "Transfer", field: "TransferType", fieldValue: "Attended", newName: "AttendedTransfer"},
+		{name: "AsyncAGI", field: "SubEvent", fieldValue: "Start", newName: "AsyncAGIStart"},
+		{name: "AsyncAGI", field: "SubEvent", fieldValue: "Exec", newName: "AsyncAGIExec"},
+		{name: "AsyncAGI", field: "SubEvent", fieldValue: "End", newName: "AsyncAGIEnd"},
+		{name: "Bridge", field: "Bridgestate", fieldValue: "Link", newName: "BridgeEnter"},
+		{name: "Bridge", field: "Bridgestate", fieldValue: "Unlink", newName: "BridgeLeave"},
+	}
+
+	// legacyFieldRenames contains renamed fields of the events, keys are the modern event names
+	legacyFieldRenames = map[string]map[string]string{
+		"dialbegin": {
+			"Source":       "Channel",
+			"SrcUniqueID":  "Uniqueid",
+			"UniqueID":     "Uniqueid",
+			"Destination":  "DestChannel",
+			"DestUniqueID": "DestUniqueid",
+			"CallerID":     "CallerIDNum",
+		},
+		"dialend": {
+			"UniqueID":   "Uniqueid",
+			"Dialstatus": "DialStatus",
+		},
+		"newexten": {
+			"Extension": "Exten",
+		},
+		"newstate": {
+			"CallerID": "CallerIDNum",
+		},
+		"newcallerid": {
+			"CallerID": "CallerIDNum",
+		},
+		"musiconholdstart": {
+			"UniqueID": "Uniqueid",
+		},
+		"musiconholdstop": {
+			"UniqueID": "Uniqueid",
+		},
+	}
+)
+
+// Dialects ordered by asterisk version
+var (
+	DialectAsterisk14 = &Dialect{
+		Name:              "asterisk-1.4",
+		MinVersion:        Version{Major: 1, Minor: 4},
+		VariableSeparator: "|",
+		eventRules:        legacyEventRules,
+		fieldRenames:      legacyFieldRenames,
+		removedActions:    actionsSet(pjsipActions...),
+	}
+	DialectAsterisk11 = &Dialect{
+		Name:              "asterisk-11",
+		MinVersion:        Version{Major: 1, Minor: 6},
+		VariableSeparator: ",",
+		eventRules:        legacyEventRules,
+		fieldRenames:      legacyFieldRenames,
+		removedActions:    actionsSet(pjsipActions...),
+	}
+	DialectAsterisk13 = &Dialect{
+		Name:              "asterisk-13",
+		MinVersion:        Version{Major: 12},
+		VariableSeparator: ",",
+		removedActions:    actionsSet(),
+	}
+	DialectAsterisk16 = &Dialect{
+		Name:              "asterisk-16",
+		MinVersion:        Version{Major: 16},
+		VariableSeparator: ",",
+		removedActions:    actionsSet(),
+	}
+	DialectAsterisk20 = &Dialect{
+		Name:              "asterisk-20",
+		MinVersion:        Version{Major: 20},
+		VariableSeparator: ",",
+		removedActions:    actionsSet(),
+	}
+	DialectAsterisk21 = &Dialect{
+		Name:              "asterisk-21",
+		MinVersion:        Version{Major: 21},
+		VariableSeparator: ",",
+		removedActions:    actionsSet(chanSIPActions...),
+	}
+
+	dialects = []*Dialect{
+		DialectAsterisk21,
+		DialectAsterisk20,
+		DialectAsterisk16,
+		DialectAsterisk13,
+		DialectAsterisk11,
+		DialectAsterisk14,
+	}
+)
+
+// DialectForVersion returns dialect for asterisk version.
+// If version is not valid, will be returned modern dialect
+func DialectForVersion(v Version) *Dialect {
+	if !v.IsValid() {
+		return DialectAsterisk13
+	}
+	for _, dialect := range dialects {
+		if v.Compare(dialect.MinVersion) >= 0 {
+			return dialect
+		}
+	}
+	return DialectAsterisk14
+}
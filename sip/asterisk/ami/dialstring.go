@@ -0,0 +1,388 @@
+package ami
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Channel technologies of the dial string
+const (
+	TechPJSIP = "PJSIP"
+	TechSIP   = "SIP"
+	TechIAX2  = "IAX2"
+	TechDAHDI = "DAHDI"
+	TechLocal = "Local"
+)
+
+var dialTechs = []string{TechPJSIP, TechSIP, TechIAX2, TechDAHDI, TechLocal}
+
+var (
+	// dahdiResource matches channel number, group (g, G, r, R), span (i) or pseudo channel with c, d and r<cadence> flags
+	dahdiResource = regexp.MustCompile(`^([gGrR]\d+|i\d+|\d+|pseudo)(c|d|r\d+)*$`)
+	// hostResource matches peer name or host with optional port
+	hostResource = regexp.MustCompile(`^[A-Za-z0-9_.\-\[\]:]+$`)
+	// dialExten matches extension or number, dialplan patterns are not allowed
+	dialExten = regexp.MustCompile(`^[A-Za-z0-9_.*#+\-]+$`)
+)
+
+// localOptions are the allowed flags of the Local channel
+const localOptions = "njmb"
+
+// DialString is the channel address of the Dial application and Originate action:
+//
+//	PJSIP/[exten@]endpoint[/sip:uri]
+//	SIP/[exten@]peer[:port] or SIP/peer/exten
+//	IAX2/[user[:secret]@]peer[:port][/exten[@context][/options]]
+//	DAHDI/<channel|g<group>|G<group>|r<group>|R<group>|i<span>>[c][d][r<cadence>][/exten]
+//	Local/exten@context[/options]
+type DialString struct {
+	Tech string
+	// Resource is PJSIP endpoint, SIP or IAX2 peer, DAHDI channel or group
+	Resource string
+	// Exten is the number dialed through resource. For the Local channel it is the dialplan extension
+	Exten string
+	// Context of the Local channel or IAX2 remote context
+	Context string
+	// URI is the explicit request URI of the PJSIP channel
+	URI string
+	// User and Secret are IAX2 credentials
+	User   string
+	Secret string
+	// Options of the Local channel (n, j, m, b) or IAX2 dial options
+	Options string
+}
+
+// PJSIP returns dial string of the PJSIP endpoint. If exten is not empty, the number is dialed through endpoint (trunk)
+func PJSIP(endpoint, exten string) DialString {
+	return DialString{Tech: TechPJSIP, Resource: endpoint, Exten: exten}
+}
+
+// Local returns dial string of the Local channel
+func Local(exten, context, options string) DialString {
+	return DialString{Tech: TechLocal, Exten: exten, Context: context, Options: options}
+}
+
+// normalizeTech returns canonical name of the technology
+func normalizeTech(tech string) (string, error) {
+	for _, v := range dialTechs {
+		if strings.EqualFold(v, tech) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("dial string: unknown technology %q, expected one of %v", tech, strings.Join(dialTechs, ", "))
+}
+
+// splitAt splits "left@right". If separator is not found, left is empty
+func splitAt(src string) (left, right string) {
+	if pos := strings.LastIndex(src, "@"); pos >= 0 {
+		return src[:pos], src[pos+1:]
+	}
+	return "", src
+}
+
+// ParseDialString parses and validates dial string like "PJSIP/100" or "Local/200@from-internal/n"
+func ParseDialString(src string) (res DialString, err error) {
+	parts := strings.SplitN(strings.TrimSpace(src), "/", 2)
+	if len(parts) != 2 {
+		return res, fmt.Errorf("dial string %q: technology prefix is not defined", src)
+	}
+	if res.Tech, err = normalizeTech(parts[0]); err != nil {
+		return
+	}
+	addr := parts[1]
+	switch res.Tech {
+	case TechPJSIP:
+		// URI may contain '/' and '@', so it is separated first
+		if pos := strings.Index(addr, "/"); pos >= 0 {
+			addr, res.URI = addr[:pos], addr[pos+1:]
+		}
+		res.Exten, res.Resource = splitAt(addr)
+	case TechSIP:
+		if pos := strings.Index(addr, "/"); pos >= 0 {
+			res.Resource, res.Exten = addr[:pos], addr[pos+1:]
+		} else {
+			res.Exten, res.Resource = splitAt(addr)
+		}
+	case TechIAX2:
+		var dest string
+		if pos := strings.Index(addr, "/"); pos >= 0 {
+			addr, dest = addr[:pos], addr[pos+1:]
+		}
+		var credentials string
+		credentials, res.Resource = splitAt(addr)
+		if credentials != "" {
+			res.User = credentials
+			if pos := strings.Index(credentials, ":"); pos >= 0 {
+				res.User, res.Secret = credentials[:pos], credentials[pos+1:]
+			}
+		}
+		if dest != "" {
+			if pos := strings.Index(dest, "/"); pos >= 0 {
+				dest, res.Options = dest[:pos], dest[pos+1:]
+			}
+			res.Exten = dest
+			if pos := strings.Index(dest, "@"); pos >= 0 {
+				res.Exten, res.Context = dest[:pos], dest[pos+1:]
+			}
+		}
+	case TechDAHDI:
+		res.Resource = addr
+		if pos := strings.Index(addr, "/"); pos >= 0 {
+			res.Resource, res.Exten = addr[:pos], addr[pos+1:]
+		}
+	case TechLocal:
+		if pos := strings.Index(addr, "/"); pos >= 0 {
+			addr, res.Options = addr[:pos], addr[pos+1:]
+		}
+		if pos := strings.Index(addr, "@"); pos >= 0 {
+			res.Exten, res.Context = addr[:pos], addr[pos+1:]
+		} else {
+			res.Exten = addr
+		}
+	}
+	if err = res.Validate(); err != nil {
+		return res, fmt.Errorf("dial string %q: %v", src, err)
+	}
+	return
+}
+
+// Validate checks dial string values of the technology
+func (s DialString) Validate() (err error) {
+	var tech string
+	if tech, err = normalizeTech(s.Tech); err != nil {
+		return
+	}
+	if s.Exten != "" && !dialExten.MatchString(s.Exten) {
+		return fmt.Errorf("invalid extension %q", s.Exten)
+	}
+	switch tech {
+	case TechLocal:
+		if s.Exten == "" || s.Context == "" {
+			return fmt.Errorf("Local channel requires exten@context")
+		}
+		if strings.ContainsAny(s.Context, "/@&,") {
+			return fmt.Errorf("invalid Local channel context %q", s.Context)
+		}
+		for _, c := range s.Options {
+			if !strings.ContainsRune(localOptions, c) {
+				return fmt.Errorf("unknown Local channel option %q, allowed %q", c, localOptions)
+			}
+		}
+	case TechDAHDI:
+		if !dahdiResource.MatchString(s.Resource) {
+			return fmt.Errorf("invalid DAHDI channel or group %q", s.Resource)
+		}
+	default:
+		if s.Resource == "" {
+			return fmt.Errorf("%v endpoint or peer is not defined", tech)
+		}
+		if !hostResource.MatchString(s.Resource) {
+			return fmt.Errorf("invalid %v endpoint or peer %q", tech, s.Resource)
+		}
+		if s.URI != "" && tech != TechPJSIP {
+			return fmt.Errorf("request URI is supported only by PJSIP")
+		}
+		if s.URI != "" && !strings.HasPrefix(s.URI, "sip:") && !strings.HasPrefix(s.URI, "sips:") {
+			return fmt.Errorf("invalid request URI %q", s.URI)
+		}
+		if (s.User != "" || s.Secret != "") && tech != TechIAX2 {
+			return fmt.Errorf("credentials are supported only by IAX2")
+		}
+	}
+	if s.Context != "" && tech != TechLocal && tech != TechIAX2 {
+		return fmt.Errorf("context is supported only by Local and IAX2")
+	}
+	if s.Options != "" && tech != TechLocal && tech != TechIAX2 {
+		return fmt.Errorf("options are supported only by Local and IAX2")
+	}
+	return
+}
+
+// String returns dial string
+func (s DialString) String() string {
+	tech, err := normalizeTech(s.Tech)
+	if err != nil {
+		tech = s.Tech
+	}
+	res := tech + "/"
+	switch tech {
+	case TechLocal:
+		res += s.Exten + "@" + s.Context
+		if s.Options != "" {
+			res += "/" + s.Options
+		}
+	case TechIAX2:
+		if s.User != "" {
+			res += s.User
+			if s.Secret != "" {
+				res += ":" + s.Secret
+			}
+			res += "@"
+		}
+		res += s.Resource
+		if s.Exten != "" || s.Options != "" {
+			res += "/" + s.Exten
+			if s.Context != "" {
+				res += "@" + s.Context
+			}
+			if s.Options != "" {
+				res += "/" + s.Options
+			}
+		}
+	case TechDAHDI:
+		res += s.Resource
+		if s.Exten != "" {
+			res += "/" + s.Exten
+		}
+	default:
+		if s.Exten != "" {
+			res += s.Exten + "@"
+		}
+		res += s.Resource
+		if s.URI != "" {
+			res += "/" + s.URI
+		}
+	}
+	return res
+}
+
+////////////////////////////////////////////////////////////////// Dial options
+
+// dialOptionArgs defines arguments of the Dial application options:
+// 0 - option without argument, 1 - optional argument, 2 - required argument
+var dialOptionArgs = map[byte]byte{
+	'A': 2, 'a': 0, 'b': 2, 'B': 2, 'C': 0, 'c': 0, 'd': 0, 'D': 2, 'e': 0, 'f': 1, 'F': 1,
+	'g': 0, 'G': 2, 'h': 0, 'H': 0, 'i': 0, 'I': 0, 'j': 0, 'k': 0, 'K': 0, 'L': 2, 'm': 1,
+	'M': 2, 'n': 1, 'N': 0, 'o': 1, 'O': 1, 'p': 0, 'P': 1, 'Q': 2, 'r': 1, 'R': 0, 's': 2,
+	'S': 2, 't': 0, 'T': 0, 'u': 2, 'U': 2, 'w': 0, 'W': 0, 'x': 0, 'X': 0, 'z': 0,
+}
+
+// DialOption is the single option of the Dial application, for example "t" or "L(60000:30000)"
+type DialOption struct {
+	Flag byte
+	// Arg is the option argument without parentheses. Value is not parsed,
+	// for example "600000:30000" for L option or "sub^s^1" for U option
+	Arg string
+}
+
+func (s DialOption) String() string {
+	if s.Arg == "" {
+		return string(s.Flag)
+	}
+	return fmt.Sprintf("%c(%v)", s.Flag, s.Arg)
+}
+
+// Validate checks option flag and argument
+func (s DialOption) Validate() error {
+	mode, check := dialOptionArgs[s.Flag]
+	switch {
+	case !check:
+		return fmt.Errorf("unknown Dial option %q", s.Flag)
+	case mode == 0 && s.Arg != "":
+		return fmt.Errorf("Dial option %c has no argument", s.Flag)
+	case mode == 2 && s.Arg == "":
+		return fmt.Errorf("Dial option %c requires argument", s.Flag)
+	}
+	return nil
+}
+
+// DialOptions is the options string of the Dial application
+type DialOptions []DialOption
+
+// ParseDialOptions parses and validates options string like "tTL(60000:30000)U(sub^arg)"
+func ParseDialOptions(src string) (res DialOptions, err error) {
+	for i := 0; i < len(src); i++ {
+		opt := DialOption{Flag: src[i]}
+		if i+1 < len(src) && src[i+1] == '(' {
+			// argument may contain nested parentheses, for example b(context^s^1(arg))
+			level, end := 0, -1
+			for j := i + 1; j < len(src) && end < 0; j++ {
+				switch src[j] {
+				case '(':
+					level++
+				case ')':
+					if level--; level == 0 {
+						end = j
+					}
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("Dial options %q: unclosed argument of the option %c", src, opt.Flag)
+			}
+			opt.Arg, i = src[i+2:end], end
+		}
+		if err = opt.Validate(); err != nil {
+			return nil, fmt.Errorf("Dial options %q: %v", src, err)
+		}
+		res = append(res, opt)
+	}
+	return
+}
+
+// Has returns true if options contain flag
+func (s DialOptions) Has(flag byte) bool {
+	_, check := s.Get(flag)
+	return check
+}
+
+// Get returns option by flag
+func (s DialOptions) Get(flag byte) (res DialOption, check bool) {
+	for _, opt := range s {
+		if opt.Flag == flag {
+			return opt, true
+		}
+	}
+	return
+}
+
+// Validate checks all options
+func (s DialOptions) Validate() error {
+	for _, opt := range s {
+		if err := opt.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s DialOptions) String() string {
+	var res strings.Builder
+	for _, opt := range s {
+		res.WriteString(opt.String())
+	}
+	return res.String()
+}
+
+// DialData returns data of the Dial application: targets joined by '&', timeout in seconds and options.
+// Arguments are separated by the dialect separator
+func DialData(dialect *Dialect, targets []DialString, timeout int, options DialOptions) (string, error) {
+	if len(targets) == 0 {
+		return "", fmt.Errorf("Dial targets are not defined")
+	}
+	list := make([]string, len(targets))
+	for i, target := range targets {
+		if err := target.Validate(); err != nil {
+			return "", fmt.Errorf("Dial target %v: %v", target, err)
+		}
+		list[i] = target.String()
+	}
+	if err := options.Validate(); err != nil {
+		return "", err
+	}
+	separator := ","
+	if dialect != nil && dialect.VariableSeparator == "|" {
+		separator = "|"
+	}
+	res := strings.Join(list, "&")
+	if timeout > 0 || len(options) > 0 {
+		res += separator
+		if timeout > 0 {
+			res += fmt.Sprint(timeout)
+		}
+	}
+	if len(options) > 0 {
+		res += separator + options.String()
+	}
+	return res, nil
+}
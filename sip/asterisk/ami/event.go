@@ -1,26 +1,49 @@
 package ami
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+)
 
 func initEvent(data ActionData) Event {
-	res := Event{
+	return Event{
 		ActionData: data,
 	}
-	if src, check := data["Uniqueid"]; check {
-		res.uuid, _ = strconv.ParseInt(src, 10, 64)
-	}
-	return res
 }
 
 type Event struct {
 	ActionData
-	uuid int64
 }
 
 func (s Event) Name() string {
 	return s.ActionData["Event"]
 }
 
+// Uniqueid returns unique id of the event channel
+func (s Event) Uniqueid() string {
+	return s.ActionData["Uniqueid"]
+}
+
+// Linkedid returns unique id of the oldest channel of the call
+func (s Event) Linkedid() string {
+	return s.ActionData["Linkedid"]
+}
+
+// Channel returns channel name of the event
+func (s Event) Channel() string {
+	return s.ActionData["Channel"]
+}
+
+// UUID returns Uniqueid as integer value. Valid only for the channels created with
+// numeric ChannelID (for example by Originate), for asterisk generated ids like
+// "1700000000.123" returns 0
 func (s Event) UUID() int64 {
-	return s.uuid
+	res, _ := strconv.ParseInt(s.Uniqueid(), 10, 64)
+	return res
+}
+
+// IsListComplete returns true if event is the last event of the event list
+func (s Event) IsListComplete() bool {
+	return strings.EqualFold(s.ActionData["EventList"], "Complete") ||
+		strings.HasSuffix(s.Name(), "Complete")
 }
@@ -1,18 +1,352 @@
 package ami
 
-import "time"
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
 
+// ListenerKind is a key type of the event listener
+type ListenerKind byte
+
+const (
+	// ListenUniqueid receives events of the single channel
+	ListenUniqueid ListenerKind = iota
+	// ListenLinkedid receives events of the whole call across all legs
+	ListenLinkedid
+	// ListenChannel receives events of the channels matched with the name pattern
+	ListenChannel
+	// ListenEvent receives events with the defined names
+	ListenEvent
+	// ListenActionID receives asynchronous events of the request, like OriginateResponse
+	ListenActionID
+)
+
+func (s ListenerKind) String() string {
+	switch s {
+	case ListenUniqueid:
+		return "Uniqueid"
+	case ListenLinkedid:
+		return "Linkedid"
+	case ListenChannel:
+		return "Channel"
+	case ListenEvent:
+		return "Event"
+	case ListenActionID:
+		return "ActionID"
+	default:
+		return ""
+	}
+}
+
+// ListenerConfig defines the lifetime of the event listener.
+// Without options listener works until Close method call or client stop
+type ListenerConfig struct {
+	// IdleTimeout closes listener if no events received during the duration
+	IdleTimeout time.Duration
+	// Deadline closes listener at the defined time
+	Deadline time.Time
+	// CloseOn closes listener after delivery of the event with one of the names
+	CloseOn []string
+	// BufferSize of the events channel
+	BufferSize int
+}
+
+// EventListener receives events of the channel or call
 type EventListener struct {
-	uuid       int64
+	kind       ListenerKind
+	key        string
+	pattern    *regexp.Regexp
+	names      map[string]bool
+	config     ListenerConfig
 	eventChan  chan Event
-	timeActual time.Time
+	done       chan struct{}
+	sendLocker sync.Mutex
+	closeOnce  sync.Once
+	closed     bool
+	timer      *time.Timer
+	registry   *listenerRegistry
 }
 
+func newEventListener(kind ListenerKind, key string, config ListenerConfig) *EventListener {
+	return &EventListener{
+		kind:      kind,
+		key:       key,
+		config:    config,
+		eventChan: make(chan Event, config.BufferSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// Kind returns key type of the listener
+func (s *EventListener) Kind() ListenerKind { return s.kind }
+
+// Key returns Uniqueid, Linkedid, channel pattern, comma separated event names or ActionID of the listener
+func (s *EventListener) Key() string { return s.key }
+
+// Events returns events channel. Channel is closed after listener close
+func (s *EventListener) Events() <-chan Event { return s.eventChan }
+
+// Done returns channel closed after listener close
+func (s *EventListener) Done() <-chan struct{} { return s.done }
+
+func (s *EventListener) match(e Event) bool {
+	switch s.kind {
+	case ListenUniqueid:
+		return e.Uniqueid() == s.key
+	case ListenLinkedid:
+		return e.Linkedid() == s.key
+	case ListenChannel:
+		return s.pattern.MatchString(e.Channel())
+	case ListenEvent:
+		return s.names[strings.ToLower(e.Name())]
+	case ListenActionID:
+		return e.ActionID() == s.key
+	}
+	return false
+}
+
+func (s *EventListener) start() {
+	s.sendLocker.Lock()
+	defer s.sendLocker.Unlock()
+	if s.closed {
+		return
+	}
+	var timeout time.Duration
+	if s.config.IdleTimeout > 0 {
+		timeout = s.config.IdleTimeout
+	}
+	if !s.config.Deadline.IsZero() {
+		if d := time.Until(s.config.Deadline); timeout == 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if timeout != 0 {
+		s.timer = time.AfterFunc(timeout, s.Close)
+	}
+}
+
+func (s *EventListener) resetTimer() {
+	if s.timer == nil || s.config.IdleTimeout == 0 {
+		return
+	}
+	timeout := s.config.IdleTimeout
+	if !s.config.Deadline.IsZero() {
+		if d := time.Until(s.config.Deadline); d < timeout {
+			timeout = d
+		}
+	}
+	s.timer.Reset(timeout)
+}
+
+// incomingEvent send event to the listener channel, blocks until the event is read or listener closed
 func (s *EventListener) incomingEvent(e Event) {
-	s.timeActual = time.Now().Add(time.Minute)
-	s.eventChan <- e
+	s.sendLocker.Lock()
+	if s.closed {
+		s.sendLocker.Unlock()
+		return
+	}
+	select {
+	case s.eventChan <- e:
+		s.resetTimer()
+	case <-s.done:
+		s.sendLocker.Unlock()
+		return
+	}
+	s.sendLocker.Unlock()
+	for _, name := range s.config.CloseOn {
+		if strings.EqualFold(name, e.Name()) {
+			s.Close()
+			return
+		}
+	}
+}
+
+// Close stops listener and closes events channel
+func (s *EventListener) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.sendLocker.Lock()
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.closed = true
+		close(s.eventChan)
+		s.sendLocker.Unlock()
+		if s.registry != nil {
+			s.registry.remove(s)
+		}
+	})
+}
+
+//////////////////////////////////////////////////////////////////
+
+// globRegexp converts channel pattern like "PJSIP/100-*" to the regular expression
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return regexp.Compile("^" + expr + "$")
+}
+
+// CompilePattern compiles glob pattern of the header value like "PJSIP/100-*". The "*" matches
+// any sequence of characters including "/", the "?" matches any single character. Channel listeners,
+// proxy and gateway filters use the same syntax
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	return globRegexp(pattern)
+}
+
+var patterns sync.Map
+
+// MatchPattern checks value by the glob pattern (see CompilePattern). Compiled patterns are cached,
+// so it is intended for the patterns of the configuration
+func MatchPattern(pattern, value string) bool {
+	expr, check := patterns.Load(pattern)
+	if !check {
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return false
+		}
+		expr, _ = patterns.LoadOrStore(pattern, re)
+	}
+	return expr.(*regexp.Regexp).MatchString(value)
+}
+
+func newListenerRegistry() *listenerRegistry {
+	return &listenerRegistry{
+		locker:   new(sync.RWMutex),
+		uniqueid: make(map[string][]*EventListener),
+		linkedid: make(map[string][]*EventListener),
+		actionID: make(map[string][]*EventListener),
+	}
+}
+
+// listenerRegistry stores event listeners indexed by key
+type listenerRegistry struct {
+	locker   *sync.RWMutex
+	uniqueid map[string][]*EventListener
+	linkedid map[string][]*EventListener
+	actionID map[string][]*EventListener
+	channel  []*EventListener
+	event    []*EventListener
+}
+
+func (s *listenerRegistry) add(listener *EventListener) {
+	listener.registry = s
+	s.locker.Lock()
+	switch listener.kind {
+	case ListenUniqueid:
+		s.uniqueid[listener.key] = append(s.uniqueid[listener.key], listener)
+	case ListenLinkedid:
+		s.linkedid[listener.key] = append(s.linkedid[listener.key], listener)
+	case ListenActionID:
+		s.actionID[listener.key] = append(s.actionID[listener.key], listener)
+	case ListenChannel:
+		s.channel = append(s.channel, listener)
+	case ListenEvent:
+		s.event = append(s.event, listener)
+	}
+	s.locker.Unlock()
+	listener.start()
+}
+
+func removeListener(list []*EventListener, listener *EventListener) []*EventListener {
+	for i, v := range list {
+		if v == listener {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+func (s *listenerRegistry) remove(listener *EventListener) {
+	s.locker.Lock()
+	switch listener.kind {
+	case ListenUniqueid:
+		if list := removeListener(s.uniqueid[listener.key], listener); len(list) > 0 {
+			s.uniqueid[listener.key] = list
+		} else {
+			delete(s.uniqueid, listener.key)
+		}
+	case ListenLinkedid:
+		if list := removeListener(s.linkedid[listener.key], listener); len(list) > 0 {
+			s.linkedid[listener.key] = list
+		} else {
+			delete(s.linkedid, listener.key)
+		}
+	case ListenActionID:
+		if list := removeListener(s.actionID[listener.key], listener); len(list) > 0 {
+			s.actionID[listener.key] = list
+		} else {
+			delete(s.actionID, listener.key)
+		}
+	case ListenChannel:
+		s.channel = removeListener(s.channel, listener)
+	case ListenEvent:
+		s.event = removeListener(s.event, listener)
+	}
+	s.locker.Unlock()
+}
+
+// match returns listeners of the event
+func (s *listenerRegistry) match(e Event) (res []*EventListener) {
+	s.locker.RLock()
+	if uniqueid := e.Uniqueid(); uniqueid != "" {
+		res = append(res, s.uniqueid[uniqueid]...)
+	}
+	if linkedid := e.Linkedid(); linkedid != "" {
+		res = append(res, s.linkedid[linkedid]...)
+	}
+	if actionID := e.ActionID(); actionID != "" {
+		res = append(res, s.actionID[actionID]...)
+	}
+	for _, listener := range s.channel {
+		if listener.match(e) {
+			res = append(res, listener)
+		}
+	}
+	for _, listener := range s.event {
+		if listener.match(e) {
+			res = append(res, listener)
+		}
+	}
+	s.locker.RUnlock()
+	return
+}
+
+func (s *listenerRegistry) len() (res int) {
+	s.locker.RLock()
+	res = len(s.channel) + len(s.event)
+	for _, list := range s.uniqueid {
+		res += len(list)
+	}
+	for _, list := range s.linkedid {
+		res += len(list)
+	}
+	for _, list := range s.actionID {
+		res += len(list)
+	}
+	s.locker.RUnlock()
+	return
 }
 
-func (s *EventListener) close() {
-	close(s.eventChan)
+func (s *listenerRegistry) closeAll() {
+	var all []*EventListener
+	s.locker.RLock()
+	all = append(all, s.channel...)
+	all = append(all, s.event...)
+	for _, list := range s.uniqueid {
+		all = append(all, list...)
+	}
+	for _, list := range s.linkedid {
+		all = append(all, list...)
+	}
+	for _, list := range s.actionID {
+		all = append(all, list...)
+	}
+	s.locker.RUnlock()
+	for _, listener := range all {
+		listener.Close()
+	}
 }
@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fcg-xvii/go-tools/text/config"
+)
+
+func splitList(src string) (res []string) {
+	for _, v := range strings.Split(src, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return
+}
+
+// TokensFromConfig reads API tokens from the config sections with name sectionName:
+//
+//	[token]
+//	key = 9f1c2a
+//	actions = Ping, CoreShowChannels, Originate
+//	events = Newchannel, Hangup
+func TokensFromConfig(conf config.Config, sectionName string) (res map[string]Token, err error) {
+	sections, check := conf.Sections(sectionName)
+	if !check {
+		return nil, fmt.Errorf("gateway config: sections [%v] are not found", sectionName)
+	}
+	res = make(map[string]Token)
+	for i, section := range sections {
+		var key, actions, events string
+		section.ValueSetup("key", &key)
+		section.ValueSetup("actions", &actions)
+		section.ValueSetup("events", &events)
+		if key == "" {
+			return nil, fmt.Errorf("gateway config: key of the token %v is empty", i)
+		}
+		if _, check := res[key]; check {
+			return nil, fmt.Errorf("gateway config: token %v is duplicated", key)
+		}
+		res[key] = Token{Actions: splitList(actions), Events: splitList(events)}
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+// eventFilter selects events of the stream by names and header patterns
+type eventFilter struct {
+	names   map[string]bool
+	headers map[string]*regexp.Regexp
+}
+
+// parseEventFilter creates filter from query parameters. Parameter "name" contains comma separated
+// event names, other parameters (except "token") are header patterns in the ami.CompilePattern syntax
+func parseEventFilter(query url.Values) (res *eventFilter, err error) {
+	res = &eventFilter{headers: make(map[string]*regexp.Regexp)}
+	for key, values := range query {
+		switch key {
+		case "token":
+		case "name":
+			for _, value := range values {
+				for _, name := range strings.Split(value, ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						if res.names == nil {
+							res.names = make(map[string]bool)
+						}
+						res.names[strings.ToLower(name)] = true
+					}
+				}
+			}
+		default:
+			if res.headers[key], err = ami.CompilePattern(values[len(values)-1]); err != nil {
+				return nil, fmt.Errorf("invalid pattern of the %v filter: %v", key, err)
+			}
+		}
+	}
+	return
+}
+
+func (s *eventFilter) match(e ami.Event) bool {
+	if s.names != nil && !s.names[strings.ToLower(e.Name())] {
+		return false
+	}
+	for key, pattern := range s.headers {
+		if !pattern.MatchString(e.ActionData[key]) {
+			return false
+		}
+	}
+	return true
+}
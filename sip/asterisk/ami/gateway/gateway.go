@@ -0,0 +1,344 @@
+// Package gateway provides HTTP access to the asterisk manager interface.
+//
+// Gateway wraps authorized ami.Client and serves two endpoints:
+//
+//	POST {prefix}/action   JSON action request, returns JSON response with event list
+//	GET  {prefix}/events   Server-Sent Events stream of the filtered AMI events
+//
+// Every request must contain API token in the "Authorization: Bearer <token>" header or in
+// the "token" query parameter. The token defines whitelists of the allowed actions and events.
+//
+// Action request body is JSON object with action fields. Object in the "Variable" field is
+// converted to the action variables:
+//
+//	{"Action": "Originate", "Channel": "PJSIP/100", "Variable": {"CALLERID": "200"}}
+//
+// Events stream is filtered by the "name" parameter (comma separated event names) and by
+// header patterns in other parameters: /events?name=Newchannel,Hangup&Channel=PJSIP/*
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+const (
+	maxBodySize          = 1 << 20
+	subscriberBufferSize = 256
+)
+
+// Token defines the access rights of the API token
+type Token struct {
+	// Actions is a whitelist of the allowed actions, "*" allows all actions
+	Actions []string
+	// Events is a whitelist of the allowed event names of the events stream, empty list or "*" allows all events
+	Events []string
+}
+
+func containsName(list []string, name string) bool {
+	for _, v := range list {
+		if v == "*" || strings.EqualFold(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActionAllowed returns true if token allows action
+func (s Token) ActionAllowed(action string) bool {
+	return containsName(s.Actions, action)
+}
+
+// EventAllowed returns true if token allows event
+func (s Token) EventAllowed(event string) bool {
+	return len(s.Events) == 0 || containsName(s.Events, event)
+}
+
+// Config of the gateway
+type Config struct {
+	// Tokens is a map of the API tokens and their rights
+	Tokens map[string]Token
+	// Prefix of the endpoints path, for example "/ami"
+	Prefix string
+	// Timeout of the action request, ami.RequestTimeoutDefault by default
+	Timeout time.Duration
+	// Heartbeat is the interval of the comment lines sended to the idle events stream, 0 disables heartbeat
+	Heartbeat time.Duration
+}
+
+// New creates gateway of the client. Gateway reads the client events channel,
+// so client events must not be readed by other consumers
+func New(client *ami.Client, conf Config) *Gateway {
+	if conf.Timeout == 0 {
+		conf.Timeout = ami.RequestTimeoutDefault
+	}
+	s := &Gateway{
+		client:      client,
+		conf:        conf,
+		subscribers: make(map[*subscriber]struct{}),
+		locker:      new(sync.RWMutex),
+		closed:      make(chan struct{}),
+	}
+	go s.broadcast(client.Event())
+	return s
+}
+
+// Gateway is the http.Handler of the AMI client
+type Gateway struct {
+	client      *ami.Client
+	conf        Config
+	subscribers map[*subscriber]struct{}
+	locker      *sync.RWMutex
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// subscriber is the events stream of the http client
+type subscriber struct {
+	token  Token
+	filter *eventFilter
+	events chan ami.Event
+}
+
+// broadcast sends client events to the subscribers. Event is dropped for the subscriber
+// if its buffer is full, so slow browser doesn't block the AMI client
+func (s *Gateway) broadcast(events chan ami.Event) {
+	for {
+		select {
+		case e, check := <-events:
+			if !check {
+				s.Close()
+				return
+			}
+			s.locker.RLock()
+			for sub := range s.subscribers {
+				if !sub.token.EventAllowed(e.Name()) || !sub.filter.match(e) {
+					continue
+				}
+				select {
+				case sub.events <- e:
+				default:
+				}
+			}
+			s.locker.RUnlock()
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Close stops all events streams. The client is not closed
+func (s *Gateway) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// SubscribersCount returns count of the active events streams
+func (s *Gateway) SubscribersCount() (res int) {
+	s.locker.RLock()
+	res = len(s.subscribers)
+	s.locker.RUnlock()
+	return
+}
+
+func (s *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, check := s.authorize(r)
+	if !check {
+		writeError(w, http.StatusUnauthorized, "invalid API token")
+		return
+	}
+	switch strings.TrimPrefix(path.Clean(r.URL.Path), s.conf.Prefix) {
+	case "/action":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.serveAction(w, r, token)
+	case "/events":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.serveEvents(w, r, token)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorize returns token of the request
+func (s *Gateway) authorize(r *http.Request) (token Token, check bool) {
+	key := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if key == "" {
+		return
+	}
+	token, check = s.conf.Tokens[key]
+	return
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// hasLineBreak checks the line breaks, which would inject the AMI fields or actions
+func hasLineBreak(src string) bool { return strings.ContainsAny(src, "\r\n") }
+
+// parseRequest converts JSON object to the AMI request
+func parseRequest(r io.Reader) (req ami.Request, err error) {
+	src, err := ioutil.ReadAll(io.LimitReader(r, maxBodySize))
+	if err != nil {
+		return
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(src, &fields); err != nil {
+		return req, fmt.Errorf("invalid JSON: %v", err)
+	}
+	req.ActionData = make(ami.ActionData, len(fields))
+	for key, val := range fields {
+		if hasLineBreak(key) {
+			return req, fmt.Errorf("field %q: line breaks are not allowed", key)
+		}
+		switch v := val.(type) {
+		case map[string]interface{}:
+			if !strings.EqualFold(key, "Variable") && !strings.EqualFold(key, "Variables") {
+				return req, fmt.Errorf("field %v: object is allowed only for variables", key)
+			}
+			for name, value := range v {
+				str := fmt.Sprint(value)
+				if hasLineBreak(name) || hasLineBreak(str) {
+					return req, fmt.Errorf("variable %q: line breaks are not allowed", name)
+				}
+				req.SetVariable(name, str)
+			}
+		case []interface{}:
+			return req, fmt.Errorf("field %v: arrays are not supported", key)
+		case nil:
+		default:
+			if hasLineBreak(fmt.Sprint(v)) {
+				return req, fmt.Errorf("field %v: line breaks are not allowed", key)
+			}
+			// ActionID is the internal value of the gateway client
+			if !strings.EqualFold(key, "ActionID") {
+				req.ActionData[key] = fmt.Sprint(v)
+			}
+		}
+	}
+	if req.ActionData["Action"] == "" {
+		return req, fmt.Errorf("field Action is not defined")
+	}
+	return
+}
+
+// connectionActions change the state of the shared upstream connection (authorization, event mask),
+// they are not allowed for any token
+var connectionActions = map[string]bool{
+	"login":     true,
+	"logoff":    true,
+	"challenge": true,
+	"events":    true,
+	"filter":    true,
+}
+
+func (s *Gateway) serveAction(w http.ResponseWriter, r *http.Request, token Token) {
+	req, err := parseRequest(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	action := req.ActionData["Action"]
+	if connectionActions[strings.ToLower(action)] || !token.ActionAllowed(action) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("action %v is not allowed", action))
+		return
+	}
+	resp, events, accepted := s.client.RequestList(req, s.conf.Timeout)
+	if !accepted {
+		writeError(w, http.StatusGatewayTimeout, "AMI request timeout")
+		return
+	}
+	result := struct {
+		Response ami.ActionData   `json:"response"`
+		Events   []ami.ActionData `json:"events"`
+	}{resp.ActionData, make([]ami.ActionData, 0, len(events))}
+	// ActionID is the internal value of the gateway client
+	delete(result.Response, "ActionID")
+	for _, e := range events {
+		delete(e.ActionData, "ActionID")
+		result.Events = append(result.Events, e.ActionData)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Gateway) serveEvents(w http.ResponseWriter, r *http.Request, token Token) {
+	flusher, check := w.(http.Flusher)
+	if !check {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	filter, err := parseEventFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sub := &subscriber{
+		token:  token,
+		filter: filter,
+		events: make(chan ami.Event, subscriberBufferSize),
+	}
+	s.locker.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.locker.Unlock()
+	defer func() {
+		s.locker.Lock()
+		delete(s.subscribers, sub)
+		s.locker.Unlock()
+	}()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var heartbeat <-chan time.Time
+	if s.conf.Heartbeat > 0 {
+		ticker := time.NewTicker(s.conf.Heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case e := <-sub.events:
+			data, _ := json.Marshal(e.ActionData)
+			if _, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", e.Name(), data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami/amitest"
+	"github.com/fcg-xvii/go-tools/text/config"
+	_ "github.com/fcg-xvii/go-tools/text/config/ini"
+)
+
+func startGateway(t *testing.T) (*amitest.Server, *httptest.Server, func()) {
+	srv := amitest.NewServer(amitest.DefaultBanner)
+	cl, err := amitest.StartClient(srv.Addr())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	gw := New(cl, Config{
+		Prefix:  "/ami",
+		Timeout: time.Second,
+		Tokens: map[string]Token{
+			"admin":    {Actions: []string{"*"}},
+			"operator": {Actions: []string{"Ping", "CoreShowChannels"}, Events: []string{"Hangup"}},
+		},
+	})
+	hs := httptest.NewServer(gw)
+	return srv, hs, func() {
+		gw.Close()
+		hs.Close()
+		cl.Close()
+		srv.Close()
+	}
+}
+
+func postAction(t *testing.T, url, token, body string) (status int, res map[string]interface{}) {
+	req, _ := http.NewRequest(http.MethodPost, url+"/ami/action", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, res
+}
+
+func TestAction(t *testing.T) {
+	srv, hs, stop := startGateway(t)
+	defer stop()
+	srv.Handle("CoreShowChannels", amitest.List("CoreShowChannel", "CoreShowChannelsComplete",
+		ami.ActionData{"Channel": "PJSIP/100-01"},
+		ami.ActionData{"Channel": "PJSIP/200-02"},
+	))
+
+	status, res := postAction(t, hs.URL, "operator", `{"Action": "Ping"}`)
+	if status != http.StatusOK || res["response"].(map[string]interface{})["Ping"] != "Pong" {
+		t.Fatal(status, res)
+	}
+	status, res = postAction(t, hs.URL, "operator", `{"Action": "CoreShowChannels"}`)
+	if events := res["events"].([]interface{}); status != http.StatusOK || len(events) != 2 {
+		t.Fatal(status, res)
+	}
+
+	for _, c := range []struct {
+		token, body string
+		status      int
+	}{
+		{"", `{"Action": "Ping"}`, http.StatusUnauthorized},
+		{"unknown", `{"Action": "Ping"}`, http.StatusUnauthorized},
+		{"operator", `{"Action": "Originate"}`, http.StatusForbidden},
+		{"admin", `{"Action": "Logoff"}`, http.StatusForbidden},
+		{"admin", `{"Action": "Events", "EventMask": "off"}`, http.StatusForbidden},
+		{"admin", `{"Action": "filter", "Operation": "Add", "Filter": "Event: Hangup"}`, http.StatusForbidden},
+		{"admin", `{"Action": "Challenge", "AuthType": "MD5"}`, http.StatusForbidden},
+		{"admin", `{"Action": `, http.StatusBadRequest},
+		{"admin", `{"Channel": "PJSIP/100"}`, http.StatusBadRequest},
+		// line breaks would inject the actions not allowed by the token
+		{"operator", `{"Action": "Ping", "X": "1\r\n\r\nAction: Command\r\nCommand: core stop now"}`, http.StatusBadRequest},
+		{"operator", `{"Action": "Ping", "X\nAction": "Command"}`, http.StatusBadRequest},
+		{"admin", `{"Action": "Originate", "Variable": {"A": "1\nCommand: core stop now"}}`, http.StatusBadRequest},
+	} {
+		if status, res = postAction(t, hs.URL, c.token, c.body); status != c.status || res["error"] == nil {
+			t.Error(c.token, c.body, status, res)
+		}
+	}
+
+	status, _ = postAction(t, hs.URL, "admin", `{"Action": "Originate", "Channel": "PJSIP/100", "Async": true, "Variable": {"A": 1}, "ActionID": "x"}`)
+	if status != http.StatusOK {
+		t.Fatal(status)
+	}
+	received := srv.Received()
+	for _, req := range received {
+		if req["Action"] == "Command" || req["ActionID"] == "x" {
+			t.Error("unexpected request", req)
+		}
+	}
+	last := received[len(received)-1]
+	if last["Channel"] != "PJSIP/100" || last["Async"] != "true" || last["Variable"] != "A=1" {
+		t.Fatal(last)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv, hs, stop := startGateway(t)
+	defer stop()
+
+	resp, err := http.Get(hs.URL + "/ami/events?token=operator&Channel=PJSIP/1*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatal(resp.StatusCode, ct)
+	}
+
+	// operator token allows only Hangup events, filter allows only PJSIP/1* channels
+	srv.Send(ami.ActionData{"Event": "Newchannel", "Channel": "PJSIP/100-01"})
+	srv.Send(ami.ActionData{"Event": "Hangup", "Channel": "PJSIP/200-02"})
+	srv.Send(ami.ActionData{"Event": "Hangup", "Channel": "PJSIP/100-01", "Cause": "16"})
+
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "event: Hangup" {
+		t.Fatal(lines)
+	}
+	var data map[string]string
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &data); err != nil || data["Channel"] != "PJSIP/100-01" || data["Cause"] != "16" {
+		t.Fatal(lines[1], err)
+	}
+}
+
+func TestTokensFromConfig(t *testing.T) {
+	src := `
+[token]
+key = one
+actions = Ping, CoreShowChannels
+
+[token]
+key = two
+actions = *
+events = Hangup,Newchannel
+`
+	conf, err := config.FromReader("ini", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := TokensFromConfig(conf, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one := tokens["one"]; !one.ActionAllowed("ping") || one.ActionAllowed("Originate") || !one.EventAllowed("Any") {
+		t.Fatal(one)
+	}
+	if two := tokens["two"]; !two.ActionAllowed("Originate") || !two.EventAllowed("Hangup") || two.EventAllowed("Dial") {
+		t.Fatal(two)
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	e := ami.Event{ActionData: ami.ActionData{"Event": "Hangup", "Channel": "PJSIP/100-0001"}}
+	for _, query := range []string{"Channel=PJSIP*", "Channel=*", "name=hangup&Channel=PJSIP/1??-*"} {
+		values, _ := url.ParseQuery(query)
+		if f, err := parseEventFilter(values); err != nil || !f.match(e) {
+			t.Error(query, err)
+		}
+	}
+	values, _ := url.ParseQuery("Channel=SIP*")
+	if f, _ := parseEventFilter(values); f.match(e) {
+		t.Error("SIP*")
+	}
+}
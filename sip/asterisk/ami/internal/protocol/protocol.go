@@ -0,0 +1,70 @@
+// Package protocol provides the AMI wire format of the actions, shared by the ami client,
+// the proxy and the test server
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// headKeys are written at the start of the action source
+var headKeys = []string{"Action", "Response", "Event", "ActionID"}
+
+// Check returns error if the key or value of the action contains line break,
+// such field would be written as the other fields or actions
+func Check(action map[string]string) error {
+	for key, val := range action {
+		if strings.ContainsAny(key, "\r\n") || strings.ContainsAny(val, "\r\n") {
+			return fmt.Errorf("AMI action field %q contains line break", key)
+		}
+	}
+	return nil
+}
+
+// Encode returns source of the action. Action with line breaks in the fields is refused
+func Encode(action map[string]string) (res []byte, err error) {
+	if err = Check(action); err != nil {
+		return nil, err
+	}
+	for _, key := range headKeys {
+		if val, check := action[key]; check {
+			res = append(res, []byte(fmt.Sprintf("%v: %v\r\n", key, val))...)
+		}
+	}
+	for key, val := range action {
+		switch key {
+		case "Action", "Response", "Event", "ActionID":
+		default:
+			res = append(res, []byte(fmt.Sprintf("%v: %v\r\n", key, val))...)
+		}
+	}
+	res = append(res, []byte("\r\n")...)
+	return
+}
+
+func decode(src []byte) (res map[string]string) {
+	res, lines := make(map[string]string), bytes.Split(src, []byte("\r\n"))
+	/// todo...
+	for _, line := range lines {
+		parts := bytes.SplitN(line, []byte(":"), 2)
+		if len(parts) == 2 {
+			res[string(bytes.TrimSpace(parts[0]))] = string(bytes.TrimSpace(parts[1]))
+		}
+	}
+	return
+}
+
+// Parse parses complete actions of the source, accept is called for each action.
+// Returns not parsed tail of the source
+func Parse(src []byte, accept func(map[string]string)) (res []byte) {
+	if bytes.Index(src, []byte("\r\n\r\n")) < 0 {
+		return src
+	}
+	actionsRaw := bytes.Split(src, []byte("\r\n\r\n"))
+	for i := 0; i < len(actionsRaw)-1; i++ {
+		accept(decode(actionsRaw[i]))
+	}
+	res = actionsRaw[len(actionsRaw)-1]
+	return
+}
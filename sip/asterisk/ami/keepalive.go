@@ -0,0 +1,78 @@
+package ami
+
+import (
+	"fmt"
+	"time"
+)
+
+// KeepaliveConfig settings of the dead connection detection
+type KeepaliveConfig struct {
+	// Interval of the Ping action sending. 0 - ping disabled
+	Interval time.Duration
+	// MaxMissed is the count of ping responses missed in a row after which the connection is dead.
+	// Ping response is missed if it is not received during Interval. Default is 3
+	MaxMissed int
+	// ReadTimeout is the max silence time of the connection. 0 - disabled
+	ReadTimeout time.Duration
+}
+
+// SetKeepalive setup keepalive settings. Applied on the next Start call
+func (s *client) SetKeepalive(conf KeepaliveConfig) {
+	if conf.MaxMissed <= 0 {
+		conf.MaxMissed = 3
+	}
+	s.locker.Lock()
+	s.keepalive = conf
+	s.locker.Unlock()
+}
+
+func (s *client) keepaliveConfig() (res KeepaliveConfig) {
+	s.locker.RLock()
+	res = s.keepalive
+	s.locker.RUnlock()
+	return
+}
+
+// Latency returns round-trip time of the last ping
+func (s *client) Latency() (res time.Duration) {
+	s.locker.RLock()
+	res = s.latency
+	s.locker.RUnlock()
+	return
+}
+
+// LastPong returns time of the last received ping response
+func (s *client) LastPong() (res time.Time) {
+	s.locker.RLock()
+	res = s.lastPong
+	s.locker.RUnlock()
+	return
+}
+
+// keepaliveLoop sends ping actions until the session is stopped.
+// If ping responses are missed, connection error is sended to the main loop
+func (s *client) keepaliveLoop(sess *session, conf KeepaliveConfig) {
+	defer sess.goroutines.Done()
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-sess.stopped:
+			return
+		}
+		start := time.Now()
+		if resp, accepted := s.send(InitRequest("Ping"), conf.Interval); accepted && !resp.IsError() {
+			missed = 0
+			s.locker.Lock()
+			s.latency, s.lastPong = time.Since(start), time.Now()
+			s.locker.Unlock()
+			continue
+		}
+		if missed++; missed >= conf.MaxMissed {
+			sess.fail(fmt.Errorf("AMI keepalive error: %v ping responses missed, connection is dead", missed))
+			return
+		}
+	}
+}
@@ -3,8 +3,8 @@ package ami
 import (
 	"errors"
 	"fmt"
-	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,32 +12,73 @@ import (
 )
 
 func (s *Client) Originate(req *OriginateRequest) (*Originate, error) {
-	if s.state != StateAuth {
+	if s.State() != StateAuth {
 		return nil, errors.New("AMI IS NOT AUTH")
 	}
-	req.uuid = time.Now().UnixNano()
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("Originate request error: %v", err)
+	}
+	req.channelID = fmt.Sprint(time.Now().UnixNano())
+	req.actionID = s.InitActionID()
 	timeout := RequestTimeoutDefault
 	if req.Timeout > timeout {
 		timeout = req.Timeout + time.Millisecond*500
 	}
 
+	// listeners are registered before request, OriginateResponse event can be received before the action response
+	res := initOriginate(req, s)
 	resp, check := s.Request(req.Request(), timeout)
 	if !check {
+		res.Close()
 		return nil, errors.New("Originate request timeout")
 	}
 	if resp.IsError() {
+		res.Close()
 		return nil, fmt.Errorf("Originate error: %v", resp.ErrorMessage())
 	}
+	return res, nil
+}
 
-	res := initOriginate(req, s)
+// OriginateReason is the Reason value of the OriginateResponse event
+type OriginateReason byte
 
-	return res, nil
+const (
+	// ReasonFailed - channel is not created, for example the trunk is unavailable
+	ReasonFailed OriginateReason = 0
+	// ReasonHangup - remote side hangup before answer
+	ReasonHangup OriginateReason = 1
+	// ReasonRinging - no answer after the originate timeout
+	ReasonRinging    OriginateReason = 3
+	ReasonAnswered   OriginateReason = 4
+	ReasonBusy       OriginateReason = 5
+	ReasonCongestion OriginateReason = 8
+)
+
+func (s OriginateReason) String() string {
+	switch s {
+	case ReasonFailed:
+		return "Failed"
+	case ReasonHangup:
+		return "Hangup"
+	case ReasonRinging:
+		return "No answer"
+	case ReasonAnswered:
+		return "Answered"
+	case ReasonBusy:
+		return "Busy"
+	case ReasonCongestion:
+		return "Congestion"
+	default:
+		return fmt.Sprintf("Reason %d", byte(s))
+	}
 }
 
 //////////////////////////////////////////////////////////////////
 
 type OriginateRequest struct {
-	Channel     string
+	Channel string
+	// Target is the typed channel, if defined, the Channel value is ignored
+	Target      *DialString
 	Context     string
 	Exten       string
 	Priority    string
@@ -47,12 +88,40 @@ type OriginateRequest struct {
 	Account     string
 	Application string
 	Data        string
-	uuid        int64
+	channelID   string
+	actionID    string
+}
+
+// channel returns dial string of the originated channel
+func (s *OriginateRequest) channel() string {
+	if s.Target != nil {
+		return s.Target.String()
+	}
+	return s.Channel
+}
+
+// Validate checks the destination of the originated channel and the typed Target. The free-form
+// Channel is passed as is, so the channel drivers unknown for ParseDialString are allowed
+func (s *OriginateRequest) Validate() error {
+	if s.Target != nil {
+		if err := s.Target.Validate(); err != nil {
+			return fmt.Errorf("channel %v: %v", s.Target, err)
+		}
+	} else if strings.TrimSpace(s.Channel) == "" {
+		return errors.New("Channel is not defined")
+	}
+	if s.Application == "" && s.Exten == "" {
+		return errors.New("Application or Exten must be defined")
+	}
+	if s.Application == "" && s.Context == "" {
+		return errors.New("Context of the Exten is not defined")
+	}
+	return nil
 }
 
 func (s *OriginateRequest) Request() (res Request) {
 	res = InitRequest("Originate")
-	res.SetParam("Channel", s.Channel)
+	res.SetParam("Channel", s.channel())
 	res.SetParam("Context", s.Context)
 	res.SetParam("Exten", s.Exten)
 	if s.Timeout > 0 {
@@ -64,7 +133,8 @@ func (s *OriginateRequest) Request() (res Request) {
 	res.SetParam("Application", s.Application)
 	res.SetParam("Data", s.Data)
 	res.SetParam("Async", "true")
-	res.SetParam("ChannelID", fmt.Sprint(s.uuid))
+	res.SetParam("ChannelID", s.channelID)
+	res.SetParam("ActionID", s.actionID)
 	res.SetVariables(s.Variable)
 	return res
 }
@@ -72,9 +142,14 @@ func (s *OriginateRequest) Request() (res Request) {
 /////////////////////////////////////////////////////////////////
 
 func initOriginate(req *OriginateRequest, client *Client) *Originate {
+	// OriginateResponse of the failed originate has no Uniqueid, so it is matched by ActionID.
+	// Hangup of the failed channel can be received before the response, so channel listener
+	// is closed by Originate after both events
 	res := &Originate{
 		OriginateRequest: req,
-		eventChan:        client.registerEventListener(req.uuid),
+		listener:         client.ListenUniqueid(req.channelID, ListenerConfig{}),
+		response:         client.ListenActionID(req.actionID, ListenerConfig{}),
+		responded:        make(chan struct{}),
 		locker:           new(sync.RWMutex),
 		client:           client,
 	}
@@ -84,56 +159,126 @@ func initOriginate(req *OriginateRequest, client *Client) *Originate {
 
 type Originate struct {
 	*OriginateRequest
-	eventChan      <-chan Event
+	listener       *EventListener
+	response       *EventListener
+	responded      chan struct{}
+	responseOnce   sync.Once
 	userEventChan  chan Event
 	locker         *sync.RWMutex
 	finished       bool
 	err            error
 	client         *Client
 	responseReason byte
+	hasResponse    bool
 	hangupCause    byte
 }
 
 func (s *Originate) listenEvents() {
+	defer s.finish()
+	responses := s.response.Events()
+	var hangup bool
 	for {
-		e, ok := <-s.eventChan
+		var e Event
+		var ok bool
+		select {
+		case e, ok = <-s.listener.Events():
+		case e, ok = <-responses:
+		}
 		if !ok {
-			s.finished = true
-			close(s.userEventChan)
 			return
 		}
+		isResponse := e.Name() == "OriginateResponse"
+		if isResponse && s.hasResponse {
+			// response with Uniqueid is received by both listeners
+			continue
+		}
 		s.locker.RLock()
 		if s.userEventChan != nil {
 			s.userEventChan <- e
 		}
 		s.locker.RUnlock()
-		switch e.Name() {
-		case "OriginateResponse":
+		switch {
+		case isResponse:
+			s.locker.Lock()
 			if reason, check := e.ActionData["Reason"]; check {
 				reasonVal, _ := strconv.ParseInt(reason, 10, 32)
 				s.responseReason = byte(reasonVal)
 			}
-			log.Println("RREASON", s.responseReason)
-			//if s.responseReason != 4 {
-			//s.client.removeEventListener(s.uuid)
-			//}
-		case "Hangup":
+			s.hasResponse = true
+			s.locker.Unlock()
+			s.responseOnce.Do(func() { close(s.responded) })
+			s.response.Close()
+			responses = nil
+			if hangup || OriginateReason(s.responseReason) != ReasonAnswered {
+				// call is not answered, hangup event will not be received
+				return
+			}
+		case e.Name() == "Hangup":
 			if cause, check := e.ActionData["Cause"]; check {
 				causeVal, _ := strconv.ParseInt(cause, 10, 32)
 				s.hangupCause = byte(causeVal)
 			}
+			if s.hasResponse {
+				return
+			}
+			hangup = true
 		}
 	}
 }
 
-func (s *Originate) IsFinished() bool {
-	return s.finished
+// finish closes listeners and events channel of the originate
+func (s *Originate) finish() {
+	s.listener.Close()
+	s.response.Close()
+	s.responseOnce.Do(func() { close(s.responded) })
+	s.locker.Lock()
+	s.finished = true
+	if s.userEventChan != nil {
+		close(s.userEventChan)
+	}
+	s.locker.Unlock()
+}
+
+func (s *Originate) IsFinished() (res bool) {
+	s.locker.RLock()
+	res = s.finished
+	s.locker.RUnlock()
+	return
+}
+
+// WaitResponse waits OriginateResponse event. Returns false on timeout or if the event
+// is not received before the listener close
+func (s *Originate) WaitResponse(timeout time.Duration) (reason OriginateReason, check bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-s.responded:
+		s.locker.RLock()
+		reason, check = OriginateReason(s.responseReason), s.hasResponse
+		s.locker.RUnlock()
+	case <-timer.C:
+	}
+	return
+}
+
+// Uniqueid returns unique id of the originated channel
+func (s *Originate) Uniqueid() string {
+	return s.channelID
+}
+
+// Close stops listening of the originated channel events
+func (s *Originate) Close() {
+	s.listener.Close()
+	s.response.Close()
 }
 
 func (s *Originate) Events() (res <-chan Event) {
 	s.locker.Lock()
 	if s.userEventChan == nil {
 		s.userEventChan = make(chan Event)
+		if s.finished {
+			close(s.userEventChan)
+		}
 	}
 	res = s.userEventChan
 	s.locker.Unlock()
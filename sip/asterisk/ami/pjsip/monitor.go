@@ -0,0 +1,568 @@
+// Package pjsip provides the monitor of the PJSIP endpoints, contacts and outbound registrations.
+//
+// Monitor loads state by PJSIPShowEndpoints, PJSIPShowContacts and PJSIPShowRegistrationsOutbound
+// actions and updates it by ContactStatus, PeerStatus and Registry events:
+//
+//	mon := pjsip.New(client, pjsip.Config{
+//		OnEndpoint: func(old, cur pjsip.Endpoint) {
+//			log.Printf("endpoint %v: %v -> %v", cur.Name, old.Status, cur.Status)
+//		},
+//		ReloadInterval: time.Minute,
+//	})
+//	if err := mon.Load(time.Second * 5); err != nil {
+//		...
+//	}
+package pjsip
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+// Statuses of the endpoints and contacts
+const (
+	StatusReachable    = "Reachable"
+	StatusUnreachable  = "Unreachable"
+	StatusNonQualified = "NonQualified"
+	StatusUnknown      = "Unknown"
+	StatusRemoved      = "Removed"
+	// StatusUnavailable is the status of the endpoint without contacts
+	StatusUnavailable = "Unavailable"
+)
+
+// Endpoint is the PJSIP endpoint state
+type Endpoint struct {
+	Name           string
+	Transport      string
+	Aor            string
+	DeviceState    string
+	ActiveChannels int
+	// Status is Reachable, Unreachable, Unknown or Unavailable
+	Status  string
+	Updated time.Time
+	seq     uint64 // sequence of the last event update
+}
+
+// Contact is the state of the AOR contact
+type Contact struct {
+	URI       string
+	Aor       string
+	Endpoint  string
+	Status    string
+	RTT       time.Duration
+	UserAgent string
+	Updated   time.Time
+	seq       uint64
+}
+
+func (s Contact) key() string { return s.Aor + "|" + s.URI }
+
+// Registration is the state of the outbound registration
+type Registration struct {
+	Name      string
+	ServerURI string
+	ClientURI string
+	// Status is Registered, Unregistered, Rejected or Failed
+	Status  string
+	Cause   string
+	Updated time.Time
+	seq     uint64
+}
+
+// Config of the monitor. Callbacks are called only if status is changed, other values
+// (for example contact RTT) are updated silently
+type Config struct {
+	OnEndpoint     func(old, cur Endpoint)
+	OnContact      func(old, cur Contact)
+	OnRegistration func(old, cur Registration)
+	// ReloadInterval of the full state reload, 0 disables reload.
+	// Reload restores the state after AMI reconnection
+	ReloadInterval time.Duration
+	// Timeout of the reload requests, ami.RequestTimeoutDefault by default
+	Timeout time.Duration
+}
+
+// New creates monitor and starts events listening. Initial state must be loaded by Load method
+func New(client *ami.Client, conf Config) *Monitor {
+	if conf.Timeout == 0 {
+		conf.Timeout = ami.RequestTimeoutDefault
+	}
+	s := &Monitor{
+		client:        client,
+		conf:          conf,
+		endpoints:     make(map[string]*Endpoint),
+		contacts:      make(map[string]*Contact),
+		registrations: make(map[string]*Registration),
+		removed:       make(map[string]uint64),
+		locker:        new(sync.RWMutex),
+		listener:      client.ListenEvents([]string{"ContactStatus", "PeerStatus", "Registry"}, ami.ListenerConfig{BufferSize: 64}),
+		stopped:       make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Monitor keeps the table of the PJSIP endpoints, contacts and outbound registrations
+type Monitor struct {
+	client        *ami.Client
+	conf          Config
+	endpoints     map[string]*Endpoint
+	contacts      map[string]*Contact
+	registrations map[string]*Registration
+	removed       map[string]uint64 // sequences of the contacts removed by the events
+	seq           uint64            // sequence of the last accepted event
+	loaded        bool
+	loading       int32 // reload by the interval is in progress
+	locker        *sync.RWMutex
+	listener      *ami.EventListener
+	stopped       chan struct{}
+}
+
+func (s *Monitor) run() {
+	defer close(s.stopped)
+	var reload <-chan time.Time
+	if s.conf.ReloadInterval > 0 {
+		ticker := time.NewTicker(s.conf.ReloadInterval)
+		defer ticker.Stop()
+		reload = ticker.C
+	}
+	for {
+		select {
+		case e, check := <-s.listener.Events():
+			if !check {
+				return
+			}
+			s.eventAccepted(e)
+		case <-reload:
+			// the tick is skipped while the previous reload is not finished
+			if atomic.CompareAndSwapInt32(&s.loading, 0, 1) {
+				go func() {
+					// reload error is ignored, the state is kept until next reload
+					s.Load(s.conf.Timeout)
+					atomic.StoreInt32(&s.loading, 0)
+				}()
+			}
+		}
+	}
+}
+
+// Close stops events listening
+func (s *Monitor) Close() {
+	s.listener.Close()
+	<-s.stopped
+}
+
+func requestList(client *ami.Client, action string, timeout time.Duration) ([]ami.Event, error) {
+	resp, events, accepted := client.RequestList(ami.InitRequest(action), timeout)
+	if !accepted {
+		return nil, fmt.Errorf("PJSIP monitor: %v timeout", action)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("PJSIP monitor: %v error: %v", action, resp.ErrorMessage())
+	}
+	return events, nil
+}
+
+// Load requests the full state from asterisk and merges it with the current state. Items updated by the events
+// received during the load are kept, they are newer than the loaded ones. Changes found by comparison with
+// the previous state are reported to the callbacks, the first load is silent
+func (s *Monitor) Load(timeout time.Duration) (err error) {
+	s.locker.RLock()
+	seq := s.seq
+	s.locker.RUnlock()
+	var endpointEvents, contactEvents, registrationEvents []ami.Event
+	if endpointEvents, err = requestList(s.client, "PJSIPShowEndpoints", timeout); err != nil {
+		return
+	}
+	if contactEvents, err = requestList(s.client, "PJSIPShowContacts", timeout); err != nil {
+		return
+	}
+	if registrationEvents, err = requestList(s.client, "PJSIPShowRegistrationsOutbound", timeout); err != nil {
+		return
+	}
+	now := time.Now()
+
+	contacts := make(map[string]*Contact)
+	for _, e := range contactEvents {
+		if e.Name() != "ContactList" {
+			continue
+		}
+		c := contactFromList(e, now)
+		contacts[c.key()] = c
+	}
+	endpoints := make(map[string]*Endpoint)
+	for _, e := range endpointEvents {
+		if e.Name() != "EndpointList" {
+			continue
+		}
+		ep := &Endpoint{
+			Name:        e.ActionData["ObjectName"],
+			Transport:   e.ActionData["Transport"],
+			Aor:         e.ActionData["Aor"],
+			DeviceState: e.ActionData["DeviceState"],
+			Updated:     now,
+		}
+		ep.ActiveChannels, _ = strconv.Atoi(e.ActionData["ActiveChannels"])
+		endpoints[ep.Name] = ep
+	}
+	registrations := make(map[string]*Registration)
+	for _, e := range registrationEvents {
+		if e.Name() != "OutboundRegistrationDetail" {
+			continue
+		}
+		r := &Registration{
+			Name:      e.ActionData["ObjectName"],
+			ServerURI: e.ActionData["ServerUri"],
+			ClientURI: e.ActionData["ClientUri"],
+			Status:    e.ActionData["Status"],
+			Updated:   now,
+		}
+		registrations[r.Name] = r
+	}
+
+	var changes []func()
+	s.locker.Lock()
+	// endpoint status is calculated by the merged contacts
+	changes = append(changes, s.mergeContacts(contacts, seq)...)
+	changes = append(changes, s.mergeEndpoints(endpoints, seq)...)
+	changes = append(changes, s.mergeRegistrations(registrations, seq)...)
+	for key, removedSeq := range s.removed {
+		if removedSeq <= seq {
+			delete(s.removed, key)
+		}
+	}
+	if !s.loaded {
+		s.loaded, changes = true, nil
+	}
+	s.locker.Unlock()
+	for _, change := range changes {
+		change()
+	}
+	return
+}
+
+// mergeEndpoints stores loaded endpoints and returns callbacks of the changed endpoints.
+// Endpoints updated by the events after the seq are kept
+func (s *Monitor) mergeEndpoints(endpoints map[string]*Endpoint, seq uint64) (changes []func()) {
+	for name, ep := range endpoints {
+		old, check := s.endpoints[name]
+		if check && old.seq > seq {
+			continue
+		}
+		if !check {
+			old = &Endpoint{Name: name}
+		}
+		ep.Status = endpointStatus(ep, s.contacts)
+		changes = append(changes, s.endpointChanged(*old, *ep)...)
+		s.endpoints[name] = ep
+	}
+	for name, old := range s.endpoints {
+		if _, check := endpoints[name]; !check && old.seq <= seq {
+			changes = append(changes, s.endpointChanged(*old, Endpoint{Name: name, Status: StatusRemoved})...)
+			delete(s.endpoints, name)
+		}
+	}
+	return
+}
+
+// mergeContacts stores loaded contacts, contacts updated or removed by the events after the seq are kept
+func (s *Monitor) mergeContacts(contacts map[string]*Contact, seq uint64) (changes []func()) {
+	for key, c := range contacts {
+		old, check := s.contacts[key]
+		if (check && old.seq > seq) || s.removed[key] > seq {
+			continue
+		}
+		if !check {
+			old = &Contact{URI: c.URI, Aor: c.Aor, Endpoint: c.Endpoint}
+		}
+		changes = append(changes, s.contactChanged(*old, *c)...)
+		s.contacts[key] = c
+	}
+	for key, old := range s.contacts {
+		if _, check := contacts[key]; !check && old.seq <= seq {
+			removed := *old
+			removed.Status = StatusRemoved
+			changes = append(changes, s.contactChanged(*old, removed)...)
+			delete(s.contacts, key)
+		}
+	}
+	return
+}
+
+// mergeRegistrations stores loaded registrations, registrations updated by the events after the seq are kept
+func (s *Monitor) mergeRegistrations(registrations map[string]*Registration, seq uint64) (changes []func()) {
+	for name, r := range registrations {
+		old, check := s.registrations[name]
+		if check && old.seq > seq {
+			continue
+		}
+		if !check {
+			old = &Registration{Name: name, ServerURI: r.ServerURI, ClientURI: r.ClientURI}
+		}
+		changes = append(changes, s.registrationChanged(*old, *r)...)
+		s.registrations[name] = r
+	}
+	for name, old := range s.registrations {
+		if _, check := registrations[name]; !check && old.seq <= seq {
+			removed := *old
+			removed.Status = StatusRemoved
+			changes = append(changes, s.registrationChanged(*old, removed)...)
+			delete(s.registrations, name)
+		}
+	}
+	return
+}
+
+func (s *Monitor) endpointChanged(old, cur Endpoint) []func() {
+	if old.Status == cur.Status || s.conf.OnEndpoint == nil {
+		return nil
+	}
+	return []func(){func() { s.conf.OnEndpoint(old, cur) }}
+}
+
+func (s *Monitor) contactChanged(old, cur Contact) []func() {
+	if old.Status == cur.Status || s.conf.OnContact == nil {
+		return nil
+	}
+	return []func(){func() { s.conf.OnContact(old, cur) }}
+}
+
+func (s *Monitor) registrationChanged(old, cur Registration) []func() {
+	if old.Status == cur.Status || s.conf.OnRegistration == nil {
+		return nil
+	}
+	return []func(){func() { s.conf.OnRegistration(old, cur) }}
+}
+
+// endpointStatus returns endpoint status calculated by the endpoint contacts
+func endpointStatus(ep *Endpoint, contacts map[string]*Contact) string {
+	status := StatusUnavailable
+	for _, c := range contacts {
+		if c.Endpoint != ep.Name && (c.Endpoint != "" || !containsAor(ep.Aor, c.Aor)) {
+			continue
+		}
+		switch c.Status {
+		case StatusReachable:
+			return StatusReachable
+		case StatusUnreachable:
+			if status == StatusUnavailable {
+				status = StatusUnreachable
+			}
+		default:
+			status = StatusUnknown
+		}
+	}
+	return status
+}
+
+func containsAor(aors, aor string) bool {
+	for _, v := range strings.Split(aors, ",") {
+		if strings.TrimSpace(v) == aor {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRTT converts RoundtripUsec value, "N/A" and empty values are returned as 0
+func parseRTT(src string) time.Duration {
+	usec, err := strconv.ParseInt(src, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+func contactFromList(e ami.Event, now time.Time) *Contact {
+	c := &Contact{
+		URI:       e.ActionData["Uri"],
+		Aor:       e.ActionData["Aor"],
+		Endpoint:  e.ActionData["Endpoint"],
+		Status:    e.ActionData["Status"],
+		RTT:       parseRTT(e.ActionData["RoundtripUsec"]),
+		UserAgent: e.ActionData["UserAgent"],
+		Updated:   now,
+	}
+	// ObjectName of the contact is "aor;@hash"
+	if c.Aor == "" {
+		c.Aor = strings.SplitN(e.ActionData["ObjectName"], ";", 2)[0]
+	}
+	return c
+}
+
+func (s *Monitor) eventAccepted(e ami.Event) {
+	var changes []func()
+	s.locker.Lock()
+	s.seq++
+	switch e.Name() {
+	case "ContactStatus":
+		changes = s.contactStatus(e)
+	case "PeerStatus":
+		changes = s.peerStatus(e)
+	case "Registry":
+		changes = s.registry(e)
+	}
+	s.locker.Unlock()
+	for _, change := range changes {
+		change()
+	}
+}
+
+func (s *Monitor) contactStatus(e ami.Event) (changes []func()) {
+	cur := Contact{
+		URI:       e.ActionData["URI"],
+		Aor:       e.ActionData["AOR"],
+		Endpoint:  e.ActionData["EndpointName"],
+		Status:    e.ActionData["ContactStatus"],
+		RTT:       parseRTT(e.ActionData["RoundtripUsec"]),
+		UserAgent: e.ActionData["UserAgent"],
+		Updated:   time.Now(),
+		seq:       s.seq,
+	}
+	key := cur.key()
+	old, check := s.contacts[key]
+	if !check {
+		old = &Contact{URI: cur.URI, Aor: cur.Aor, Endpoint: cur.Endpoint}
+	}
+	switch cur.Status {
+	case "Created", "Updated":
+		// contact registration, status is unknown until qualify
+		if check {
+			cur.Status = old.Status
+		} else {
+			cur.Status = StatusUnknown
+		}
+		if cur.UserAgent == "" {
+			cur.UserAgent = old.UserAgent
+		}
+	}
+	if cur.Endpoint == "" {
+		cur.Endpoint = old.Endpoint
+	}
+	if cur.Status == StatusRemoved {
+		delete(s.contacts, key)
+		s.removed[key] = s.seq
+	} else {
+		s.contacts[key] = &cur
+	}
+	changes = s.contactChanged(*old, cur)
+
+	// endpoint status follows the contacts
+	for _, ep := range s.endpoints {
+		if ep.Name == cur.Endpoint || (cur.Endpoint == "" && containsAor(ep.Aor, cur.Aor)) {
+			changes = append(changes, s.setEndpointStatus(ep, endpointStatus(ep, s.contacts))...)
+		}
+	}
+	return
+}
+
+func (s *Monitor) setEndpointStatus(ep *Endpoint, status string) []func() {
+	old := *ep
+	ep.Status, ep.Updated, ep.seq = status, time.Now(), s.seq
+	return s.endpointChanged(old, *ep)
+}
+
+func (s *Monitor) peerStatus(e ami.Event) []func() {
+	peer := e.ActionData["Peer"]
+	if !strings.HasPrefix(peer, "PJSIP/") {
+		return nil
+	}
+	name := strings.TrimPrefix(peer, "PJSIP/")
+	ep, check := s.endpoints[name]
+	if !check {
+		ep = &Endpoint{Name: name}
+		s.endpoints[name] = ep
+	}
+	switch status := e.ActionData["PeerStatus"]; status {
+	case StatusReachable, StatusUnreachable:
+		return s.setEndpointStatus(ep, status)
+	}
+	return nil
+}
+
+func (s *Monitor) registry(e ami.Event) []func() {
+	if !strings.EqualFold(e.ActionData["ChannelType"], "pjsip") {
+		return nil
+	}
+	clientURI, serverURI := e.ActionData["Username"], e.ActionData["Domain"]
+	var r *Registration
+	for _, v := range s.registrations {
+		if v.ClientURI == clientURI && (v.ServerURI == serverURI || r == nil) {
+			r = v
+		}
+	}
+	if r == nil {
+		r = &Registration{Name: clientURI, ClientURI: clientURI, ServerURI: serverURI}
+		s.registrations[r.Name] = r
+	}
+	old := *r
+	r.Status, r.Cause, r.Updated, r.seq = e.ActionData["Status"], e.ActionData["Cause"], time.Now(), s.seq
+	return s.registrationChanged(old, *r)
+}
+
+////////////////////////////////////////////////////////////////// state
+
+// Endpoints returns endpoints sorted by name
+func (s *Monitor) Endpoints() (res []Endpoint) {
+	s.locker.RLock()
+	for _, ep := range s.endpoints {
+		res = append(res, *ep)
+	}
+	s.locker.RUnlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return
+}
+
+// Endpoint returns endpoint by name
+func (s *Monitor) Endpoint(name string) (res Endpoint, check bool) {
+	s.locker.RLock()
+	var ep *Endpoint
+	if ep, check = s.endpoints[name]; check {
+		res = *ep
+	}
+	s.locker.RUnlock()
+	return
+}
+
+// Contacts returns contacts sorted by AOR and URI. If endpoint is not empty, returns only
+// the endpoint contacts
+func (s *Monitor) Contacts(endpoint string) (res []Contact) {
+	s.locker.RLock()
+	for _, c := range s.contacts {
+		if endpoint == "" || c.Endpoint == endpoint {
+			res = append(res, *c)
+		}
+	}
+	s.locker.RUnlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].key() < res[j].key() })
+	return
+}
+
+// Registrations returns outbound registrations sorted by name
+func (s *Monitor) Registrations() (res []Registration) {
+	s.locker.RLock()
+	for _, r := range s.registrations {
+		res = append(res, *r)
+	}
+	s.locker.RUnlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return
+}
+
+// Registration returns outbound registration by name
+func (s *Monitor) Registration(name string) (res Registration, check bool) {
+	s.locker.RLock()
+	var r *Registration
+	if r, check = s.registrations[name]; check {
+		res = *r
+	}
+	s.locker.RUnlock()
+	return
+}
@@ -0,0 +1,197 @@
+package pjsip
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami/amitest"
+)
+
+type changes struct {
+	list   []string
+	locker sync.Mutex
+}
+
+func (s *changes) add(format string, args ...interface{}) {
+	s.locker.Lock()
+	s.list = append(s.list, fmt.Sprintf(format, args...))
+	s.locker.Unlock()
+}
+
+func (s *changes) wait(t *testing.T, count int) []string {
+	deadline := time.Now().Add(time.Second * 2)
+	for time.Now().Before(deadline) {
+		s.locker.Lock()
+		if len(s.list) >= count {
+			res := append([]string(nil), s.list...)
+			s.list = s.list[:0]
+			s.locker.Unlock()
+			return res
+		}
+		s.locker.Unlock()
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("expected %v changes, given %v", count, s.list)
+	return nil
+}
+
+func TestMonitor(t *testing.T) {
+	srv := amitest.NewServer(amitest.DefaultBanner)
+	defer srv.Close()
+	srv.Handle("PJSIPShowEndpoints", amitest.List("EndpointList", "EndpointListComplete",
+		ami.ActionData{"ObjectName": "100", "Transport": "udp", "Aor": "100", "DeviceState": "Not in use", "ActiveChannels": "0"},
+		ami.ActionData{"ObjectName": "200", "Transport": "udp", "Aor": "200", "DeviceState": "Unavailable", "ActiveChannels": "0"},
+		ami.ActionData{"ObjectName": "trunk", "Transport": "udp", "Aor": "trunk", "DeviceState": "Not in use", "ActiveChannels": "2"},
+	))
+	srv.Handle("PJSIPShowContacts", amitest.List("ContactList", "ContactListComplete",
+		ami.ActionData{"ObjectName": "100;@a1", "Uri": "sip:100@10.0.0.1:5060", "Endpoint": "100", "Status": "Reachable", "RoundtripUsec": "1500"},
+		ami.ActionData{"ObjectName": "trunk;@b2", "Uri": "sip:10.0.0.254", "Aor": "trunk", "Status": "NonQualified", "RoundtripUsec": "N/A"},
+	))
+	srv.Handle("PJSIPShowRegistrationsOutbound", amitest.List("OutboundRegistrationDetail", "OutboundRegistrationDetailComplete",
+		ami.ActionData{"ObjectName": "provider", "ServerUri": "sip:sip.provider.net", "ClientUri": "sip:user@sip.provider.net", "Status": "Registered"},
+	))
+	cl, err := amitest.StartClient(srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	ch := new(changes)
+	mon := New(cl, Config{
+		OnEndpoint: func(old, cur Endpoint) { ch.add("endpoint %v %v>%v", cur.Name, old.Status, cur.Status) },
+		OnContact: func(old, cur Contact) {
+			ch.add("contact %v %v>%v %v", cur.URI, old.Status, cur.Status, cur.RTT)
+		},
+		OnRegistration: func(old, cur Registration) { ch.add("registration %v %v>%v", cur.Name, old.Status, cur.Status) },
+	})
+	defer mon.Close()
+	if err = mon.Load(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := mon.Endpoints()
+	if len(endpoints) != 3 || endpoints[0].Status != StatusReachable || endpoints[1].Status != StatusUnavailable ||
+		endpoints[2].Status != StatusUnknown || endpoints[2].ActiveChannels != 2 {
+		t.Fatal(endpoints)
+	}
+	if contacts := mon.Contacts("100"); len(contacts) != 1 || contacts[0].RTT != time.Microsecond*1500 {
+		t.Fatal(contacts)
+	}
+	if r, check := mon.Registration("provider"); !check || r.Status != "Registered" {
+		t.Fatal(r)
+	}
+
+	srv.Send(ami.ActionData{"Event": "ContactStatus", "URI": "sip:100@10.0.0.1:5060", "ContactStatus": "Unreachable",
+		"AOR": "100", "EndpointName": "100", "RoundtripUsec": "0"})
+	srv.Send(ami.ActionData{"Event": "PeerStatus", "ChannelType": "PJSIP", "Peer": "PJSIP/100", "PeerStatus": "Unreachable"})
+	srv.Send(ami.ActionData{"Event": "ContactStatus", "URI": "sip:200@10.0.0.2:5060", "ContactStatus": "Created",
+		"AOR": "200", "EndpointName": "200"})
+	srv.Send(ami.ActionData{"Event": "ContactStatus", "URI": "sip:200@10.0.0.2:5060", "ContactStatus": "Reachable",
+		"AOR": "200", "EndpointName": "200", "RoundtripUsec": "2000"})
+	srv.Send(ami.ActionData{"Event": "Registry", "ChannelType": "pjsip", "Username": "sip:user@sip.provider.net",
+		"Domain": "sip:sip.provider.net", "Status": "Rejected", "Cause": "403"})
+
+	res := ch.wait(t, 7)
+	expected := []string{
+		"contact sip:100@10.0.0.1:5060 Reachable>Unreachable 0s",
+		"endpoint 100 Reachable>Unreachable",
+		"contact sip:200@10.0.0.2:5060 >Unknown 0s",
+		"endpoint 200 Unavailable>Unknown",
+		"contact sip:200@10.0.0.2:5060 Unknown>Reachable 2ms",
+		"endpoint 200 Unknown>Reachable",
+		"registration provider Registered>Rejected",
+	}
+	for i, v := range expected {
+		if res[i] != v {
+			t.Errorf("change %v: expected %q, given %q", i, v, res[i])
+		}
+	}
+	if r, _ := mon.Registration("provider"); r.Cause != "403" {
+		t.Error(r)
+	}
+
+	// reload finds removed contact
+	srv.Handle("PJSIPShowContacts", amitest.List("ContactList", "ContactListComplete"))
+	if err = mon.Load(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	res = ch.wait(t, 7)
+	if len(mon.Contacts("")) != 0 || mon.Registrations()[0].Status != "Registered" {
+		t.Error(res, mon.Contacts(""), mon.Registrations())
+	}
+}
+
+func TestMonitorLoadMerge(t *testing.T) {
+	srv := amitest.NewServer(amitest.DefaultBanner)
+	defer srv.Close()
+	srv.Handle("PJSIPShowEndpoints", amitest.List("EndpointList", "EndpointListComplete",
+		ami.ActionData{"ObjectName": "100", "Aor": "100"},
+	))
+	srv.Handle("PJSIPShowContacts", amitest.List("ContactList", "ContactListComplete",
+		ami.ActionData{"ObjectName": "100;@a1", "Uri": "sip:100@10.0.0.1:5060", "Endpoint": "100", "Status": "Reachable"},
+	))
+	srv.Handle("PJSIPShowRegistrationsOutbound", amitest.List("OutboundRegistrationDetail", "OutboundRegistrationDetailComplete"))
+	cl, err := amitest.StartClient(srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	mon := New(cl, Config{})
+	defer mon.Close()
+	if err = mon.Load(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event is accepted while the load, the loaded contact status is older
+	srv.Handle("PJSIPShowContacts", func(req ami.ActionData) []ami.ActionData {
+		srv.Send(ami.ActionData{"Event": "ContactStatus", "URI": "sip:100@10.0.0.1:5060", "ContactStatus": "Unreachable",
+			"AOR": "100", "EndpointName": "100"})
+		for deadline := time.Now().Add(time.Second * 2); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if contacts := mon.Contacts("100"); len(contacts) == 1 && contacts[0].Status == StatusUnreachable {
+				break
+			}
+		}
+		return amitest.List("ContactList", "ContactListComplete",
+			ami.ActionData{"ObjectName": "100;@a1", "Uri": "sip:100@10.0.0.1:5060", "Endpoint": "100", "Status": "Reachable"},
+		)(req)
+	})
+	if err = mon.Load(time.Second * 5); err != nil {
+		t.Fatal(err)
+	}
+	if contacts := mon.Contacts("100"); len(contacts) != 1 || contacts[0].Status != StatusUnreachable {
+		t.Error(contacts)
+	}
+	if ep, _ := mon.Endpoint("100"); ep.Status != StatusUnreachable {
+		t.Error(ep)
+	}
+}
+
+func TestMonitorReload(t *testing.T) {
+	srv := amitest.NewServer(amitest.DefaultBanner)
+	defer srv.Close()
+	srv.Handle("PJSIPShowEndpoints", func(req ami.ActionData) []ami.ActionData {
+		time.Sleep(time.Millisecond * 100)
+		return amitest.List("EndpointList", "EndpointListComplete")(req)
+	})
+	srv.Handle("PJSIPShowContacts", amitest.List("ContactList", "ContactListComplete"))
+	srv.Handle("PJSIPShowRegistrationsOutbound", amitest.List("OutboundRegistrationDetail", "OutboundRegistrationDetailComplete"))
+	cl, err := amitest.StartClient(srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	// ticks are skipped while the slow reload is in progress
+	mon := New(cl, Config{ReloadInterval: time.Millisecond * 10})
+	defer mon.Close()
+	for i := 0; i < 25; i++ {
+		time.Sleep(time.Millisecond * 10)
+		if count := cl.PendingCount(); count > 1 {
+			t.Fatalf("unexpected pending requests count %v", count)
+		}
+	}
+}
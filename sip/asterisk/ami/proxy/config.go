@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fcg-xvii/go-tools/text/config"
+)
+
+func splitList(src string) (res []string) {
+	for _, v := range strings.Split(src, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return
+}
+
+// UsersFromConfig reads downstream users from the config sections with name sectionName.
+// Value filter contains space separated header patterns:
+//
+//	[user]
+//	login = crm
+//	password = secret
+//	actions = Ping, Originate, CoreShowChannels
+//	events = Newchannel, Hangup, Dial*
+//	filter = Channel=PJSIP/* Context=from-crm
+func UsersFromConfig(conf config.Config, sectionName string) (res []User, err error) {
+	sections, check := conf.Sections(sectionName)
+	if !check {
+		return nil, fmt.Errorf("AMI proxy config: sections [%v] are not found", sectionName)
+	}
+	logins := make(map[string]bool)
+	for i, section := range sections {
+		var login, password, actions, events, filter string
+		section.ValueSetup("login", &login)
+		section.ValueSetup("password", &password)
+		section.ValueSetup("actions", &actions)
+		section.ValueSetup("events", &events)
+		section.ValueSetup("filter", &filter)
+		if login == "" {
+			return nil, fmt.Errorf("AMI proxy config: login of the user %v is empty", i)
+		}
+		if logins[login] {
+			return nil, fmt.Errorf("AMI proxy config: user %v is duplicated", login)
+		}
+		logins[login] = true
+		user := User{
+			Login:    login,
+			Password: password,
+			Actions:  splitList(actions),
+			Events:   splitList(events),
+		}
+		for _, item := range strings.Fields(filter) {
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("AMI proxy config: invalid filter %v of the user %v", item, login)
+			}
+			if user.Filter == nil {
+				user.Filter = make(map[string]string)
+			}
+			user.Filter[parts[0]] = parts[1]
+		}
+		res = append(res, user)
+	}
+	return
+}
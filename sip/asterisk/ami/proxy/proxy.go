@@ -0,0 +1,281 @@
+// Package proxy provides AMI multiplexing server. Proxy holds single upstream ami.Client
+// and accepts many downstream AMI clients with own credentials.
+//
+// Downstream actions are sended by the upstream client, so ActionIDs are replaced by the
+// client ones and restored in the responses and event lists. Events with ActionID of the forwarded
+// action, like OriginateResponse of the async Originate, are delivered only to the session of the
+// action with the restored ActionID. Other events are delivered to the
+// downstream clients according to the user event filters, actions are checked by the user
+// permissions. Actions Login, Logoff, Ping and Events are served by proxy itself.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+// sessionEventsSize is the events buffer size of the downstream session.
+// Events are dropped for the session with full buffer
+const sessionEventsSize = 1024
+
+// ErrServerClosed is returned by Serve after Close call
+var ErrServerClosed = errors.New("AMI proxy closed")
+
+// User is the downstream user account
+type User struct {
+	Login    string
+	Password string
+	// Actions is a whitelist of the allowed actions, "*" allows all actions
+	Actions []string
+	// Events is a list of the allowed event name patterns (ami.CompilePattern syntax),
+	// empty list allows all events
+	Events []string
+	// Filter is a map of the event header patterns like "PJSIP/*", event is delivered if all headers are matched
+	Filter map[string]string
+}
+
+// ActionAllowed returns true if user has permission of the action
+func (s *User) ActionAllowed(action string) bool {
+	for _, v := range s.Actions {
+		if v == "*" || strings.EqualFold(v, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventAllowed returns true if event is matched with the user event filters
+func (s *User) EventAllowed(e ami.Event) bool {
+	if len(s.Events) > 0 {
+		check := false
+		for _, pattern := range s.Events {
+			if check = ami.MatchPattern(pattern, e.Name()); check {
+				break
+			}
+		}
+		if !check {
+			return false
+		}
+	}
+	for key, pattern := range s.Filter {
+		if !ami.MatchPattern(pattern, e.ActionData[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Config of the proxy
+type Config struct {
+	Users []User
+	// Timeout of the upstream request, ami.RequestTimeoutDefault by default
+	Timeout time.Duration
+}
+
+// New creates proxy of the upstream client. Proxy reads the client events channel,
+// so client events must not be readed by other consumers
+func New(client *ami.Client, conf Config) *Proxy {
+	if conf.Timeout == 0 {
+		conf.Timeout = ami.RequestTimeoutDefault
+	}
+	s := &Proxy{
+		client:    client,
+		timeout:   conf.Timeout,
+		users:     make(map[string]*User),
+		sessions:  make(map[*session]struct{}),
+		listeners: make(map[net.Listener]struct{}),
+		routes:    make(map[string]route),
+		locker:    new(sync.RWMutex),
+		closed:    make(chan struct{}),
+	}
+	for i := range conf.Users {
+		s.users[conf.Users[i].Login] = &conf.Users[i]
+	}
+	go s.broadcast(client.Event())
+	return s
+}
+
+// Proxy is the AMI multiplexing server
+type Proxy struct {
+	client       *ami.Client
+	timeout      time.Duration
+	users        map[string]*User
+	sessions     map[*session]struct{}
+	listeners    map[net.Listener]struct{}
+	routes       map[string]route // forwarded actions by the upstream ActionID
+	routesLocker sync.Mutex
+	locker       *sync.RWMutex
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// route is the session of the forwarded action with the downstream ActionID
+type route struct {
+	sess     *session
+	actionID string
+}
+
+func (s *Proxy) addRoute(upstreamID string, sess *session, actionID string) {
+	s.routesLocker.Lock()
+	s.routes[upstreamID] = route{sess, actionID}
+	s.routesLocker.Unlock()
+}
+
+func (s *Proxy) removeRoute(upstreamID string) {
+	s.routesLocker.Lock()
+	delete(s.routes, upstreamID)
+	s.routesLocker.Unlock()
+}
+
+// removeSessionRoutes removes routes of the closed session
+func (s *Proxy) removeSessionRoutes(sess *session) {
+	s.routesLocker.Lock()
+	for upstreamID, r := range s.routes {
+		if r.sess == sess {
+			delete(s.routes, upstreamID)
+		}
+	}
+	s.routesLocker.Unlock()
+}
+
+// routeEvent returns the session of the action of the event. Route is removed after the
+// final event of the action
+func (s *Proxy) routeEvent(e ami.Event) (r route, check bool) {
+	upstreamID := e.ActionID()
+	if upstreamID == "" {
+		return
+	}
+	s.routesLocker.Lock()
+	if r, check = s.routes[upstreamID]; check && (e.IsListComplete() || strings.EqualFold(e.Name(), "OriginateResponse")) {
+		delete(s.routes, upstreamID)
+	}
+	s.routesLocker.Unlock()
+	return
+}
+
+// ListenAndServe listens TCP address and serves downstream connections
+func (s *Proxy) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts downstream connections of the listener until Close call
+func (s *Proxy) Serve(ln net.Listener) error {
+	s.locker.Lock()
+	select {
+	case <-s.closed:
+		s.locker.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	default:
+	}
+	s.listeners[ln] = struct{}{}
+	s.locker.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.closed:
+				return ErrServerClosed
+			default:
+			}
+			s.locker.Lock()
+			delete(s.listeners, ln)
+			s.locker.Unlock()
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops listeners and closes downstream connections. The upstream client is not closed
+func (s *Proxy) Close() {
+	s.closeOnce.Do(func() {
+		s.locker.Lock()
+		close(s.closed)
+		for ln := range s.listeners {
+			ln.Close()
+		}
+		for sess := range s.sessions {
+			sess.conn.Close()
+		}
+		s.locker.Unlock()
+	})
+}
+
+// SessionsCount returns count of the downstream connections
+func (s *Proxy) SessionsCount() (res int) {
+	s.locker.RLock()
+	res = len(s.sessions)
+	s.locker.RUnlock()
+	return
+}
+
+// broadcast sends upstream events to the authorized downstream sessions
+func (s *Proxy) broadcast(events chan ami.Event) {
+	for {
+		select {
+		case e, check := <-events:
+			if !check {
+				return
+			}
+			if r, check := s.routeEvent(e); check {
+				r.sess.pushActionEvent(e, r.actionID)
+				continue
+			}
+			s.locker.RLock()
+			for sess := range s.sessions {
+				sess.pushEvent(e)
+			}
+			s.locker.RUnlock()
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Proxy) serveConn(conn net.Conn) {
+	sess := newSession(s, conn)
+	s.locker.Lock()
+	select {
+	case <-s.closed:
+		s.locker.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	s.sessions[sess] = struct{}{}
+	s.locker.Unlock()
+	sess.serve()
+	s.locker.Lock()
+	delete(s.sessions, sess)
+	s.locker.Unlock()
+	s.removeSessionRoutes(sess)
+}
+
+// authorize checks downstream user credentials
+func (s *Proxy) authorize(login, password string) (user *User, err error) {
+	user, check := s.users[login]
+	if !check || user.Password != password {
+		return nil, fmt.Errorf("Authentication failed")
+	}
+	return
+}
+
+// greeting returns banner with upstream AMI version
+func (s *Proxy) greeting() string {
+	version := s.client.AMIVersion()
+	if !version.IsValid() {
+		return "Asterisk Call Manager/5.0.0"
+	}
+	return fmt.Sprintf("Asterisk Call Manager/%v.%v.%v", version.Major, version.Minor, version.Patch)
+}
@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami/internal/protocol"
+)
+
+func newSession(proxy *Proxy, conn net.Conn) *session {
+	return &session{
+		proxy:  proxy,
+		conn:   conn,
+		locker: new(sync.RWMutex),
+		events: make(chan ami.Event, sessionEventsSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// session is the downstream connection
+type session struct {
+	proxy       *Proxy
+	conn        net.Conn
+	writeLocker sync.Mutex
+	locker      *sync.RWMutex
+	user        *User
+	eventsOn    bool
+	events      chan ami.Event
+	done        chan struct{}
+	requests    sync.WaitGroup
+}
+
+// field returns action value, AMI header names are case insensitive
+func field(action ami.ActionData, key string) string {
+	if val, check := action[key]; check {
+		return val
+	}
+	for k, val := range action {
+		if strings.EqualFold(k, key) {
+			return val
+		}
+	}
+	return ""
+}
+
+func (s *session) authorized() (user *User, eventsOn bool) {
+	s.locker.RLock()
+	user, eventsOn = s.user, s.eventsOn
+	s.locker.RUnlock()
+	return
+}
+
+// pushEvent queues event if it is allowed for the session user
+func (s *session) pushEvent(e ami.Event) {
+	if user, eventsOn := s.authorized(); user == nil || !eventsOn || !user.EventAllowed(e) {
+		return
+	}
+	// ActionID of the upstream client is not valid for the session
+	if _, check := e.ActionData["ActionID"]; check {
+		e = withActionID(e, "")
+	}
+	s.queueEvent(e)
+}
+
+// pushActionEvent queues event of the action forwarded by the session, the event filters of the user are
+// not applied. ActionID is replaced by the downstream one
+func (s *session) pushActionEvent(e ami.Event, actionID string) {
+	if _, eventsOn := s.authorized(); eventsOn {
+		s.queueEvent(withActionID(e, actionID))
+	}
+}
+
+func (s *session) queueEvent(e ami.Event) {
+	select {
+	case s.events <- e:
+	default:
+	}
+}
+
+// withActionID returns copy of the event with replaced ActionID, empty ActionID is removed
+func withActionID(e ami.Event, actionID string) ami.Event {
+	data := make(ami.ActionData, len(e.ActionData))
+	for key, val := range e.ActionData {
+		data[key] = val
+	}
+	if actionID != "" {
+		data["ActionID"] = actionID
+	} else {
+		delete(data, "ActionID")
+	}
+	e.ActionData = data
+	return e
+}
+
+func (s *session) write(data []byte) (err error) {
+	s.writeLocker.Lock()
+	_, err = s.conn.Write(data)
+	s.writeLocker.Unlock()
+	return
+}
+
+// answer writes response and events with downstream ActionID
+func (s *session) answer(actionID string, actions ...ami.ActionData) error {
+	var raw []byte
+	for _, action := range actions {
+		if actionID != "" {
+			action["ActionID"] = actionID
+		} else {
+			delete(action, "ActionID")
+		}
+		src, err := protocol.Encode(action)
+		if err != nil {
+			return err
+		}
+		raw = append(raw, src...)
+	}
+	return s.write(raw)
+}
+
+func (s *session) writeEvents() {
+	for {
+		select {
+		case e := <-s.events:
+			src, err := protocol.Encode(e.ActionData)
+			if err != nil {
+				continue
+			}
+			if s.write(src) != nil {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *session) serve() {
+	defer func() {
+		close(s.done)
+		s.conn.Close()
+		s.requests.Wait()
+	}()
+	if s.write([]byte(s.proxy.greeting()+"\r\n")) != nil {
+		return
+	}
+	go s.writeEvents()
+	var data []byte
+	buf := make([]byte, 4096)
+	for {
+		count, err := s.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		open := true
+		data = protocol.Parse(append(data, buf[:count]...), func(action map[string]string) {
+			if open {
+				open = s.handle(action)
+			}
+		})
+		if !open {
+			return
+		}
+	}
+}
+
+// handle serves downstream action. Returns false if connection must be closed
+func (s *session) handle(action ami.ActionData) bool {
+	name, actionID := field(action, "Action"), field(action, "ActionID")
+	user, _ := s.authorized()
+	switch {
+	case strings.EqualFold(name, "Login"):
+		return s.login(action)
+	case strings.EqualFold(name, "Challenge"):
+		// challenge would be issued for the shared upstream connection
+		s.answer(actionID, ami.ActionData{"Response": "Error", "Message": "Only plaintext authentication is supported"})
+		return true
+	case user == nil:
+		s.answer(actionID, ami.ActionData{"Response": "Error", "Message": "Authentication Required"})
+		return true
+	case strings.EqualFold(name, "Logoff"):
+		s.answer(actionID, ami.ActionData{"Response": "Goodbye", "Message": "Thanks for all the fish."})
+		return false
+	case strings.EqualFold(name, "Ping"):
+		s.answer(actionID, ami.ActionData{
+			"Response":  "Success",
+			"Ping":      "Pong",
+			"Timestamp": fmt.Sprintf("%.6f", float64(time.Now().UnixNano())/1e9),
+		})
+		return true
+	case strings.EqualFold(name, "Events"):
+		eventsOn := !strings.EqualFold(field(action, "EventMask"), "off")
+		s.locker.Lock()
+		s.eventsOn = eventsOn
+		s.locker.Unlock()
+		state := "Off"
+		if eventsOn {
+			state = "On"
+		}
+		s.answer(actionID, ami.ActionData{"Response": "Success", "Events": state})
+		return true
+	case strings.EqualFold(name, "Filter"):
+		// filter would change events of all sessions, events of the user are filtered by the config
+		s.answer(actionID, ami.ActionData{"Response": "Error", "Message": "Permission denied"})
+		return true
+	case !user.ActionAllowed(name):
+		s.answer(actionID, ami.ActionData{"Response": "Error", "Message": "Permission denied"})
+		return true
+	}
+	// actions without ActionID are served sequentially, the client can't match concurrent responses
+	if actionID == "" {
+		s.forward(action)
+	} else {
+		s.requests.Add(1)
+		go func() {
+			s.forward(action)
+			s.requests.Done()
+		}()
+	}
+	return true
+}
+
+func (s *session) login(action ami.ActionData) bool {
+	actionID := field(action, "ActionID")
+	if user, _ := s.authorized(); user != nil {
+		s.answer(actionID, ami.ActionData{"Response": "Error", "Message": "Already authenticated"})
+		return true
+	}
+	if field(action, "AuthType") != "" {
+		s.answer(actionID, ami.ActionData{"Response": "Error", "Message": "Only plaintext authentication is supported"})
+		return true
+	}
+	user, err := s.proxy.authorize(field(action, "Username"), field(action, "Secret"))
+	if err != nil {
+		s.answer(actionID, ami.ActionData{"Response": "Error", "Message": err.Error()})
+		return false
+	}
+	s.locker.Lock()
+	s.user, s.eventsOn = user, !strings.EqualFold(field(action, "Events"), "off")
+	s.locker.Unlock()
+	s.answer(actionID, ami.ActionData{"Response": "Success", "Message": "Authentication accepted"})
+	return true
+}
+
+// isTrue checks boolean value of the AMI header
+func isTrue(val string) bool {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "true", "yes", "y", "t", "1", "on":
+		return true
+	}
+	return false
+}
+
+// forward sends action by the upstream client and writes response with event list to the session.
+// Events with ActionID of the action are routed to the session until the response is received,
+// for the async actions until the final event
+func (s *session) forward(action ami.ActionData) {
+	actionID := field(action, "ActionID")
+	upstreamID := s.proxy.client.InitActionID()
+	req := ami.Request{ActionData: make(ami.ActionData, len(action))}
+	for key, val := range action {
+		if !strings.EqualFold(key, "ActionID") && !strings.EqualFold(key, "Action") {
+			req.ActionData[key] = val
+		}
+	}
+	req.ActionData["Action"] = field(action, "Action")
+	req.ActionData["ActionID"] = upstreamID
+	s.proxy.addRoute(upstreamID, s, actionID)
+	resp, events, accepted := s.proxy.client.RequestListRaw(req, s.proxy.timeout)
+	// route of the timed out request is kept for the late events until the session is closed
+	if accepted && (resp.IsError() || !isTrue(field(action, "Async"))) {
+		s.proxy.removeRoute(upstreamID)
+	}
+	if !accepted {
+		s.answer(actionID, ami.ActionData{"Response": "Error", "Message": "Upstream request timeout"})
+		return
+	}
+	actions := make([]ami.ActionData, 0, len(events)+1)
+	actions = append(actions, resp.ActionData)
+	for _, e := range events {
+		actions = append(actions, e.ActionData)
+	}
+	s.answer(actionID, actions...)
+}
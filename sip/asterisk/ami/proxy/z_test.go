@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami/amitest"
+	"github.com/fcg-xvii/go-tools/text/config"
+	_ "github.com/fcg-xvii/go-tools/text/config/ini"
+)
+
+func startProxy(t *testing.T) (*amitest.Server, string, func()) {
+	srv := amitest.NewServer(amitest.DefaultBanner)
+	upstream, err := amitest.StartClient(srv.Addr())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	p := New(upstream, Config{
+		Timeout: time.Second,
+		Users: []User{
+			{Login: "admin", Password: "secret", Actions: []string{"*"}},
+			{Login: "crm", Password: "crm", Actions: []string{"CoreSettings", "Echo"}, Events: []string{"Dial*"}, Filter: map[string]string{"Channel": "PJSIP/1*"}},
+		},
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(ln)
+	return srv, ln.Addr().String(), func() {
+		p.Close()
+		upstream.Close()
+		srv.Close()
+	}
+}
+
+func connect(addr, login, password string) (*ami.Client, error) {
+	auth := make(chan error, 1)
+	cl := ami.New(addr, login, password, nil, func(state ami.State, err error) {
+		if state == ami.StateAuth {
+			auth <- nil
+		} else if state == ami.StateStopped {
+			if err == nil {
+				err = errors.New("stopped")
+			}
+			select {
+			case auth <- err:
+			default:
+			}
+		}
+	})
+	cl.Event()
+	go cl.Start()
+	select {
+	case err := <-auth:
+		if err != nil {
+			cl.Close()
+			return nil, err
+		}
+		return cl, nil
+	case <-time.After(time.Second * 5):
+		cl.Close()
+		return nil, errors.New("auth timeout")
+	}
+}
+
+// echo handler answers with the request value with delay, so responses of the concurrent requests are mixed
+func echo(req ami.ActionData) []ami.ActionData {
+	time.Sleep(time.Millisecond * time.Duration(len(req["Value"])%5))
+	return []ami.ActionData{{"Response": "Success", "Value": req["Value"]}}
+}
+
+func TestProxyRequests(t *testing.T) {
+	srv, addr, stop := startProxy(t)
+	defer stop()
+	srv.Handle("Echo", echo)
+	srv.Handle("CoreShowChannels", amitest.List("CoreShowChannel", "CoreShowChannelsComplete",
+		ami.ActionData{"Channel": "PJSIP/100-01"},
+	))
+
+	if _, err := connect(addr, "crm", "wrong"); err == nil {
+		t.Fatal("expected auth error")
+	}
+
+	var clients []*ami.Client
+	for _, user := range [][2]string{{"admin", "secret"}, {"crm", "crm"}} {
+		cl, err := connect(addr, user[0], user[1])
+		if err != nil {
+			t.Fatal(user[0], err)
+		}
+		defer cl.Close()
+		if v := cl.Version(); v.Major != 16 {
+			t.Error(user[0], "unexpected version", v)
+		}
+		clients = append(clients, cl)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		cl := clients[i%2]
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := ami.InitRequest("Echo")
+			req.SetParam("Value", strings.Repeat("x", i))
+			resp, accepted := cl.Request(req, time.Second*5)
+			if !accepted || resp.ActionData["Value"] != req.ActionData["Value"] {
+				t.Errorf("unexpected response %v %v", i, resp.ActionData)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// permissions
+	if resp, _ := clients[1].Request(ami.InitRequest("Originate"), time.Second); resp.ErrorMessage() != "Permission denied" {
+		t.Error(resp.ActionData)
+	}
+	resp, events, _ := clients[0].RequestList(ami.InitRequest("CoreShowChannels"), time.Second)
+	if resp.IsError() || len(events) != 1 || events[0].Channel() != "PJSIP/100-01" {
+		t.Error(resp.ActionData, events)
+	}
+	// actions of the shared upstream connection are not forwarded for any user
+	filter := ami.InitRequest("Filter")
+	filter.SetParam("Operation", "Add")
+	filter.SetParam("Filter", "!Event: Newexten")
+	for _, req := range []ami.Request{filter, ami.InitRequest("Challenge")} {
+		if resp, accepted := clients[0].Request(req, time.Second); !accepted || !resp.IsError() {
+			t.Error(req.ActionData, resp.ActionData)
+		}
+	}
+	for _, action := range srv.Received() {
+		if name := action["Action"]; name == "Filter" || name == "Challenge" {
+			t.Error("forwarded action", action)
+		}
+	}
+}
+
+func TestProxyEvents(t *testing.T) {
+	srv, addr, stop := startProxy(t)
+	defer stop()
+	admin, err := connect(addr, "admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	crm, err := connect(addr, "crm", "crm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crm.Close()
+
+	sent := []ami.ActionData{
+		{"Event": "Newchannel", "Channel": "PJSIP/100-01"},
+		{"Event": "DialBegin", "Channel": "PJSIP/200-02"},
+		{"Event": "DialBegin", "Channel": "PJSIP/100-01"},
+		{"Event": "Hangup", "Channel": "PJSIP/100-01"},
+	}
+	for _, e := range sent {
+		srv.Send(e)
+	}
+	receive := func(cl *ami.Client, count int) (res []string) {
+		for len(res) < count {
+			select {
+			case e := <-cl.Event():
+				res = append(res, fmt.Sprintf("%v %v", e.Name(), e.Channel()))
+			case <-time.After(time.Second * 2):
+				t.Fatal("events timeout", res)
+			}
+		}
+		return
+	}
+	if res := receive(admin, 4); res[3] != "Hangup PJSIP/100-01" {
+		t.Error(res)
+	}
+	if res := receive(crm, 1); res[0] != "DialBegin PJSIP/100-01" {
+		t.Error(res)
+	}
+	select {
+	case e := <-crm.Event():
+		t.Error("unexpected event", e.ActionData)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestProxyAsyncOriginate(t *testing.T) {
+	srv, addr, stop := startProxy(t)
+	defer stop()
+	srv.Handle("Originate", func(req ami.ActionData) []ami.ActionData {
+		return []ami.ActionData{
+			{"Response": "Success", "Message": "Originate successfully queued"},
+			{"Event": "OriginateResponse", "ActionID": req.ActionID(), "Response": "Success", "Channel": "PJSIP/100-01"},
+		}
+	})
+	var clients []*ami.Client
+	for i := 0; i < 2; i++ {
+		cl, err := connect(addr, "admin", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cl.Close()
+		clients = append(clients, cl)
+	}
+
+	req := ami.InitRequest("Originate")
+	req.SetParam("Channel", "PJSIP/100")
+	req.SetParam("Async", "true")
+	actionID := clients[0].InitActionID()
+	req.SetParam("ActionID", actionID)
+	if resp, accepted := clients[0].Request(req, time.Second); !accepted || resp.IsError() || resp.ActionID() != actionID {
+		t.Fatal(resp.ActionData)
+	}
+	select {
+	case e := <-clients[0].Event():
+		if e.Name() != "OriginateResponse" || e.ActionID() != actionID {
+			t.Error(e.ActionData)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("OriginateResponse timeout")
+	}
+	// async response is not delivered to other sessions
+	srv.Send(ami.ActionData{"Event": "Hangup", "Channel": "PJSIP/100-01"})
+	select {
+	case e := <-clients[1].Event():
+		if e.Name() != "Hangup" {
+			t.Error("unexpected event", e.ActionData)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("events timeout")
+	}
+}
+
+func TestUsersFromConfig(t *testing.T) {
+	src := `
+[user]
+login = crm
+password = secret
+actions = Ping, Originate
+events = Dial*, Hangup
+filter = Channel=PJSIP/* Context=from-crm
+`
+	conf, err := config.FromReader("ini", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := UsersFromConfig(conf, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := users[0]
+	if user.Login != "crm" || user.Password != "secret" || !user.ActionAllowed("originate") || user.ActionAllowed("Command") {
+		t.Fatal(user)
+	}
+	if !user.EventAllowed(ami.Event{ActionData: ami.ActionData{"Event": "DialEnd", "Channel": "PJSIP/1", "Context": "from-crm"}}) ||
+		user.EventAllowed(ami.Event{ActionData: ami.ActionData{"Event": "DialEnd", "Channel": "SIP/1", "Context": "from-crm"}}) ||
+		user.EventAllowed(ami.Event{ActionData: ami.ActionData{"Event": "Newchannel", "Channel": "PJSIP/1", "Context": "from-crm"}}) {
+		t.Fatal(user)
+	}
+	// "*" matches the "/" of the channel name
+	e := ami.Event{ActionData: ami.ActionData{"Event": "Hangup", "Channel": "PJSIP/100-0001"}}
+	for _, pattern := range []string{"PJSIP*", "*", "PJSIP/1??-*"} {
+		if user := (User{Filter: map[string]string{"Channel": pattern}}); !user.EventAllowed(e) {
+			t.Error(pattern)
+		}
+	}
+	if user := (User{Events: []string{"Hang*"}, Filter: map[string]string{"Channel": "SIP*"}}); user.EventAllowed(e) {
+		t.Error("SIP*")
+	}
+}
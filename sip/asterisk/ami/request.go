@@ -2,8 +2,10 @@ package ami
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/fcg-xvii/go-tools/json"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami/internal/protocol"
 )
 
 func InitRequest(action string) Request {
@@ -16,9 +18,7 @@ func InitRequest(action string) Request {
 
 type Request struct {
 	ActionData
-	Variables    json.Map
-	chanResponse chan Response
-	sended       bool
+	Variables json.Map
 }
 
 func (s *Request) SetParam(key, value string) {
@@ -43,13 +43,27 @@ func (s *Request) SetVariables(m json.Map) {
 	}
 }
 
-func (s *Request) raw() []byte {
+// check returns error if the fields or variables contain line breaks
+func (s *Request) check() error {
+	if err := protocol.Check(s.ActionData); err != nil {
+		return err
+	}
+	for key, val := range s.Variables {
+		if strings.ContainsAny(key, "\r\n") || strings.ContainsAny(fmt.Sprint(val), "\r\n") {
+			return fmt.Errorf("AMI variable %q contains line break", key)
+		}
+	}
+	return nil
+}
+
+// raw returns request source, variables are joined by separator of the server dialect
+func (s *Request) raw(separator string) ([]byte, error) {
 	if len(s.Variables) > 0 {
 		vars, count := "", 0
 		for key, val := range s.Variables {
 			vars += fmt.Sprintf("%v=%v", key, val)
 			if count < len(s.Variables)-1 {
-				vars += "," // todo 1.5 or lower splitter is '|'
+				vars += separator
 			}
 			count++
 		}
@@ -1,10 +1,12 @@
 package ami
 
+import "strings"
+
 func initResponseError(err error) Response {
 	return Response{
 		ActionData{
-			"Action":  "Error",
-			"Message": err.Error(),
+			"Response": "Error",
+			"Message":  err.Error(),
 		},
 	}
 }
@@ -20,3 +22,9 @@ func (s Response) IsError() bool {
 func (s Response) ErrorMessage() string {
 	return s.ActionData["Message"]
 }
+
+// IsListStart returns true if response is the start of the event list
+func (s Response) IsListStart() bool {
+	return strings.EqualFold(s.ActionData["EventList"], "start") ||
+		strings.HasSuffix(strings.ToLower(s.ActionData["Message"]), "will follow")
+}
@@ -0,0 +1,122 @@
+package routing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fcg-xvii/go-tools/json"
+	"github.com/fcg-xvii/go-tools/text/config"
+)
+
+// routeSource is the route description of the config or JSON file
+type routeSource struct {
+	Name     string   `json:"name"`
+	Prefix   string   `json:"prefix"`
+	Trunk    string   `json:"trunk"`
+	Tech     string   `json:"tech"`
+	Priority int      `json:"priority"`
+	Cost     float64  `json:"cost"`
+	Strip    int      `json:"strip"`
+	Add      string   `json:"add"`
+	Time     []string `json:"time"`
+}
+
+func (s *routeSource) route() (res Route, err error) {
+	res = Route{
+		Name:     s.Name,
+		Prefix:   s.Prefix,
+		Trunk:    s.Trunk,
+		Tech:     s.Tech,
+		Priority: s.Priority,
+		Cost:     s.Cost,
+		Strip:    s.Strip,
+		Add:      s.Add,
+	}
+	if res.Name == "" {
+		res.Name = res.Prefix + "/" + res.Trunk
+	}
+	for _, src := range s.Time {
+		var w TimeWindow
+		if w, err = ParseTimeWindow(src); err != nil {
+			return res, fmt.Errorf("route %v: %v", res.Name, err)
+		}
+		res.Windows = append(res.Windows, w)
+	}
+	return
+}
+
+func tableFromSources(sources []routeSource) (*Table, error) {
+	routes := make([]Route, len(sources))
+	for i := range sources {
+		route, err := sources[i].route()
+		if err != nil {
+			return nil, err
+		}
+		routes[i] = route
+	}
+	return NewTable(routes)
+}
+
+// FromConfig loads table from the config sections with name sectionName.
+// Time windows of the route are separated by ';'
+func FromConfig(conf config.Config, sectionName string) (*Table, error) {
+	sections, check := conf.Sections(sectionName)
+	if !check {
+		return nil, fmt.Errorf("routing config: sections [%v] are not found", sectionName)
+	}
+	sources := make([]routeSource, len(sections))
+	for i, section := range sections {
+		src := &sources[i]
+		var priority, cost, strip, windows string
+		section.ValueSetup("name", &src.Name)
+		section.ValueSetup("prefix", &src.Prefix)
+		section.ValueSetup("trunk", &src.Trunk)
+		section.ValueSetup("tech", &src.Tech)
+		section.ValueSetup("add", &src.Add)
+		section.ValueSetup("priority", &priority)
+		section.ValueSetup("cost", &cost)
+		section.ValueSetup("strip", &strip)
+		section.ValueSetup("time", &windows)
+		var err error
+		if priority != "" {
+			if src.Priority, err = strconv.Atoi(priority); err != nil {
+				return nil, fmt.Errorf("routing config: route %v: invalid priority %q", i, priority)
+			}
+		}
+		if cost != "" {
+			if src.Cost, err = strconv.ParseFloat(cost, 64); err != nil {
+				return nil, fmt.Errorf("routing config: route %v: invalid cost %q", i, cost)
+			}
+		}
+		if strip != "" {
+			if src.Strip, err = strconv.Atoi(strip); err != nil {
+				return nil, fmt.Errorf("routing config: route %v: invalid strip %q", i, strip)
+			}
+		}
+		for _, w := range strings.Split(windows, ";") {
+			if w = strings.TrimSpace(w); w != "" {
+				src.Time = append(src.Time, w)
+			}
+		}
+	}
+	return tableFromSources(sources)
+}
+
+// FromJSON loads table from JSON array of the routes
+func FromJSON(src []byte) (*Table, error) {
+	var sources []routeSource
+	if err := json.Unmarshal(src, &sources); err != nil {
+		return nil, fmt.Errorf("routing JSON: %v", err)
+	}
+	return tableFromSources(sources)
+}
+
+// FromJSONFile loads table from JSON file
+func FromJSONFile(fileName string) (*Table, error) {
+	var sources []routeSource
+	if err := json.UnmarshalFile(fileName, &sources); err != nil {
+		return nil, fmt.Errorf("routing JSON: %v", err)
+	}
+	return tableFromSources(sources)
+}
@@ -0,0 +1,351 @@
+// Package routing selects outbound trunks by the number prefix, time of day and cost.
+//
+// Route table is loaded from the ini config:
+//
+//	[route]
+//	name = moscow-mts
+//	prefix = 7495
+//	trunk = mts
+//	priority = 1
+//	cost = 0.45
+//	strip = 1
+//	add = 8
+//	time = Mon-Fri 09:00-18:00; Sat 10:00-14:00
+//
+// or from JSON array of the objects with the same keys (time is an array of windows).
+// Table returns candidates ordered by priority, prefix length and cost, the Dial function
+// originates call with failover to the next candidate on congestion or lost originate response.
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+)
+
+var (
+	// ErrResponseTimeout is the error of the attempt without OriginateResponse in the response timeout
+	ErrResponseTimeout = errors.New("originate response timeout")
+	// ErrConnectionLost is the error of the attempt, which response is lost with the AMI connection
+	ErrConnectionLost = errors.New("AMI connection lost")
+)
+
+// responseMargin is added to the originate timeout to wait the OriginateResponse
+var responseMargin = time.Second * 5
+
+// DialError is the error of the Dial, all candidates are failed. Err is the error of the last attempt,
+// it is nil if the last attempt failed by congestion
+type DialError struct {
+	Failures []string
+	Err      error
+}
+
+func (s *DialError) Error() string {
+	return fmt.Sprintf("routing: all routes failed: %v", strings.Join(s.Failures, "; "))
+}
+
+func (s *DialError) Unwrap() error { return s.Err }
+
+// Route is the outbound route of the numbers with prefix
+type Route struct {
+	Name string
+	// Prefix of the number, empty prefix matches all numbers
+	Prefix string
+	// Trunk is the endpoint or peer of the route, DAHDI group or dialplan context of the Local route
+	Trunk string
+	// Tech of the trunk, PJSIP by default
+	Tech string
+	// Priority of the route, lower value is used first
+	Priority int
+	// Cost of the minute, cheaper route is used first among routes with the same priority and prefix length
+	Cost float64
+	// Strip is the count of the removed leading digits
+	Strip int
+	// Add is the prefix added to the number after strip
+	Add string
+	// Windows of the route activity, empty list means always active
+	Windows []TimeWindow
+}
+
+// Rewrite returns number transformed by strip and add rules
+func (s *Route) Rewrite(number string) string {
+	if s.Strip >= len(number) {
+		return s.Add
+	}
+	return s.Add + number[s.Strip:]
+}
+
+// Active returns true if the route is active at the time
+func (s *Route) Active(t time.Time) bool {
+	if len(s.Windows) == 0 {
+		return true
+	}
+	for _, w := range s.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks route values
+func (s *Route) Validate() error {
+	if s.Trunk == "" {
+		return fmt.Errorf("route %v: trunk is not defined", s.Name)
+	}
+	for _, c := range s.Prefix {
+		if !strings.ContainsRune("0123456789+*#", c) {
+			return fmt.Errorf("route %v: invalid prefix %q", s.Name, s.Prefix)
+		}
+	}
+	if s.Strip < 0 {
+		return fmt.Errorf("route %v: negative strip", s.Name)
+	}
+	if err := s.dial("1").Validate(); err != nil {
+		return fmt.Errorf("route %v: %v", s.Name, err)
+	}
+	return nil
+}
+
+func (s *Route) tech() string {
+	if s.Tech == "" {
+		return ami.TechPJSIP
+	}
+	return s.Tech
+}
+
+func (s *Route) dial(number string) ami.DialString {
+	tech := s.tech()
+	if strings.EqualFold(tech, ami.TechLocal) {
+		// trunk of the Local route is the dialplan context
+		return ami.DialString{Tech: tech, Context: s.Trunk, Exten: number}
+	}
+	return ami.DialString{Tech: tech, Resource: s.Trunk, Exten: number}
+}
+
+// Candidate is the route selected for the number
+type Candidate struct {
+	Route *Route
+	// Number after the route rewrite rules
+	Number string
+	Dial   ami.DialString
+}
+
+// NewTable creates route table. Routes are validated
+func NewTable(routes []Route) (*Table, error) {
+	for i := range routes {
+		if err := routes[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Table{routes: routes}, nil
+}
+
+// Table is the outbound routes table
+type Table struct {
+	routes []Route
+}
+
+// Routes returns routes of the table
+func (s *Table) Routes() []Route { return s.routes }
+
+// Candidates returns routes of the number active at the time. Routes are ordered by priority,
+// longest prefix and cost. Only the first route of each trunk is returned
+func (s *Table) Candidates(number string, t time.Time) (res []Candidate) {
+	var matched []*Route
+	for i := range s.routes {
+		r := &s.routes[i]
+		if strings.HasPrefix(number, r.Prefix) && r.Active(t) {
+			matched = append(matched, r)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		l, r := matched[i], matched[j]
+		if l.Priority != r.Priority {
+			return l.Priority < r.Priority
+		}
+		if len(l.Prefix) != len(r.Prefix) {
+			return len(l.Prefix) > len(r.Prefix)
+		}
+		return l.Cost < r.Cost
+	})
+	trunks := make(map[string]bool)
+	for _, r := range matched {
+		key := strings.ToUpper(r.tech()) + "/" + r.Trunk
+		if trunks[key] {
+			continue
+		}
+		trunks[key] = true
+		num := r.Rewrite(number)
+		res = append(res, Candidate{Route: r, Number: num, Dial: r.dial(num)})
+	}
+	return
+}
+
+// Dial originates call by the candidates. If the originate attempt fails with congestion, the channel
+// is not created or the OriginateResponse is not received (timeout or connection loss), the next candidate
+// is used. The req defines call destination (Context and Exten or Application), its Target is replaced
+// by the candidate dial string. Response timeout is req.Timeout increased by 5 seconds or 35 seconds if
+// req.Timeout is not defined, so the missed response means the lost attempt, not the ringing call.
+// Returns Originate of the answered call, for other results (busy, no answer) the error is returned.
+// If all candidates are failed, the *DialError is returned
+func Dial(client *ami.Client, candidates []Candidate, req ami.OriginateRequest) (res *ami.Originate, used Candidate, err error) {
+	if len(candidates) == 0 {
+		return nil, used, fmt.Errorf("routing: no routes found")
+	}
+	responseTimeout := time.Second * 35
+	if req.Timeout > 0 {
+		responseTimeout = req.Timeout + responseMargin
+	}
+	dialErr := new(DialError)
+	fail := func(cand Candidate, reason interface{}, err error) {
+		dialErr.Failures = append(dialErr.Failures, fmt.Sprintf("%v: %v", cand.Dial, reason))
+		dialErr.Err = err
+	}
+	for _, cand := range candidates {
+		r := req
+		target := cand.Dial
+		r.Target, used = &target, cand
+		if res, err = client.Originate(&r); err != nil {
+			fail(cand, err, err)
+			continue
+		}
+		reason, check := res.WaitResponse(responseTimeout)
+		connected := client.State() == ami.StateAuth
+		switch {
+		case !check && !connected:
+			// the events of the attempt are lost with the connection (or the client is closed)
+			res.Close()
+			fail(cand, ErrConnectionLost, ErrConnectionLost)
+		case !check:
+			res.Close()
+			fail(cand, ErrResponseTimeout, ErrResponseTimeout)
+		case reason == ami.ReasonAnswered:
+			return res, used, nil
+		case reason == ami.ReasonCongestion || reason == ami.ReasonFailed:
+			res.Close()
+			fail(cand, reason, nil)
+		default:
+			return nil, used, fmt.Errorf("routing: call is not answered: %v", reason)
+		}
+	}
+	return nil, used, dialErr
+}
+
+////////////////////////////////////////////////////////////////// time windows
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// TimeWindow is the period of the week days. If To is less than From, window
+// continues to the next day (22:00-06:00)
+type TimeWindow struct {
+	// Days is the mask of the week days (1 << time.Weekday), 0 means all days
+	Days uint8
+	// From and To are offsets from the midnight
+	From, To time.Duration
+}
+
+// Contains returns true if time is inside the window
+func (s TimeWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	if s.From <= s.To {
+		return s.dayAllowed(day) && offset >= s.From && offset < s.To
+	}
+	// overnight window, the early part belongs to the previous day
+	if offset >= s.From {
+		return s.dayAllowed(day)
+	}
+	return offset < s.To && s.dayAllowed((day+6)%7)
+}
+
+func (s TimeWindow) dayAllowed(day time.Weekday) bool {
+	return s.Days == 0 || s.Days&(1<<uint(day)) != 0
+}
+
+func parseClock(src string) (res time.Duration, err error) {
+	parts := strings.Split(src, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q", src)
+	}
+	h, errH := strconv.Atoi(parts[0])
+	m, errM := strconv.Atoi(parts[1])
+	if errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("invalid time %q", src)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+func parseDay(src string) (time.Weekday, error) {
+	day, check := weekdays[strings.ToLower(src)]
+	if !check {
+		return 0, fmt.Errorf("invalid week day %q", src)
+	}
+	return day, nil
+}
+
+// ParseTimeWindow parses window like "Mon-Fri 09:00-18:00", "Sat,Sun 10:00-14:00" or "22:00-06:00"
+func ParseTimeWindow(src string) (res TimeWindow, err error) {
+	fields := strings.Fields(src)
+	if len(fields) == 0 || len(fields) > 2 {
+		return res, fmt.Errorf("invalid time window %q", src)
+	}
+	if len(fields) == 2 {
+		for _, item := range strings.Split(fields[0], ",") {
+			bounds := strings.SplitN(item, "-", 2)
+			var from, to time.Weekday
+			if from, err = parseDay(bounds[0]); err != nil {
+				return
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = parseDay(bounds[1]); err != nil {
+					return
+				}
+			}
+			for day := from; ; day = (day + 1) % 7 {
+				res.Days |= 1 << uint(day)
+				if day == to {
+					break
+				}
+			}
+		}
+	}
+	clock := strings.SplitN(fields[len(fields)-1], "-", 2)
+	if len(clock) != 2 {
+		return res, fmt.Errorf("invalid time window %q", src)
+	}
+	if res.From, err = parseClock(clock[0]); err != nil {
+		return
+	}
+	if res.To, err = parseClock(clock[1]); err != nil {
+		return
+	}
+	return
+}
+
+// String returns window source
+func (s TimeWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	res := clock(s.From) + "-" + clock(s.To)
+	if s.Days == 0 {
+		return res
+	}
+	var days []string
+	for _, day := range []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday} {
+		if s.dayAllowed(day) {
+			days = append(days, day.String()[:3])
+		}
+	}
+	return strings.Join(days, ",") + " " + res
+}
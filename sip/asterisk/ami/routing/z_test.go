@@ -0,0 +1,218 @@
+package routing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami/amitest"
+	"github.com/fcg-xvii/go-tools/text/config"
+	_ "github.com/fcg-xvii/go-tools/text/config/ini"
+)
+
+const testConfig = `
+[route]
+name = default
+trunk = backup
+priority = 2
+cost = 1.5
+
+[route]
+name = moscow-mts
+prefix = 7495
+trunk = mts
+priority = 1
+cost = 0.45
+strip = 1
+add = 8
+
+[route]
+name = moscow-beeline
+prefix = 7495
+trunk = beeline
+priority = 1
+cost = 0.3
+time = Mon-Fri 09:00-18:00
+
+[route]
+name = russia
+prefix = 7
+trunk = mts
+priority = 1
+cost = 0.9
+`
+
+func loadTestTable(t *testing.T) *Table {
+	conf, err := config.FromReader("ini", strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := FromConfig(conf, "route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func candidateStrings(list []Candidate) (res []string) {
+	for _, c := range list {
+		res = append(res, c.Dial.String())
+	}
+	return
+}
+
+func TestCandidates(t *testing.T) {
+	table := loadTestTable(t)
+	// wednesday
+	workTime := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 1, 10, 23, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		number   string
+		t        time.Time
+		expected string
+	}{
+		{"74951234567", workTime, "PJSIP/74951234567@beeline PJSIP/84951234567@mts PJSIP/74951234567@backup"},
+		{"74951234567", night, "PJSIP/84951234567@mts PJSIP/74951234567@backup"},
+		{"78121234567", night, "PJSIP/78121234567@mts PJSIP/78121234567@backup"},
+		{"4420123456", night, "PJSIP/4420123456@backup"},
+	} {
+		if res := strings.Join(candidateStrings(table.Candidates(c.number, c.t)), " "); res != c.expected {
+			t.Errorf("%v: expected %v, given %v", c.number, c.expected, res)
+		}
+	}
+
+	src := `[{"prefix": "7", "trunk": "sip-trunk", "tech": "SIP", "time": ["22:00-06:00"]}]`
+	table, err := FromJSON([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := candidateStrings(table.Candidates("7900", time.Date(2024, 1, 10, 3, 0, 0, 0, time.UTC))); len(res) != 1 || res[0] != "SIP/7900@sip-trunk" {
+		t.Error(res)
+	}
+	if res := table.Candidates("7900", workTime); len(res) != 0 {
+		t.Error(res)
+	}
+	if _, err = FromJSON([]byte(`[{"prefix": "7a", "trunk": "mts"}]`)); err == nil {
+		t.Error("expected prefix error")
+	}
+	if _, err = FromJSON([]byte(`[{"trunk": "mts", "time": ["Mon-Fry 09:00-18:00"]}]`)); err == nil {
+		t.Error("expected time window error")
+	}
+}
+
+func TestTimeWindow(t *testing.T) {
+	w, err := ParseTimeWindow("Fri-Mon 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != "Mon,Fri,Sat,Sun 22:00-06:00" {
+		t.Error(w)
+	}
+	for _, c := range []struct {
+		t        time.Time
+		expected bool
+	}{
+		{time.Date(2024, 1, 12, 23, 0, 0, 0, time.UTC), true},  // friday night
+		{time.Date(2024, 1, 13, 3, 0, 0, 0, time.UTC), true},   // saturday morning, friday window
+		{time.Date(2024, 1, 16, 3, 0, 0, 0, time.UTC), true},   // tuesday morning, monday window
+		{time.Date(2024, 1, 17, 3, 0, 0, 0, time.UTC), false},  // wednesday morning
+		{time.Date(2024, 1, 12, 12, 0, 0, 0, time.UTC), false}, // friday day
+	} {
+		if res := w.Contains(c.t); res != c.expected {
+			t.Errorf("%v: expected %v", c.t, c.expected)
+		}
+	}
+	for _, src := range []string{"", "Mon 09:00", "Mon 25:00-26:00", "Xyz 09:00-10:00"} {
+		if _, err := ParseTimeWindow(src); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
+
+func TestDial(t *testing.T) {
+	srv := amitest.NewServer(amitest.DefaultBanner)
+	defer srv.Close()
+	// mts trunk is congested, call by backup trunk is answered. As in Asterisk, the failed channel
+	// is hung up before the OriginateResponse without Uniqueid
+	srv.Handle("Originate", func(req ami.ActionData) []ami.ActionData {
+		res := []ami.ActionData{{"Response": "Success", "Message": "Originate successfully queued"}}
+		if strings.HasSuffix(req["Channel"], "@mts") {
+			return append(res,
+				ami.ActionData{"Event": "Hangup", "Uniqueid": req["ChannelID"], "Channel": req["Channel"], "Cause": "34"},
+				ami.ActionData{"Event": "OriginateResponse", "ActionID": req["ActionID"], "Uniqueid": "<null>", "Channel": req["Channel"], "Reason": "8"},
+			)
+		}
+		return append(res, ami.ActionData{"Event": "OriginateResponse", "ActionID": req["ActionID"], "Uniqueid": req["ChannelID"], "Channel": req["Channel"], "Reason": "4"})
+	})
+	cl, err := amitest.StartClient(srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	table := loadTestTable(t)
+	candidates := table.Candidates("78121234567", time.Now())
+	orig, used, err := Dial(cl, candidates, ami.OriginateRequest{Context: "outbound", Exten: "s", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	if used.Route.Trunk != "backup" {
+		t.Error(used)
+	}
+
+	if _, _, err = Dial(cl, candidates[:1], ami.OriginateRequest{Context: "outbound", Exten: "s", Timeout: time.Second}); err == nil ||
+		!strings.Contains(err.Error(), "Congestion") {
+		t.Error(err)
+	}
+}
+
+func TestDialResponseLost(t *testing.T) {
+	defer func(margin time.Duration) { responseMargin = margin }(responseMargin)
+	responseMargin = time.Millisecond * 100
+
+	srv := amitest.NewServer(amitest.DefaultBanner)
+	defer srv.Close()
+	// OriginateResponse of the mts trunk is not received, call by backup trunk is answered
+	srv.Handle("Originate", func(req ami.ActionData) []ami.ActionData {
+		res := []ami.ActionData{{"Response": "Success", "Message": "Originate successfully queued"}}
+		if !strings.HasSuffix(req["Channel"], "@mts") {
+			res = append(res, ami.ActionData{"Event": "OriginateResponse", "ActionID": req["ActionID"], "Uniqueid": req["ChannelID"], "Channel": req["Channel"], "Reason": "4"})
+		}
+		return res
+	})
+	cl, err := amitest.StartClient(srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	req := ami.OriginateRequest{Context: "outbound", Exten: "s", Timeout: time.Millisecond * 100}
+	candidates := loadTestTable(t).Candidates("78121234567", time.Now())
+	orig, used, err := Dial(cl, candidates, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig.Close()
+	if used.Route.Trunk != "backup" {
+		t.Error(used)
+	}
+	if _, _, err = Dial(cl, candidates[:1], req); !errors.Is(err, ErrResponseTimeout) {
+		t.Error(err)
+	}
+
+	// connection is closed after the originate response
+	srv.Handle("Originate", func(req ami.ActionData) []ami.ActionData {
+		go func() {
+			time.Sleep(time.Millisecond * 20)
+			srv.Close()
+		}()
+		return []ami.ActionData{{"Response": "Success", "Message": "Originate successfully queued"}}
+	})
+	if _, _, err = Dial(cl, candidates[:1], req); !errors.Is(err, ErrConnectionLost) {
+		t.Error(err)
+	}
+}
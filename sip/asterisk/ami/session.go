@@ -0,0 +1,359 @@
+package ami
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// responseGrace is the wait of the response delivered while the request cancel
+const responseGrace = time.Second
+
+// pendingRequest is the request waiting for response
+type pendingRequest struct {
+	actionID     string
+	userActionID string // ActionID defined by the caller, restored in the response
+	request      Request
+	response     chan Response
+	sent         bool
+	list         bool      // request waits the event list
+	listResponse *Response // list start response
+	events       []Event   // received event list items
+	complete     *Event    // list complete event
+	err          error     // encoding error of the refused request
+}
+
+func newPendingRequest(actionID string, req Request) *pendingRequest {
+	data := make(ActionData, len(req.ActionData)+1)
+	for key, val := range req.ActionData {
+		data[key] = val
+	}
+	data["ActionID"] = actionID
+	userActionID := req.ActionData["ActionID"]
+	req.ActionData = data
+	return &pendingRequest{
+		actionID:     actionID,
+		userActionID: userActionID,
+		request:      req,
+		response:     make(chan Response, 1),
+	}
+}
+
+// restoreActionID replaces ActionID of the response or list event by the caller one
+func (s *pendingRequest) restoreActionID(data ActionData) {
+	if s.userActionID != "" && data["ActionID"] == s.actionID {
+		data["ActionID"] = s.userActionID
+	}
+}
+
+func newSession(conn net.Conn) *session {
+	return &session{
+		conn:    conn,
+		signal:  make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		errChan: make(chan error, 1),
+	}
+}
+
+// session is the authorized connection to ami server
+type session struct {
+	conn       net.Conn
+	separator  string
+	queue      []*pendingRequest // write queue, guarded by client pendingLocker
+	signal     chan struct{}
+	stopped    chan struct{}
+	errChan    chan error
+	goroutines sync.WaitGroup
+	tail       []byte       // data received after login, not parsed yet
+	received   []ActionData // actions received before the session start, processed by the receive loop
+}
+
+// fail send connection error to the client main loop
+func (s *session) fail(err error) {
+	select {
+	case s.errChan <- err:
+	default:
+	}
+}
+
+func (s *session) notify() {
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *session) write(data []byte) (err error) {
+	if _, err = s.conn.Write(data); err != nil {
+		err = fmt.Errorf("AMI socket send data error: %v", err.Error())
+	}
+	return
+}
+
+func (s *session) receiveSingle() (data []byte, err error) {
+	count, buf := 0, make([]byte, 1024)
+	if count, err = s.conn.Read(buf); err == nil {
+		data = buf[:count]
+	}
+	return
+}
+
+// sendSingle send request and read socket until response is received.
+// Used before the session start, data received after the response is stored for the receive loop
+func (s *session) sendSingle(request Request, acceptCallback func(ActionData)) (err error) {
+	// send action
+	var src []byte
+	if src, err = request.raw(s.separator); err != nil {
+		return
+	}
+	if err = s.write(src); err != nil {
+		return
+	}
+
+	// receive answer
+	responded := false
+	accept := func(action ActionData) {
+		if responded {
+			// data after response, will be processed in receive loop
+			s.received = append(s.received, action)
+			return
+		}
+		if !action.isEvent() {
+			responded = true
+		}
+		acceptCallback(action)
+	}
+	for {
+		count, buf := 0, make([]byte, 1024)
+		if count, err = s.conn.Read(buf); err != nil {
+			return
+		}
+		if s.tail = actionsFromRaw(append(s.tail, buf[:count]...), accept); responded {
+			return
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////// client side
+
+// enqueue push request to the write queue of the active session.
+// If client is not authorized, request waits the next session.
+// Returns false if the request with the same ActionID is pending
+func (s *client) enqueue(p *pendingRequest) bool {
+	s.pendingLocker.Lock()
+	if _, check := s.pending[p.actionID]; check {
+		s.pendingLocker.Unlock()
+		return false
+	}
+	s.pending[p.actionID] = p
+	if s.session != nil {
+		s.session.queue = append(s.session.queue, p)
+		s.session.notify()
+	} else {
+		s.unsent = append(s.unsent, p)
+	}
+	s.pendingLocker.Unlock()
+	return true
+}
+
+// cancel removes request from pending requests. Returns false if response is already delivered
+func (s *client) cancel(p *pendingRequest) (check bool) {
+	s.pendingLocker.Lock()
+	if check = s.pending[p.actionID] == p; check {
+		delete(s.pending, p.actionID)
+	}
+	s.pendingLocker.Unlock()
+	return
+}
+
+// deliverResponse sends response to the waiting request.
+// If the request waits the event list, the response is stored until the list complete event
+func (s *client) deliverResponse(resp Response) {
+	s.pendingLocker.Lock()
+	p, check := s.pending[resp.ActionID()]
+	if check {
+		if p.list && !resp.IsError() && resp.IsListStart() {
+			p.listResponse = &resp
+			s.pendingLocker.Unlock()
+			return
+		}
+		delete(s.pending, p.actionID)
+	}
+	s.pendingLocker.Unlock()
+	if check {
+		p.response <- resp
+	}
+}
+
+// deliverListEvent appends event to the event list of the waiting request.
+// Returns false if event is not an item of the requested list
+func (s *client) deliverListEvent(event Event) bool {
+	actionID := event.ActionID()
+	if actionID == "" {
+		return false
+	}
+	s.pendingLocker.Lock()
+	p, check := s.pending[actionID]
+	if !check || !p.list || p.listResponse == nil {
+		s.pendingLocker.Unlock()
+		return false
+	}
+	if !event.IsListComplete() {
+		p.events = append(p.events, event)
+		s.pendingLocker.Unlock()
+		return true
+	}
+	p.complete = &event
+	delete(s.pending, actionID)
+	s.pendingLocker.Unlock()
+	p.response <- *p.listResponse
+	return true
+}
+
+// attachSession makes session active and moves requests waiting authorization to the session queue
+func (s *client) attachSession(sess *session) {
+	s.pendingLocker.Lock()
+	s.session = sess
+	for _, p := range s.unsent {
+		if s.pending[p.actionID] == p {
+			sess.queue = append(sess.queue, p)
+		}
+	}
+	s.unsent = nil
+	s.pendingLocker.Unlock()
+	sess.notify()
+}
+
+// detachSession stops the session. Requests sended to the closed connection receive error response,
+// not sended requests wait the next session
+func (s *client) detachSession(sess *session, err error) {
+	var failed []*pendingRequest
+	s.pendingLocker.Lock()
+	s.session = nil
+	s.unsent = append(s.unsent, sess.queue...)
+	sess.queue = nil
+	for id, p := range s.pending {
+		if p.sent {
+			failed = append(failed, p)
+			delete(s.pending, id)
+		}
+	}
+	s.pendingLocker.Unlock()
+	resp := initResponseError(fmt.Errorf("AMI connection lost: %v", err))
+	for _, p := range failed {
+		p.response <- resp
+	}
+}
+
+// failRequests sends error response to all pending requests
+func (s *client) failRequests(err error) {
+	s.pendingLocker.Lock()
+	pending := s.pending
+	s.pending, s.unsent = make(map[string]*pendingRequest), nil
+	if s.session != nil {
+		s.session.queue = nil
+	}
+	s.pendingLocker.Unlock()
+	resp := initResponseError(err)
+	for _, p := range pending {
+		p.response <- resp
+	}
+}
+
+// PendingCount returns count of the requests waiting response
+func (s *client) PendingCount() (res int) {
+	s.pendingLocker.Lock()
+	res = len(s.pending)
+	s.pendingLocker.Unlock()
+	return
+}
+
+// writeLoop writes queued requests to the socket. All requests queued at the moment
+// are sended by single write call
+func (s *client) writeLoop(sess *session) {
+	defer sess.goroutines.Done()
+	var buf []byte
+	for {
+		select {
+		case <-sess.signal:
+		case <-sess.stopped:
+			return
+		}
+		buf = buf[:0]
+		var refused []*pendingRequest
+		s.pendingLocker.Lock()
+		for _, p := range sess.queue {
+			// skip canceled requests
+			if s.pending[p.actionID] == p {
+				src, err := p.request.raw(sess.separator)
+				if err != nil {
+					delete(s.pending, p.actionID)
+					p.err = err
+					refused = append(refused, p)
+					continue
+				}
+				p.sent = true
+				buf = append(buf, src...)
+			}
+		}
+		sess.queue = sess.queue[:0]
+		s.pendingLocker.Unlock()
+		for _, p := range refused {
+			p.response <- initResponseError(p.err)
+		}
+		if len(buf) > 0 {
+			if err := sess.write(buf); err != nil {
+				sess.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// receiveLoop reads socket until error. Responses are delivered to the waiting requests,
+// events are pushed to the events queue.
+// If readTimeout is defined, the silent connection is closed after timeout
+func (s *client) receiveLoop(sess *session, readTimeout time.Duration) {
+	defer sess.goroutines.Done()
+	var (
+		count int
+		err   error
+	)
+	dialect := s.Dialect()
+	accept := func(action ActionData) {
+		if action.isEvent() {
+			dialect.normalizeEvent(action)
+			if s.deliverListEvent(initEvent(action)) {
+				return
+			}
+			select {
+			case s.event <- initEvent(action):
+			case <-sess.stopped:
+			}
+		} else {
+			s.deliverResponse(Response{action})
+		}
+	}
+	// actions received before session start
+	data := sess.tail
+	for _, action := range sess.received {
+		accept(action)
+	}
+	buf := make([]byte, 4096)
+	for {
+		if readTimeout > 0 {
+			sess.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		if count, err = sess.conn.Read(buf); err != nil {
+			if netErr, check := err.(net.Error); check && netErr.Timeout() {
+				err = fmt.Errorf("AMI connection is silent more than %v, connection is dead", readTimeout)
+			} else {
+				err = fmt.Errorf("AMI socket receive data error: %v", err.Error())
+			}
+			sess.fail(err)
+			return
+		}
+		data = actionsFromRaw(append(data, buf[:count]...), accept)
+	}
+}
@@ -0,0 +1,51 @@
+package ami
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is the error of the requests rejected or failed after client close
+var ErrClosed = errors.New("AMI client closed")
+
+// Shutdown gracefully stops the client. New requests are rejected with ErrClosed,
+// in-flight requests are awaited until the ctx is done, then Logoff action is sended and
+// the connection closed. Logoff response is awaited up to LogoffTimeout even if the ctx is done.
+// Not completed requests receive error response, event listeners and Event channel are closed.
+// Method returns after all client goroutines are finished. The returned error is
+// ctx error if in-flight requests was not completed in time
+func (s *client) Shutdown(ctx context.Context) (err error) {
+	s.locker.Lock()
+	if !s.shuttingDown {
+		s.shuttingDown = true
+		close(s.shutdownChan)
+	}
+	s.locker.Unlock()
+
+	// wait in-flight requests, new requests are not accepted after the shuttingDown flag set
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// logoff is sended whatever the drain result, the session must not be left open on the server
+	if s.State() == StateAuth {
+		s.send(InitRequest("Logoff"), LogoffTimeout)
+	}
+
+	// the event loop closes listeners and Event channel
+	s.Close()
+	s.goroutines.Wait()
+	return
+}
+
+// ShutdownDone returns channel closed after shutdown start
+func (s *client) ShutdownDone() <-chan struct{} {
+	return s.shutdownChan
+}
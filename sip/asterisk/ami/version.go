@@ -0,0 +1,115 @@
+package ami
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const greetingPrefix = "Asterisk Call Manager/"
+
+// Version of asterisk server or ami protocol
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	Raw   string
+}
+
+// ParseVersion parse version string like "16.2.1", "certified/13.21-cert3" or "GIT-master-abc"
+func ParseVersion(src string) (res Version) {
+	res.Raw = strings.TrimSpace(src)
+	str := res.Raw
+	if pos := strings.LastIndex(str, "/"); pos >= 0 {
+		str = str[pos+1:]
+	}
+	parts := strings.SplitN(str, ".", 3)
+	vals := []*int{&res.Major, &res.Minor, &res.Patch}
+	for i, part := range parts {
+		// cut suffix like "-cert3" or "-rc1"
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		*vals[i], _ = strconv.Atoi(part[:end])
+		if end < len(part) {
+			break
+		}
+	}
+	return
+}
+
+// IsValid returns true if major version is defined
+func (s Version) IsValid() bool {
+	return s.Major > 0
+}
+
+// Compare returns -1 if version is lower than v, 1 if higher and 0 if versions are equal
+func (s Version) Compare(v Version) int {
+	l, r := []int{s.Major, s.Minor, s.Patch}, []int{v.Major, v.Minor, v.Patch}
+	for i := range l {
+		if l[i] < r[i] {
+			return -1
+		} else if l[i] > r[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// AtLeast returns true if version is equal or higher than major.minor
+func (s Version) AtLeast(major, minor int) bool {
+	return s.Compare(Version{Major: major, Minor: minor}) >= 0
+}
+
+func (s Version) String() string {
+	if !s.IsValid() {
+		return s.Raw
+	}
+	return fmt.Sprintf("%v.%v.%v", s.Major, s.Minor, s.Patch)
+}
+
+// versionFromGreeting parse ami protocol version from greeting banner
+// "Asterisk Call Manager/5.0.1"
+func versionFromGreeting(greeting []byte) (res Version, check bool) {
+	str := strings.TrimSpace(string(greeting))
+	if pos := strings.Index(str, "\r\n"); pos >= 0 {
+		str = str[:pos]
+	}
+	if !strings.HasPrefix(str, greetingPrefix) {
+		return
+	}
+	res = ParseVersion(str[len(greetingPrefix):])
+	check = res.IsValid()
+	return
+}
+
+// asteriskVersionFromAMI returns the lowest asterisk version released with ami protocol version.
+// Used if CoreSettings action is not permitted for the manager user
+func asteriskVersionFromAMI(ami Version) Version {
+	switch {
+	case ami.Major >= 3:
+		// since ami 3.0 (asterisk 14) the major version grows together with asterisk
+		return Version{Major: ami.Major + 11, Raw: ami.Raw}
+	case ami.Major == 2:
+		if ami.Minor == 0 {
+			return Version{Major: 12, Raw: ami.Raw}
+		}
+		return Version{Major: 13, Raw: ami.Raw}
+	case ami.Major == 1:
+		switch ami.Minor {
+		case 0:
+			return Version{Major: 1, Minor: 4, Raw: ami.Raw}
+		case 1:
+			return Version{Major: 1, Minor: 8, Raw: ami.Raw}
+		case 2:
+			return Version{Major: 10, Raw: ami.Raw}
+		default:
+			return Version{Major: 11, Raw: ami.Raw}
+		}
+	}
+	return Version{Raw: ami.Raw}
+}
@@ -0,0 +1,633 @@
+package ami_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami"
+	"github.com/fcg-xvii/go-tools/sip/asterisk/ami/amitest"
+)
+
+// startClient start client and wait auth state.
+// If events is true, client side events channel will be created
+func startClient(t testing.TB, srv *amitest.Server, events bool) (cl *ami.Client, states chan ami.State) {
+	states = make(chan ami.State, 16)
+	cl = ami.New(srv.Addr(), "admin", "secret", nil, func(state ami.State, err error) {
+		states <- state
+	})
+	if events {
+		cl.Event()
+	}
+	go cl.Start()
+	waitState(t, states, ami.StateAuth)
+	return
+}
+
+// waitPending waits the request sended by other goroutine
+func waitPending(t testing.TB, cl *ami.Client) {
+	for deadline := time.Now().Add(time.Second * 5); cl.PendingCount() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("request is not queued")
+		}
+	}
+}
+
+func waitState(t testing.TB, states chan ami.State, state ami.State) {
+	for {
+		select {
+		case s := <-states:
+			if s == state {
+				return
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("state %v timeout", state)
+		}
+	}
+}
+
+func TestVersionDetect(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/1.3")
+	defer srv.Close()
+	// events received before the dialect detection are normalized too
+	srv.Handle("Login", func(req ami.ActionData) []ami.ActionData {
+		return []ami.ActionData{
+			{"Event": "Newexten", "Extension": "100"},
+			{"Response": "Success", "Message": "Authentication accepted"},
+		}
+	})
+	srv.Handle("CoreSettings", func(req ami.ActionData) []ami.ActionData {
+		return []ami.ActionData{
+			{"Event": "Join", "Queue": "support"},
+			{"Response": "Success", "AMIversion": "1.3", "AsteriskVersion": "11.25.3"},
+		}
+	})
+
+	cl, _ := startClient(t, srv, true)
+	defer cl.Close()
+
+	if v := cl.AMIVersion(); v.String() != "1.3.0" {
+		t.Errorf("unexpected ami version %v", v)
+	}
+	if v := cl.Version(); v.String() != "11.25.3" {
+		t.Errorf("unexpected asterisk version %v", v)
+	}
+	if cl.Dialect() != ami.DialectAsterisk11 {
+		t.Errorf("unexpected dialect %v", cl.Dialect().Name)
+	}
+
+	// removed action must be rejected without request to the server
+	resp, accepted := cl.Request(ami.InitRequest("PJSIPShowEndpoints"), time.Second)
+	if !accepted || !resp.IsError() {
+		t.Errorf("expected error response, given %v", resp)
+	}
+
+	for _, expected := range []string{"Newexten 100", "QueueCallerJoin support"} {
+		select {
+		case e := <-cl.Event():
+			if res := fmt.Sprintf("%v %v", e.Name(), e.ActionData["Exten"]+e.ActionData["Queue"]); res != expected {
+				t.Errorf("expected %v, given %v", expected, res)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("event timeout")
+		}
+	}
+
+	// legacy event must be converted to the modern name
+	srv.Send(ami.ActionData{"Event": "Dial", "SubEvent": "Begin", "UniqueID": "1700000000.1", "Destination": "SIP/100-0001"})
+	select {
+	case e := <-cl.Event():
+		if e.Name() != "DialBegin" || e.ActionData["Uniqueid"] != "1700000000.1" || e.ActionData["DestChannel"] != "SIP/100-0001" {
+			t.Errorf("unexpected event %v", e.ActionData)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("event timeout")
+	}
+}
+
+func TestVersionFallback(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/9.0.0")
+	defer srv.Close()
+	srv.Handle("CoreSettings", amitest.Response(ami.ActionData{"Response": "Error", "Message": "Permission denied"}))
+
+	cl, _ := startClient(t, srv, false)
+	defer cl.Close()
+
+	if v := cl.Version(); v.Major != 20 || cl.Dialect() != ami.DialectAsterisk20 {
+		t.Errorf("unexpected version %v, dialect %v", v, cl.Dialect().Name)
+	}
+}
+
+/////////////////////////////////////////////////////////////////// listeners
+
+func receiveEvents(t *testing.T, listener *ami.EventListener, count int) (res []ami.Event) {
+	for {
+		select {
+		case e, ok := <-listener.Events():
+			if !ok {
+				if len(res) != count {
+					t.Errorf("%v %v: expected %v events, given %v", listener.Kind(), listener.Key(), count, len(res))
+				}
+				return
+			}
+			res = append(res, e)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%v %v: listener close timeout", listener.Kind(), listener.Key())
+		}
+	}
+}
+
+func TestEventListeners(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+
+	cl, _ := startClient(t, srv, false)
+	defer cl.Close()
+
+	byUniqueid := cl.ListenUniqueid("1700000000.123", ami.ListenerConfig{CloseOn: []string{"Hangup"}, BufferSize: 8})
+	byLinkedid := cl.ListenLinkedid("1700000000.123", ami.ListenerConfig{IdleTimeout: time.Millisecond * 300, BufferSize: 8})
+	byChannel, err := cl.ListenChannel("PJSIP/trunk-*", ami.ListenerConfig{CloseOn: []string{"Hangup"}, BufferSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := cl.ListenEvents([]string{"hangup"}, ami.ListenerConfig{BufferSize: 8, IdleTimeout: time.Millisecond * 300})
+
+	events := []ami.ActionData{
+		{"Event": "Newchannel", "Channel": "PJSIP/100-00000001", "Uniqueid": "1700000000.123", "Linkedid": "1700000000.123"},
+		{"Event": "Newchannel", "Channel": "PJSIP/trunk-00000002", "Uniqueid": "1700000000.124", "Linkedid": "1700000000.123"},
+		{"Event": "Hangup", "Channel": "PJSIP/trunk-00000002", "Uniqueid": "1700000000.124", "Linkedid": "1700000000.123"},
+		{"Event": "Hangup", "Channel": "PJSIP/100-00000001", "Uniqueid": "1700000000.123", "Linkedid": "1700000000.123"},
+		{"Event": "Newchannel", "Channel": "PJSIP/100-00000003", "Uniqueid": "1700000000.125", "Linkedid": "1700000000.125"},
+	}
+	for _, e := range events {
+		srv.Send(e)
+	}
+
+	receiveEvents(t, byUniqueid, 2)
+	receiveEvents(t, byChannel, 2)
+	receiveEvents(t, byName, 2)
+	// linkedid listener is closed by idle timeout
+	receiveEvents(t, byLinkedid, 4)
+
+	if _, err := cl.ListenChannel("PJSIP/[", ami.ListenerConfig{}); err != nil {
+		t.Errorf("unexpected pattern error %v", err)
+	}
+}
+
+func TestEventListenerClose(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+
+	cl, _ := startClient(t, srv, false)
+
+	// listener without consumer must not block the client after close
+	listener := cl.ListenUniqueid("1", ami.ListenerConfig{})
+	srv.Send(ami.ActionData{"Event": "Newchannel", "Uniqueid": "1"})
+	time.Sleep(time.Millisecond * 100)
+	listener.Close()
+
+	deadline := cl.ListenUniqueid("2", ami.ListenerConfig{Deadline: time.Now().Add(time.Millisecond * 100)})
+	receiveEvents(t, deadline, 0)
+
+	other := cl.ListenLinkedid("3", ami.ListenerConfig{})
+	events := cl.Event()
+	cl.Close()
+	receiveEvents(t, other, 0)
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Error("event channel is not closed after close")
+	}
+}
+
+/////////////////////////////////////////////////////////////////// shutdown
+
+func TestShutdown(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	logoff := make(chan bool, 1)
+	srv.Handle("Slow", func(req ami.ActionData) []ami.ActionData {
+		time.Sleep(time.Millisecond * 100)
+		return []ami.ActionData{{"Response": "Success"}}
+	})
+	srv.Handle("Logoff", func(req ami.ActionData) []ami.ActionData {
+		logoff <- true
+		return []ami.ActionData{{"Response": "Goodbye"}}
+	})
+
+	cl, _ := startClient(t, srv, true)
+	listener := cl.ListenLinkedid("1", ami.ListenerConfig{})
+
+	// in-flight request must be completed before shutdown
+	result := make(chan ami.Response, 1)
+	go func() {
+		resp, _ := cl.Request(ami.InitRequest("Slow"), 0)
+		result <- resp
+	}()
+	waitPending(t, cl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := cl.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if resp := <-result; resp.IsError() {
+		t.Errorf("unexpected in-flight response %v", resp)
+	}
+	select {
+	case <-logoff:
+	default:
+		t.Error("logoff is not sended")
+	}
+	if _, ok := <-cl.Event(); ok {
+		t.Error("event channel is not closed")
+	}
+	if _, ok := <-listener.Events(); ok {
+		t.Error("listener is not closed")
+	}
+	if resp, _ := cl.Request(ami.InitRequest("Ping"), 0); resp.ErrorMessage() != ami.ErrClosed.Error() {
+		t.Errorf("expected closed error, given %v", resp)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	// the server never answers
+	srv.Handle("Hang", func(req ami.ActionData) []ami.ActionData { return nil })
+
+	cl, _ := startClient(t, srv, false)
+
+	result := make(chan ami.Response, 1)
+	go func() {
+		resp, _ := cl.Request(ami.InitRequest("Hang"), 0)
+		result <- resp
+	}()
+	waitPending(t, cl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := cl.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline error, given %v", err)
+	}
+	logoff := false
+	for _, action := range srv.Received() {
+		logoff = logoff || action["Action"] == "Logoff"
+	}
+	if !logoff {
+		t.Error("logoff is not sended")
+	}
+	select {
+	case resp := <-result:
+		if resp.ErrorMessage() != ami.ErrClosed.Error() {
+			t.Errorf("expected closed error, given %v", resp)
+		}
+	case <-time.After(time.Second):
+		t.Error("pending request is not failed")
+	}
+	// channel requested after the stop is closed
+	select {
+	case _, ok := <-cl.Event():
+		if ok {
+			t.Error("unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Error("event channel is not closed")
+	}
+}
+
+/////////////////////////////////////////////////////////////////// keepalive
+
+func waitStopError(t *testing.T, errs chan error) error {
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(time.Second * 5):
+		t.Fatal("stop timeout")
+	}
+	return nil
+}
+
+func startKeepaliveClient(t *testing.T, srv *amitest.Server, conf ami.KeepaliveConfig) (cl *ami.Client, errs chan error) {
+	states, errs := make(chan ami.State, 16), make(chan error, 1)
+	cl = ami.New(srv.Addr(), "admin", "secret", nil, func(state ami.State, err error) {
+		states <- state
+		if state == ami.StateStopped {
+			errs <- err
+		}
+	})
+	cl.SetKeepalive(conf)
+	go cl.Start()
+	waitState(t, states, ami.StateAuth)
+	return
+}
+
+func TestKeepalive(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	srv.Handle("Ping", func(req ami.ActionData) []ami.ActionData {
+		return []ami.ActionData{{"Response": "Success", "Ping": "Pong"}}
+	})
+
+	cl, _ := startKeepaliveClient(t, srv, ami.KeepaliveConfig{Interval: time.Millisecond * 20, ReadTimeout: time.Second})
+	defer cl.Close()
+	time.Sleep(time.Millisecond * 100)
+	if cl.Latency() <= 0 || cl.LastPong().IsZero() {
+		t.Errorf("ping is not measured: %v %v", cl.Latency(), cl.LastPong())
+	}
+	if cl.State() != ami.StateAuth {
+		t.Errorf("unexpected state %v", cl.State())
+	}
+}
+
+func TestKeepaliveMissed(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	srv.Handle("Ping", func(req ami.ActionData) []ami.ActionData { return nil })
+
+	cl, errs := startKeepaliveClient(t, srv, ami.KeepaliveConfig{Interval: time.Millisecond * 20, MaxMissed: 2})
+	defer cl.Close()
+	if err := waitStopError(t, errs); err == nil || !strings.Contains(err.Error(), "ping responses missed") {
+		t.Errorf("unexpected stop error %v", err)
+	}
+}
+
+func TestKeepaliveReadTimeout(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+
+	cl, errs := startKeepaliveClient(t, srv, ami.KeepaliveConfig{ReadTimeout: time.Millisecond * 100})
+	defer cl.Close()
+	if err := waitStopError(t, errs); err == nil || !strings.Contains(err.Error(), "silent") {
+		t.Errorf("unexpected stop error %v", err)
+	}
+}
+
+/////////////////////////////////////////////////////////////////// concurrency
+
+func echoHandler(req ami.ActionData) []ami.ActionData {
+	return []ami.ActionData{{"Response": "Success", "Value": req["Value"]}}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	srv.Handle("Echo", echoHandler)
+
+	cl, _ := startClient(t, srv, true)
+	defer cl.Close()
+
+	// events flow during requests
+	go func() {
+		for range cl.Event() {
+		}
+	}()
+	go func() {
+		for i := 0; i < 500; i++ {
+			srv.Send(ami.ActionData{"Event": "Newexten", "Uniqueid": fmt.Sprint(i)})
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				value := fmt.Sprintf("%v-%v", worker, j)
+				req := ami.InitRequest("Echo")
+				req.SetParam("Value", value)
+				resp, accepted := cl.Request(req, time.Second*5)
+				if !accepted || resp.ActionData["Value"] != value {
+					t.Errorf("unexpected response %v for %v", resp.ActionData, value)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if count := cl.PendingCount(); count != 0 {
+		t.Errorf("expected empty pending requests, given %v", count)
+	}
+}
+
+func TestRequestLineBreak(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	srv.Handle("Echo", echoHandler)
+
+	cl, _ := startClient(t, srv, false)
+	defer cl.Close()
+
+	req := ami.InitRequest("Echo")
+	req.SetParam("Value", "1\r\n\r\nAction: Command\r\nCommand: core stop now")
+	if resp, accepted := cl.Request(req, time.Second); !accepted || !resp.IsError() {
+		t.Errorf("expected error response, given %v", resp.ActionData)
+	}
+	req = ami.InitRequest("Echo")
+	req.SetVariable("A", "1\nCommand: core stop now")
+	if resp, accepted := cl.Request(req, time.Second); !accepted || !resp.IsError() {
+		t.Errorf("expected error response, given %v", resp.ActionData)
+	}
+	// the next request is served
+	if resp, accepted := cl.Request(ami.InitRequest("Echo"), time.Second); !accepted || resp.IsError() {
+		t.Errorf("unexpected response %v", resp.ActionData)
+	}
+	for _, action := range srv.Received() {
+		if action["Action"] == "Command" {
+			t.Error("injected action", action)
+		}
+	}
+}
+
+func TestRequestActionID(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	srv.Handle("Echo", echoHandler)
+	srv.Handle("Hang", func(req ami.ActionData) []ami.ActionData { return nil })
+
+	cl, _ := startClient(t, srv, false)
+	defer cl.Close()
+
+	// the same caller ActionID in concurrent requests
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := ami.InitRequest("Echo")
+			req.SetParam("ActionID", "x")
+			req.SetParam("Value", fmt.Sprint(i))
+			resp, accepted := cl.Request(req, time.Second)
+			if !accepted || resp.ActionData["ActionID"] != "x" || resp.ActionData["Value"] != fmt.Sprint(i) {
+				t.Errorf("unexpected response %v for %v", resp.ActionData, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// ActionID of the client is pending
+	actionID := cl.InitActionID()
+	go func() {
+		req := ami.InitRequest("Hang")
+		req.SetParam("ActionID", actionID)
+		cl.Request(req, time.Second)
+	}()
+	waitPending(t, cl)
+	req := ami.InitRequest("Echo")
+	req.SetParam("ActionID", actionID)
+	if resp, accepted := cl.Request(req, time.Second); !accepted || !resp.IsError() {
+		t.Errorf("expected error response, given %v", resp.ActionData)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	srv.Handle("Hang", func(req ami.ActionData) []ami.ActionData { return nil })
+
+	cl, _ := startClient(t, srv, false)
+	defer cl.Close()
+
+	if _, accepted := cl.Request(ami.InitRequest("Hang"), time.Millisecond*50); accepted {
+		t.Error("unexpected accepted request")
+	}
+	if count := cl.PendingCount(); count != 0 {
+		t.Errorf("timed out request is not removed, pending %v", count)
+	}
+}
+
+func TestRequestBeforeStart(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	srv.Handle("Echo", echoHandler)
+
+	states := make(chan ami.State, 16)
+	cl := ami.New(srv.Addr(), "admin", "secret", nil, func(state ami.State, err error) {
+		states <- state
+	})
+	defer cl.Close()
+
+	// request waits authorization
+	result := make(chan ami.Response, 1)
+	go func() {
+		req := ami.InitRequest("Echo")
+		req.SetParam("Value", "queued")
+		resp, _ := cl.Request(req, 0)
+		result <- resp
+	}()
+	// the client is started after the request is queued
+	waitPending(t, cl)
+	go cl.Start()
+	waitState(t, states, ami.StateAuth)
+
+	select {
+	case resp := <-result:
+		if resp.ActionData["Value"] != "queued" {
+			t.Errorf("unexpected response %v", resp.ActionData)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("queued request timeout")
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	srv.Handle("Hang", func(req ami.ActionData) []ami.ActionData { return nil })
+
+	cl, states := startClient(t, srv, false)
+	defer cl.Close()
+
+	result := make(chan ami.Response, 1)
+	go func() {
+		resp, _ := cl.Request(ami.InitRequest("Hang"), 0)
+		result <- resp
+	}()
+	waitPending(t, cl)
+	srv.Close()
+	waitState(t, states, ami.StateStopped)
+
+	select {
+	case resp := <-result:
+		if !resp.IsError() || !strings.Contains(resp.ErrorMessage(), "connection lost") {
+			t.Errorf("unexpected response %v", resp.ActionData)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("sended request is not failed")
+	}
+}
+
+func benchmarkClient(b *testing.B) (*amitest.Server, *ami.Client) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	srv.Handle("Echo", echoHandler)
+	cl, _ := startClient(b, srv, false)
+	return srv, cl
+}
+
+func BenchmarkRequest(b *testing.B) {
+	srv, cl := benchmarkClient(b)
+	defer srv.Close()
+	defer cl.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, accepted := cl.Request(ami.InitRequest("Echo"), time.Second*5); !accepted {
+			b.Fatal("request timeout")
+		}
+	}
+}
+
+func BenchmarkRequestParallel(b *testing.B) {
+	srv, cl := benchmarkClient(b)
+	defer srv.Close()
+	defer cl.Close()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, accepted := cl.Request(ami.InitRequest("Echo"), time.Second*5); !accepted {
+				b.Fatal("request timeout")
+			}
+		}
+	})
+}
+
+/////////////////////////////////////////////////////////////////// event list
+
+func TestRequestList(t *testing.T) {
+	srv := amitest.NewServer("Asterisk Call Manager/5.0.1")
+	defer srv.Close()
+	srv.Handle("CoreShowChannels", func(req ami.ActionData) []ami.ActionData {
+		id := req.ActionID()
+		return []ami.ActionData{
+			{"Response": "Success", "EventList": "start", "Message": "Channels will follow"},
+			{"Event": "CoreShowChannel", "ActionID": id, "Channel": "PJSIP/100-00000001"},
+			{"Event": "CoreShowChannel", "ActionID": id, "Channel": "PJSIP/101-00000002"},
+			{"Event": "CoreShowChannelsComplete", "ActionID": id, "EventList": "Complete", "ListItems": "2"},
+		}
+	})
+	srv.Handle("Echo", echoHandler)
+
+	cl, _ := startClient(t, srv, false)
+	defer cl.Close()
+
+	resp, events, accepted := cl.RequestList(ami.InitRequest("CoreShowChannels"), time.Second*5)
+	if !accepted || resp.IsError() || len(events) != 2 || events[1].Channel() != "PJSIP/101-00000002" {
+		t.Errorf("unexpected list response %v %v", resp.ActionData, events)
+	}
+
+	resp, events, accepted = cl.RequestListRaw(ami.InitRequest("CoreShowChannels"), time.Second*5)
+	if !accepted || len(events) != 3 || !events[2].IsListComplete() {
+		t.Errorf("unexpected raw list response %v %v", resp.ActionData, events)
+	}
+
+	// not a list action
+	resp, events, accepted = cl.RequestList(ami.InitRequest("Echo"), time.Second*5)
+	if !accepted || resp.IsError() || len(events) != 0 {
+		t.Errorf("unexpected response %v %v", resp.ActionData, events)
+	}
+}
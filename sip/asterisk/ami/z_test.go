@@ -1,6 +1,7 @@
 package ami
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"testing"
@@ -72,3 +73,177 @@ func TestClient(t *testing.T) {
 		cl.Close()
 	}
 }
+
+/////////////////////////////////////////////////////////////////// version
+
+func TestParseVersion(t *testing.T) {
+	items := map[string]string{
+		"16.2.1":                "16.2.1",
+		"certified/13.21-cert3": "13.21.0",
+		"1.8.32.3":              "1.8.32",
+		"20.5.0-rc1":            "20.5.0",
+	}
+	for src, expected := range items {
+		if v := ParseVersion(src); v.String() != expected {
+			t.Errorf("%v: expected %v, given %v", src, expected, v)
+		}
+	}
+	if v := ParseVersion("GIT-master-abc"); v.IsValid() {
+		t.Errorf("unexpected valid version %v", v)
+	}
+	amiVersions := map[string]int{"1.3": 11, "2.10.4": 13, "5.0.1": 16, "9.0.0": 20}
+	for src, major := range amiVersions {
+		if v := asteriskVersionFromAMI(ParseVersion(src)); v.Major != major {
+			t.Errorf("ami %v: expected asterisk %v, given %v", src, major, v.Major)
+		}
+	}
+}
+
+func TestNormalizeEvent(t *testing.T) {
+	items := []struct {
+		src, expected ActionData
+	}{
+		{
+			ActionData{"Event": "Dial", "SubEvent": "Begin", "Source": "SIP/100-01", "SrcUniqueID": "1.1", "Destination": "SIP/200-02"},
+			ActionData{"Event": "DialBegin", "SubEvent": "Begin", "Channel": "SIP/100-01", "Uniqueid": "1.1", "DestChannel": "SIP/200-02"},
+		},
+		// modern field is not overwritten
+		{
+			ActionData{"Event": "Dial", "SubEvent": "Begin", "Channel": "SIP/100-01", "Source": "SIP/101-01"},
+			ActionData{"Event": "DialBegin", "SubEvent": "Begin", "Channel": "SIP/100-01", "Source": "SIP/101-01"},
+		},
+		// fields of other events are not renamed
+		{
+			ActionData{"Event": "Cdr", "Source": "100", "Destination": "200"},
+			ActionData{"Event": "Cdr", "Source": "100", "Destination": "200"},
+		},
+		{
+			ActionData{"Event": "Newexten", "Extension": "100"},
+			ActionData{"Event": "Newexten", "Exten": "100"},
+		},
+	}
+	for _, item := range items {
+		src := fmt.Sprint(item.src)
+		if DialectAsterisk11.normalizeEvent(item.src); fmt.Sprint(item.src) != fmt.Sprint(item.expected) {
+			t.Errorf("%v: expected %v, given %v", src, item.expected, item.src)
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////// dial string
+
+func TestDialString(t *testing.T) {
+	for src, expected := range map[string]string{
+		"PJSIP/100":                         "PJSIP/100",
+		"pjsip/89001234567@trunk":           "PJSIP/89001234567@trunk",
+		"PJSIP/trunk/sip:100@10.0.0.1:5060": "PJSIP/trunk/sip:100@10.0.0.1:5060",
+		"SIP/user1/89774708408":             "SIP/89774708408@user1",
+		"SIP/100@10.0.0.1:5060":             "SIP/100@10.0.0.1:5060",
+		"IAX2/user:secret@pbx:4569/100@ctx": "IAX2/user:secret@pbx:4569/100@ctx",
+		"IAX2/pbx":                          "IAX2/pbx",
+		"DAHDI/g1/89001234567":              "DAHDI/g1/89001234567",
+		"DAHDI/1c":                          "DAHDI/1c",
+		"Local/100@from-internal/n":         "Local/100@from-internal/n",
+		"local/s@bot":                       "Local/s@bot",
+	} {
+		ds, err := ParseDialString(src)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", src, err)
+			continue
+		}
+		if res := ds.String(); res != expected {
+			t.Errorf("%v: expected %v, given %v", src, expected, res)
+		}
+	}
+	for _, src := range []string{
+		"100",
+		"SIPP/100",
+		"PJSIP/",
+		"PJSIP/100@",
+		"PJSIP/trunk/100",
+		"Local/100",
+		"Local/100@from-internal/x",
+		"DAHDI/x1",
+		"SIP/1 00@trunk",
+	} {
+		if _, err := ParseDialString(src); err == nil {
+			t.Errorf("%v: expected error", src)
+		}
+	}
+	if ds := PJSIP("trunk", "89001234567"); ds.String() != "PJSIP/89001234567@trunk" {
+		t.Error(ds)
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	src := "tTL(60000:30000)U(sub^arg)b(ctx^s^1(a,b))"
+	opts, err := ParseDialOptions(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != 5 || opts.String() != src || !opts.Has('T') {
+		t.Fatal(opts)
+	}
+	if opt, _ := opts.Get('b'); opt.Arg != "ctx^s^1(a,b)" {
+		t.Fatal(opt)
+	}
+	for _, src := range []string{"Y", "L", "t(1)", "L(600"} {
+		if _, err := ParseDialOptions(src); err == nil {
+			t.Errorf("%v: expected error", src)
+		}
+	}
+
+	targets := []DialString{PJSIP("100", ""), PJSIP("101", "")}
+	if data, err := DialData(DialectAsterisk16, targets, 30, opts[:2]); err != nil || data != "PJSIP/100&PJSIP/101,30,tT" {
+		t.Error(data, err)
+	}
+	if data, _ := DialData(DialectAsterisk14, targets[:1], 0, opts[:1]); data != "PJSIP/100||t" {
+		t.Error(data)
+	}
+
+	req := &OriginateRequest{Target: &DialString{Tech: TechLocal, Exten: "100"}, Application: "Playback"}
+	if err := req.Validate(); err == nil {
+		t.Error("expected Local channel error")
+	}
+	req.Target.Context = "from-internal"
+	if err := req.Validate(); err != nil || req.Request().ActionData["Channel"] != "Local/100@from-internal" {
+		t.Error(err, req.Request().ActionData)
+	}
+	// free-form channels of the drivers unknown for the parser are passed as is
+	for _, channel := range []string{"Dongle/dongle0/79001234567", "Motif/google/user@gmail.com", "SCCP/100", "Custom/abc"} {
+		req = &OriginateRequest{Channel: channel, Application: "Playback"}
+		if err := req.Validate(); err != nil || req.Request().ActionData["Channel"] != channel {
+			t.Error(channel, err)
+		}
+	}
+	if err := (&OriginateRequest{Application: "Playback"}).Validate(); err == nil {
+		t.Error("expected empty channel error")
+	}
+}
+
+/////////////////////////////////////////////////////////////////// listeners
+
+func TestListenerRegistry(t *testing.T) {
+	registry := newListenerRegistry()
+	listeners := []*EventListener{
+		newEventListener(ListenUniqueid, "1", ListenerConfig{}),
+		newEventListener(ListenLinkedid, "1", ListenerConfig{}),
+		newEventListener(ListenEvent, "hangup", ListenerConfig{}),
+		newEventListener(ListenActionID, "a-1", ListenerConfig{}),
+	}
+	listeners[2].names = map[string]bool{"hangup": true}
+	for _, listener := range listeners {
+		registry.add(listener)
+	}
+	e := Event{ActionData: ActionData{"Event": "Hangup", "Uniqueid": "1", "Linkedid": "1", "ActionID": "a-1"}}
+	if res := registry.match(e); len(res) != 4 {
+		t.Fatal(res)
+	}
+	// closed listeners are removed from the registry
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	if count := registry.len(); count != 0 {
+		t.Errorf("expected empty listeners registry, given %v", count)
+	}
+}
@@ -0,0 +1,297 @@
+// Package pcap provides minimal reader and writer of the libpcap capture files
+// and decoder of the UDP datagrams from the captured frames. The pcapng format is not supported
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Link types of the capture
+const (
+	LinkTypeNull     = 0
+	LinkTypeEthernet = 1
+	LinkTypeRaw      = 101
+	LinkTypeLinuxSLL = 113
+	LinkTypeIPv4     = 228
+	LinkTypeIPv6     = 229
+)
+
+const (
+	magicMicroseconds = 0xA1B2C3D4
+	magicNanoseconds  = 0xA1B23C4D
+	magicPcapng       = 0x0A0D0D0A
+	fileHeaderSize    = 24
+	recordHeaderSize  = 16
+	maxSnapLen        = 262144
+)
+
+// ErrPcapng is returned for the files of the pcapng format
+var ErrPcapng = errors.New("pcap: pcapng format is not supported")
+
+// Packet is the captured frame
+type Packet struct {
+	Timestamp time.Time
+	Data      []byte
+	// Length is the original length of the frame, Data can be truncated by the capture snap length
+	Length int
+}
+
+// NewReader reads file header and returns reader of the capture
+func NewReader(r io.Reader) (*Reader, error) {
+	header := make([]byte, fileHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("pcap: file header read error: %v", err)
+	}
+	s := &Reader{r: r, record: make([]byte, recordHeaderSize)}
+	switch magic := binary.LittleEndian.Uint32(header); magic {
+	case magicMicroseconds:
+		s.order = binary.LittleEndian
+	case magicNanoseconds:
+		s.order, s.nanoseconds = binary.LittleEndian, true
+	case magicPcapng:
+		return nil, ErrPcapng
+	default:
+		switch binary.BigEndian.Uint32(header) {
+		case magicMicroseconds:
+			s.order = binary.BigEndian
+		case magicNanoseconds:
+			s.order, s.nanoseconds = binary.BigEndian, true
+		default:
+			return nil, fmt.Errorf("pcap: unknown file magic %x", magic)
+		}
+	}
+	s.snapLen = s.order.Uint32(header[16:])
+	s.linkType = s.order.Uint32(header[20:]) & 0x0FFFFFFF
+	return s, nil
+}
+
+// Reader reads packets of the capture
+type Reader struct {
+	r           io.Reader
+	order       binary.ByteOrder
+	nanoseconds bool
+	snapLen     uint32
+	linkType    uint32
+	record      []byte
+}
+
+// LinkType returns link layer type of the capture
+func (s *Reader) LinkType() uint32 { return s.linkType }
+
+// Next returns next packet, io.EOF is returned at the end of the capture
+func (s *Reader) Next() (p Packet, err error) {
+	if _, err = io.ReadFull(s.r, s.record); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("pcap: truncated record header")
+		}
+		return
+	}
+	sec, frac := s.order.Uint32(s.record), s.order.Uint32(s.record[4:])
+	capLen, origLen := s.order.Uint32(s.record[8:]), s.order.Uint32(s.record[12:])
+	if capLen > maxSnapLen {
+		return p, fmt.Errorf("pcap: invalid record length %v", capLen)
+	}
+	if !s.nanoseconds {
+		frac *= 1000
+	}
+	p.Timestamp = time.Unix(int64(sec), int64(frac))
+	p.Length = int(origLen)
+	p.Data = make([]byte, capLen)
+	if _, err = io.ReadFull(s.r, p.Data); err != nil {
+		return p, fmt.Errorf("pcap: truncated record data: %v", err)
+	}
+	return
+}
+
+// NextUDP returns next UDP datagram of the capture, other packets are skipped
+func (s *Reader) NextUDP() (ts time.Time, u UDP, err error) {
+	for {
+		var p Packet
+		if p, err = s.Next(); err != nil {
+			return
+		}
+		var check bool
+		if u, check = DecodeUDP(s.linkType, p.Data); check {
+			return p.Timestamp, u, nil
+		}
+	}
+}
+
+// NewWriter writes file header with microseconds resolution and returns writer of the capture
+func NewWriter(w io.Writer, linkType uint32) (*Writer, error) {
+	header := make([]byte, fileHeaderSize)
+	binary.LittleEndian.PutUint32(header, magicMicroseconds)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], maxSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], linkType)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// Writer writes packets to the capture
+type Writer struct {
+	w io.Writer
+}
+
+// WritePacket writes frame captured at the time
+func (s *Writer) WritePacket(ts time.Time, data []byte) error {
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(data)))
+	_, err := s.w.Write(append(record, data...))
+	return err
+}
+
+////////////////////////////////////////////////////////////////// decoding
+
+// UDP is the decoded UDP datagram
+type UDP struct {
+	SrcIP, DstIP     net.IP
+	SrcPort, DstPort uint16
+	Payload          []byte
+}
+
+// DecodeUDP decodes UDP datagram of the frame. Fragmented IP packets are not supported
+func DecodeUDP(linkType uint32, data []byte) (res UDP, check bool) {
+	var etherType uint16
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:]), data[14:]
+		// 802.1Q VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88A8) && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:]), data[16:]
+	case LinkTypeNull:
+		if len(data) < 4 {
+			return
+		}
+		// address family in the host byte order: 2 is IPv4, 24, 28 or 30 is IPv6
+		family := binary.LittleEndian.Uint32(data)
+		if family > 0xFFFF {
+			family = binary.BigEndian.Uint32(data)
+		}
+		etherType, data = 0x86DD, data[4:]
+		if family == 2 {
+			etherType = 0x0800
+		}
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		if len(data) == 0 {
+			return
+		}
+		etherType = 0x0800
+		if data[0]>>4 == 6 {
+			etherType = 0x86DD
+		}
+	default:
+		return
+	}
+	switch etherType {
+	case 0x0800:
+		return decodeIPv4(data)
+	case 0x86DD:
+		return decodeIPv6(data)
+	}
+	return
+}
+
+func decodeIPv4(data []byte) (res UDP, check bool) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return
+	}
+	headerLen := int(data[0]&0x0F) * 4
+	totalLen := int(binary.BigEndian.Uint16(data[2:]))
+	flags := binary.BigEndian.Uint16(data[6:])
+	// more fragments flag or fragment offset
+	if flags&0x2000 != 0 || flags&0x1FFF != 0 || data[9] != 17 {
+		return
+	}
+	if headerLen < 20 || totalLen < headerLen || len(data) < headerLen {
+		return
+	}
+	if totalLen < len(data) {
+		// ethernet padding
+		data = data[:totalLen]
+	}
+	res.SrcIP, res.DstIP = net.IP(data[12:16]), net.IP(data[16:20])
+	return decodeUDP(res, data[headerLen:])
+}
+
+func decodeIPv6(data []byte) (res UDP, check bool) {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return
+	}
+	res.SrcIP, res.DstIP = net.IP(data[8:24]), net.IP(data[24:40])
+	next, payload := data[6], data[40:]
+	// skip hop-by-hop, routing and destination options headers
+	for next == 0 || next == 43 || next == 60 {
+		if len(payload) < 8 {
+			return
+		}
+		size := (int(payload[1]) + 1) * 8
+		if len(payload) < size {
+			return
+		}
+		next, payload = payload[0], payload[size:]
+	}
+	if next != 17 {
+		return
+	}
+	return decodeUDP(res, payload)
+}
+
+func decodeUDP(res UDP, data []byte) (UDP, bool) {
+	if len(data) < 8 {
+		return res, false
+	}
+	res.SrcPort, res.DstPort = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	if length < 8 || length > len(data) {
+		// length is not valid or truncated by snap length, the captured data is used
+		length = len(data)
+	}
+	res.Payload = data[8:length]
+	return res, true
+}
+
+// EthernetUDP returns ethernet frame of the IPv4 UDP datagram. Used to write captures
+func EthernetUDP(src, dst *net.UDPAddr, payload []byte) []byte {
+	frame := make([]byte, 14+20+8+len(payload))
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	ip := frame[14:]
+	ip[0], ip[8], ip[9] = 0x45, 64, 17
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+8+len(payload)))
+	copy(ip[12:16], src.IP.To4())
+	copy(ip[16:20], dst.IP.To4())
+	var sum uint32
+	for i := 0; i < 20; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(ip[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+	binary.BigEndian.PutUint16(ip[10:], ^uint16(sum))
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp, uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	copy(udp[8:], payload)
+	return frame
+}
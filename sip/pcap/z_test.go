@@ -0,0 +1,80 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 5060}
+	dst := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5062}
+	ts := time.Unix(1600000000, 123456000)
+	w.WritePacket(ts, EthernetUDP(src, dst, []byte("hello")))
+	// not UDP frame
+	w.WritePacket(ts, make([]byte, 60))
+	w.WritePacket(ts.Add(time.Second), EthernetUDP(dst, src, []byte("world")))
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LinkType() != LinkTypeEthernet {
+		t.Fatal(r.LinkType())
+	}
+	pts, udp, err := r.NextUDP()
+	if err != nil || !pts.Equal(ts) || string(udp.Payload) != "hello" || !udp.SrcIP.Equal(src.IP) || udp.DstPort != 5062 {
+		t.Fatal(err, pts, udp)
+	}
+	if _, udp, err = r.NextUDP(); err != nil || string(udp.Payload) != "world" || udp.SrcPort != 5062 {
+		t.Fatal(err, udp)
+	}
+	if _, _, err = r.NextUDP(); err != io.EOF {
+		t.Fatal(err)
+	}
+
+	// pcapng is rejected
+	ng := make([]byte, 24)
+	binary.LittleEndian.PutUint32(ng, magicPcapng)
+	if _, err = NewReader(bytes.NewReader(ng)); err != ErrPcapng {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeUDP(t *testing.T) {
+	frame := EthernetUDP(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2}, []byte("data"))
+	// VLAN tagged frame
+	vlan := append(append(append([]byte(nil), frame[:12]...), 0x81, 0x00, 0x00, 0x64), frame[12:]...)
+	if udp, check := DecodeUDP(LinkTypeEthernet, vlan); !check || string(udp.Payload) != "data" {
+		t.Fatal(udp, check)
+	}
+	// raw IPv4 with ethernet padding
+	if udp, check := DecodeUDP(LinkTypeRaw, append(frame[14:], 0, 0, 0)); !check || string(udp.Payload) != "data" {
+		t.Fatal(udp, check)
+	}
+	// fragmented packet
+	frag := append([]byte(nil), frame...)
+	frag[14+6] = 0x20
+	if _, check := DecodeUDP(LinkTypeEthernet, frag); check {
+		t.Fatal("fragment decoded")
+	}
+	// IPv6 with hop-by-hop header
+	ip6 := make([]byte, 40+8+8+4)
+	ip6[0], ip6[6] = 0x60, 0
+	ip6[40] = 17
+	binary.BigEndian.PutUint16(ip6[48:], 3000)
+	binary.BigEndian.PutUint16(ip6[50:], 4000)
+	binary.BigEndian.PutUint16(ip6[52:], 12)
+	copy(ip6[56:], "ipv6")
+	if udp, check := DecodeUDP(LinkTypeRaw, ip6); !check || string(udp.Payload) != "ipv6" || udp.DstPort != 4000 {
+		t.Fatal(udp, check)
+	}
+}
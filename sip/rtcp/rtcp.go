@@ -0,0 +1,454 @@
+// Package rtcp provides parsing and serialization of the RTCP packets (RFC 3550):
+// sender and receiver reports, source description and goodbye
+package rtcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Packet types
+const (
+	TypeSenderReport      = 200
+	TypeReceiverReport    = 201
+	TypeSourceDescription = 202
+	TypeGoodbye           = 203
+	TypeApplication       = 204
+)
+
+// SDES item types
+const (
+	SDESEnd   = 0
+	SDESCNAME = 1
+	SDESName  = 2
+	SDESEmail = 3
+	SDESPhone = 4
+	SDESLoc   = 5
+	SDESTool  = 6
+	SDESNote  = 7
+	SDESPriv  = 8
+)
+
+const (
+	headerSize = 4
+	reportSize = 24
+	version    = 2
+)
+
+var (
+	// ErrShortPacket is returned if packet data is less than its length
+	ErrShortPacket = errors.New("RTCP packet is too short")
+	// ErrVersion is returned if packet version is not 2
+	ErrVersion = errors.New("RTCP packet version is not 2")
+)
+
+// Packet is the element of the compound RTCP packet
+type Packet interface {
+	Type() uint8
+	Marshal() ([]byte, error)
+}
+
+// header is the common header of the RTCP packets
+type header struct {
+	padding bool
+	count   uint8
+	typ     uint8
+	// size of the packet in bytes, including header
+	size int
+}
+
+func parseHeader(buf []byte) (h header, err error) {
+	if len(buf) < headerSize {
+		return h, ErrShortPacket
+	}
+	if buf[0]>>6 != version {
+		return h, ErrVersion
+	}
+	h.padding = buf[0]&0x20 != 0
+	h.count = buf[0] & 0x1F
+	h.typ = buf[1]
+	h.size = (int(binary.BigEndian.Uint16(buf[2:])) + 1) * 4
+	if len(buf) < h.size {
+		return h, ErrShortPacket
+	}
+	return
+}
+
+// marshalPacket returns packet with the common header, body must be padded to 32 bits
+func marshalPacket(typ, count uint8, body []byte) ([]byte, error) {
+	if count > 31 {
+		return nil, fmt.Errorf("RTCP packet %v count %v is more than 31", typ, count)
+	}
+	if len(body)%4 != 0 || len(body)/4 > 0xFFFF {
+		return nil, fmt.Errorf("RTCP packet %v length %v is invalid", typ, len(body))
+	}
+	buf := make([]byte, headerSize, headerSize+len(body))
+	buf[0] = version<<6 | count
+	buf[1] = typ
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(body)/4))
+	return append(buf, body...), nil
+}
+
+// Unmarshal parses compound RTCP packet
+func Unmarshal(buf []byte) (res []Packet, err error) {
+	for len(buf) > 0 {
+		var h header
+		if h, err = parseHeader(buf); err != nil {
+			return nil, err
+		}
+		body := buf[headerSize:h.size]
+		if h.padding {
+			if len(body) == 0 || int(body[len(body)-1]) > len(body) {
+				return nil, fmt.Errorf("RTCP packet %v padding is invalid", h.typ)
+			}
+			body = body[:len(body)-int(body[len(body)-1])]
+		}
+		var p Packet
+		switch h.typ {
+		case TypeSenderReport:
+			p, err = parseSenderReport(h, body)
+		case TypeReceiverReport:
+			p, err = parseReceiverReport(h, body)
+		case TypeSourceDescription:
+			p, err = parseSourceDescription(h, body)
+		case TypeGoodbye:
+			p, err = parseGoodbye(h, body)
+		default:
+			p = &RawPacket{PacketType: h.typ, Count: h.count, Payload: body}
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+		buf = buf[h.size:]
+	}
+	if len(res) == 0 {
+		return nil, ErrShortPacket
+	}
+	return
+}
+
+// Marshal returns compound packet source
+func Marshal(packets []Packet) (res []byte, err error) {
+	for _, p := range packets {
+		var buf []byte
+		if buf, err = p.Marshal(); err != nil {
+			return nil, err
+		}
+		res = append(res, buf...)
+	}
+	return
+}
+
+////////////////////////////////////////////////////////////////// reports
+
+// ReceptionReport is the report block of the sender and receiver reports
+type ReceptionReport struct {
+	SSRC uint32
+	// FractionLost is the fraction of the lost packets since the previous report, in 1/256 units
+	FractionLost uint8
+	// TotalLost is the cumulative number of the lost packets (24 bit signed value)
+	TotalLost int32
+	// LastSequence is the extended highest sequence number received
+	LastSequence uint32
+	// Jitter is the interarrival jitter in the timestamp units
+	Jitter uint32
+	// LSR is the middle 32 bits of the NTP timestamp of the last sender report
+	LSR uint32
+	// DLSR is the delay since the last sender report in 1/65536 seconds
+	DLSR uint32
+}
+
+func parseReports(count uint8, buf []byte) (res []ReceptionReport, err error) {
+	if len(buf) < int(count)*reportSize {
+		return nil, ErrShortPacket
+	}
+	for i := 0; i < int(count); i++ {
+		b := buf[i*reportSize:]
+		lost := int32(binary.BigEndian.Uint32(b[4:]) & 0xFFFFFF)
+		if lost&0x800000 != 0 {
+			// negative 24 bit value
+			lost -= 0x1000000
+		}
+		res = append(res, ReceptionReport{
+			SSRC:         binary.BigEndian.Uint32(b),
+			FractionLost: b[4],
+			TotalLost:    lost,
+			LastSequence: binary.BigEndian.Uint32(b[8:]),
+			Jitter:       binary.BigEndian.Uint32(b[12:]),
+			LSR:          binary.BigEndian.Uint32(b[16:]),
+			DLSR:         binary.BigEndian.Uint32(b[20:]),
+		})
+	}
+	return
+}
+
+func marshalReports(buf []byte, reports []ReceptionReport) ([]byte, error) {
+	for _, r := range reports {
+		if r.TotalLost > 0x7FFFFF || r.TotalLost < -0x800000 {
+			return nil, fmt.Errorf("RTCP report total lost %v is out of 24 bit range", r.TotalLost)
+		}
+		b := make([]byte, reportSize)
+		binary.BigEndian.PutUint32(b, r.SSRC)
+		binary.BigEndian.PutUint32(b[4:], uint32(r.TotalLost)&0xFFFFFF)
+		b[4] = r.FractionLost
+		binary.BigEndian.PutUint32(b[8:], r.LastSequence)
+		binary.BigEndian.PutUint32(b[12:], r.Jitter)
+		binary.BigEndian.PutUint32(b[16:], r.LSR)
+		binary.BigEndian.PutUint32(b[20:], r.DLSR)
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+// SenderReport is the SR packet
+type SenderReport struct {
+	SSRC uint32
+	// NTPTime is the 64 bit NTP timestamp
+	NTPTime     uint64
+	RTPTime     uint32
+	PacketCount uint32
+	OctetCount  uint32
+	Reports     []ReceptionReport
+	// Extension is the profile-specific extension data
+	Extension []byte
+}
+
+// Type returns packet type
+func (s *SenderReport) Type() uint8 { return TypeSenderReport }
+
+func parseSenderReport(h header, body []byte) (Packet, error) {
+	if len(body) < 24 {
+		return nil, ErrShortPacket
+	}
+	res := &SenderReport{
+		SSRC:        binary.BigEndian.Uint32(body),
+		NTPTime:     binary.BigEndian.Uint64(body[4:]),
+		RTPTime:     binary.BigEndian.Uint32(body[12:]),
+		PacketCount: binary.BigEndian.Uint32(body[16:]),
+		OctetCount:  binary.BigEndian.Uint32(body[20:]),
+	}
+	var err error
+	if res.Reports, err = parseReports(h.count, body[24:]); err != nil {
+		return nil, err
+	}
+	if ext := body[24+int(h.count)*reportSize:]; len(ext) > 0 {
+		res.Extension = ext
+	}
+	return res, nil
+}
+
+// Marshal returns packet source
+func (s *SenderReport) Marshal() ([]byte, error) {
+	body := make([]byte, 24)
+	binary.BigEndian.PutUint32(body, s.SSRC)
+	binary.BigEndian.PutUint64(body[4:], s.NTPTime)
+	binary.BigEndian.PutUint32(body[12:], s.RTPTime)
+	binary.BigEndian.PutUint32(body[16:], s.PacketCount)
+	binary.BigEndian.PutUint32(body[20:], s.OctetCount)
+	body, err := marshalReports(body, s.Reports)
+	if err != nil {
+		return nil, err
+	}
+	return marshalPacket(TypeSenderReport, uint8(len(s.Reports)), append(body, s.Extension...))
+}
+
+// ReceiverReport is the RR packet
+type ReceiverReport struct {
+	SSRC      uint32
+	Reports   []ReceptionReport
+	Extension []byte
+}
+
+// Type returns packet type
+func (s *ReceiverReport) Type() uint8 { return TypeReceiverReport }
+
+func parseReceiverReport(h header, body []byte) (Packet, error) {
+	if len(body) < 4 {
+		return nil, ErrShortPacket
+	}
+	res := &ReceiverReport{SSRC: binary.BigEndian.Uint32(body)}
+	var err error
+	if res.Reports, err = parseReports(h.count, body[4:]); err != nil {
+		return nil, err
+	}
+	if ext := body[4+int(h.count)*reportSize:]; len(ext) > 0 {
+		res.Extension = ext
+	}
+	return res, nil
+}
+
+// Marshal returns packet source
+func (s *ReceiverReport) Marshal() ([]byte, error) {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, s.SSRC)
+	body, err := marshalReports(body, s.Reports)
+	if err != nil {
+		return nil, err
+	}
+	return marshalPacket(TypeReceiverReport, uint8(len(s.Reports)), append(body, s.Extension...))
+}
+
+////////////////////////////////////////////////////////////////// source description
+
+// SDESItem is the item of the source description chunk
+type SDESItem struct {
+	Type uint8
+	Text string
+}
+
+// SDESChunk is the list of the items of the source
+type SDESChunk struct {
+	Source uint32
+	Items  []SDESItem
+}
+
+// SourceDescription is the SDES packet
+type SourceDescription struct {
+	Chunks []SDESChunk
+}
+
+// Type returns packet type
+func (s *SourceDescription) Type() uint8 { return TypeSourceDescription }
+
+// CNAME returns canonical name of the source
+func (s *SourceDescription) CNAME(source uint32) (string, bool) {
+	for _, chunk := range s.Chunks {
+		if chunk.Source != source {
+			continue
+		}
+		for _, item := range chunk.Items {
+			if item.Type == SDESCNAME {
+				return item.Text, true
+			}
+		}
+	}
+	return "", false
+}
+
+func parseSourceDescription(h header, body []byte) (Packet, error) {
+	res := new(SourceDescription)
+	pos := 0
+	for i := 0; i < int(h.count); i++ {
+		if pos+4 > len(body) {
+			return nil, ErrShortPacket
+		}
+		chunk := SDESChunk{Source: binary.BigEndian.Uint32(body[pos:])}
+		pos += 4
+		for {
+			if pos >= len(body) {
+				return nil, ErrShortPacket
+			}
+			if body[pos] == SDESEnd {
+				// end of the items, chunk is padded to 32 bits
+				pos += 4 - pos%4
+				break
+			}
+			if pos+2 > len(body) {
+				return nil, ErrShortPacket
+			}
+			typ, length := body[pos], int(body[pos+1])
+			pos += 2
+			if pos+length > len(body) {
+				return nil, ErrShortPacket
+			}
+			chunk.Items = append(chunk.Items, SDESItem{Type: typ, Text: string(body[pos : pos+length])})
+			pos += length
+		}
+		res.Chunks = append(res.Chunks, chunk)
+	}
+	return res, nil
+}
+
+// Marshal returns packet source
+func (s *SourceDescription) Marshal() ([]byte, error) {
+	var body []byte
+	for _, chunk := range s.Chunks {
+		start := len(body)
+		body = append(body, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(body[start:], chunk.Source)
+		for _, item := range chunk.Items {
+			if item.Type == SDESEnd || len(item.Text) > 255 {
+				return nil, fmt.Errorf("RTCP SDES item %v is invalid", item.Type)
+			}
+			body = append(body, item.Type, byte(len(item.Text)))
+			body = append(body, item.Text...)
+		}
+		// null terminator and padding to 32 bits
+		body = append(body, SDESEnd)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	return marshalPacket(TypeSourceDescription, uint8(len(s.Chunks)), body)
+}
+
+////////////////////////////////////////////////////////////////// goodbye
+
+// Goodbye is the BYE packet
+type Goodbye struct {
+	Sources []uint32
+	Reason  string
+}
+
+// Type returns packet type
+func (s *Goodbye) Type() uint8 { return TypeGoodbye }
+
+func parseGoodbye(h header, body []byte) (Packet, error) {
+	if len(body) < int(h.count)*4 {
+		return nil, ErrShortPacket
+	}
+	res := new(Goodbye)
+	for i := 0; i < int(h.count); i++ {
+		res.Sources = append(res.Sources, binary.BigEndian.Uint32(body[i*4:]))
+	}
+	if rest := body[int(h.count)*4:]; len(rest) > 0 {
+		length := int(rest[0])
+		if length+1 > len(rest) {
+			return nil, ErrShortPacket
+		}
+		res.Reason = string(rest[1 : length+1])
+	}
+	return res, nil
+}
+
+// Marshal returns packet source
+func (s *Goodbye) Marshal() ([]byte, error) {
+	if len(s.Reason) > 255 {
+		return nil, fmt.Errorf("RTCP BYE reason is too long")
+	}
+	body := make([]byte, len(s.Sources)*4)
+	for i, source := range s.Sources {
+		binary.BigEndian.PutUint32(body[i*4:], source)
+	}
+	if s.Reason != "" {
+		body = append(body, byte(len(s.Reason)))
+		body = append(body, s.Reason...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	return marshalPacket(TypeGoodbye, uint8(len(s.Sources)), body)
+}
+
+////////////////////////////////////////////////////////////////// raw
+
+// RawPacket is the packet of unsupported type
+type RawPacket struct {
+	PacketType uint8
+	Count      uint8
+	Payload    []byte
+}
+
+// Type returns packet type
+func (s *RawPacket) Type() uint8 { return s.PacketType }
+
+// Marshal returns packet source, payload is padded to 32 bits
+func (s *RawPacket) Marshal() ([]byte, error) {
+	body := append([]byte(nil), s.Payload...)
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	return marshalPacket(s.PacketType, s.Count, body)
+}
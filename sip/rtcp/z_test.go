@@ -0,0 +1,86 @@
+package rtcp
+
+import (
+	"testing"
+)
+
+func TestCompound(t *testing.T) {
+	src := []Packet{
+		&SenderReport{
+			SSRC:        0x1234,
+			NTPTime:     0xE3A1B2C3D4E5F607,
+			RTPTime:     160000,
+			PacketCount: 100,
+			OctetCount:  16000,
+			Reports: []ReceptionReport{
+				{SSRC: 0x5678, FractionLost: 12, TotalLost: -3, LastSequence: 70000, Jitter: 40, LSR: 1, DLSR: 65536},
+			},
+		},
+		&SourceDescription{Chunks: []SDESChunk{
+			{Source: 0x1234, Items: []SDESItem{{SDESCNAME, "user@host"}, {SDESTool, "go"}}},
+			{Source: 0x5678, Items: []SDESItem{{SDESCNAME, "abc"}}},
+		}},
+		&Goodbye{Sources: []uint32{0x1234}, Reason: "end"},
+	}
+	buf, err := Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf)%4 != 0 {
+		t.Fatal(len(buf))
+	}
+	res, err := Unmarshal(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatal(res)
+	}
+	sr, check := res[0].(*SenderReport)
+	if !check || sr.NTPTime != 0xE3A1B2C3D4E5F607 || sr.PacketCount != 100 || len(sr.Reports) != 1 {
+		t.Fatal(res[0])
+	}
+	if r := sr.Reports[0]; r != src[0].(*SenderReport).Reports[0] {
+		t.Fatal(r)
+	}
+	sdes := res[1].(*SourceDescription)
+	if cname, check := sdes.CNAME(0x5678); !check || cname != "abc" || len(sdes.Chunks[0].Items) != 2 {
+		t.Fatal(sdes)
+	}
+	if bye := res[2].(*Goodbye); len(bye.Sources) != 1 || bye.Reason != "end" {
+		t.Fatal(bye)
+	}
+	if _, err = Unmarshal(buf[:len(buf)-2]); err != ErrShortPacket {
+		t.Fatal(err)
+	}
+}
+
+func TestReceiverReport(t *testing.T) {
+	rr := &ReceiverReport{SSRC: 1, Reports: []ReceptionReport{{SSRC: 2, TotalLost: 0x7FFFFF}, {SSRC: 3}}}
+	buf, err := rr.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Unmarshal(append(buf, mustMarshal(t, &RawPacket{PacketType: TypeApplication, Count: 1, Payload: []byte("name")})...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := res[0].(*ReceiverReport); len(r.Reports) != 2 || r.Reports[0].TotalLost != 0x7FFFFF {
+		t.Fatal(r)
+	}
+	if raw := res[1].(*RawPacket); raw.Type() != TypeApplication || string(raw.Payload) != "name" {
+		t.Fatal(raw)
+	}
+	rr.Reports[0].TotalLost = 0x800000
+	if _, err = rr.Marshal(); err == nil {
+		t.Fatal("expected range error")
+	}
+}
+
+func mustMarshal(t *testing.T, p Packet) []byte {
+	buf, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
@@ -0,0 +1,34 @@
+package rtp
+
+import (
+	"io"
+
+	"github.com/fcg-xvii/go-tools/sip/pcap"
+)
+
+// AnalyzePcap reads UDP datagrams of the pcap capture and returns statistics of the found RTP streams.
+// If clockRate is 0, it is selected by the payload type of the streams
+func AnalyzePcap(r io.Reader, clockRate int) ([]Stats, error) {
+	reader, err := pcap.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	analyzer := NewAnalyzer(clockRate)
+	for {
+		ts, udp, err := reader.NextUDP()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !IsRTP(udp.Payload) {
+			continue
+		}
+		var p Packet
+		if p.Unmarshal(udp.Payload) == nil {
+			analyzer.Add(&p, ts)
+		}
+	}
+	return analyzer.Stats(), nil
+}
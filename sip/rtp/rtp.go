@@ -0,0 +1,317 @@
+// Package rtp provides parsing and serialization of the RTP packets (RFC 3550) with
+// header extensions (RFC 8285) and the quality analyzer of the RTP streams
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	headerSize = 12
+	version    = 2
+
+	// ExtensionProfileOneByte is the profile of the one-byte header extensions (RFC 8285)
+	ExtensionProfileOneByte = 0xBEDE
+	// ExtensionProfileTwoByte is the profile of the two-byte header extensions (RFC 8285)
+	ExtensionProfileTwoByte = 0x1000
+)
+
+var (
+	// ErrShortPacket is returned if packet data is less than header size
+	ErrShortPacket = errors.New("RTP packet is too short")
+	// ErrVersion is returned if packet version is not 2
+	ErrVersion = errors.New("RTP packet version is not 2")
+)
+
+// Extension is the element of the RFC 8285 header extension
+type Extension struct {
+	ID      uint8
+	Payload []byte
+}
+
+// Header of the RTP packet
+type Header struct {
+	Padding        bool
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRC           []uint32
+	// Extension is true if header contains extension
+	Extension        bool
+	ExtensionProfile uint16
+	// Extensions are parsed elements of the one-byte and two-byte profiles
+	Extensions []Extension
+	// ExtensionPayload is the raw extension data of other profiles
+	ExtensionPayload []byte
+}
+
+// Unmarshal parses header and returns its size
+func (s *Header) Unmarshal(buf []byte) (size int, err error) {
+	if len(buf) < headerSize {
+		return 0, ErrShortPacket
+	}
+	if buf[0]>>6 != version {
+		return 0, ErrVersion
+	}
+	s.Padding = buf[0]&0x20 != 0
+	s.Extension = buf[0]&0x10 != 0
+	csrcCount := int(buf[0] & 0x0F)
+	s.Marker = buf[1]&0x80 != 0
+	s.PayloadType = buf[1] & 0x7F
+	s.SequenceNumber = binary.BigEndian.Uint16(buf[2:])
+	s.Timestamp = binary.BigEndian.Uint32(buf[4:])
+	s.SSRC = binary.BigEndian.Uint32(buf[8:])
+
+	size = headerSize + csrcCount*4
+	if len(buf) < size {
+		return 0, ErrShortPacket
+	}
+	s.CSRC = nil
+	for i := 0; i < csrcCount; i++ {
+		s.CSRC = append(s.CSRC, binary.BigEndian.Uint32(buf[headerSize+i*4:]))
+	}
+
+	s.ExtensionProfile, s.Extensions, s.ExtensionPayload = 0, nil, nil
+	if !s.Extension {
+		return
+	}
+	if len(buf) < size+4 {
+		return 0, ErrShortPacket
+	}
+	s.ExtensionProfile = binary.BigEndian.Uint16(buf[size:])
+	extSize := int(binary.BigEndian.Uint16(buf[size+2:])) * 4
+	size += 4
+	if len(buf) < size+extSize {
+		return 0, ErrShortPacket
+	}
+	data := buf[size : size+extSize]
+	size += extSize
+	switch {
+	case s.ExtensionProfile == ExtensionProfileOneByte:
+		err = s.parseOneByte(data)
+	case s.ExtensionProfile&0xFFF0 == ExtensionProfileTwoByte:
+		err = s.parseTwoByte(data)
+	default:
+		s.ExtensionPayload = data
+	}
+	return
+}
+
+func (s *Header) parseOneByte(data []byte) error {
+	for i := 0; i < len(data); {
+		if data[i] == 0 {
+			// padding
+			i++
+			continue
+		}
+		id, length := data[i]>>4, int(data[i]&0x0F)+1
+		if id == 15 {
+			// reserved id, the rest of the extension must be ignored
+			return nil
+		}
+		i++
+		if i+length > len(data) {
+			return fmt.Errorf("RTP extension %v is too short", id)
+		}
+		s.Extensions = append(s.Extensions, Extension{ID: id, Payload: data[i : i+length]})
+		i += length
+	}
+	return nil
+}
+
+func (s *Header) parseTwoByte(data []byte) error {
+	for i := 0; i < len(data); {
+		if data[i] == 0 {
+			i++
+			continue
+		}
+		if i+2 > len(data) {
+			return fmt.Errorf("RTP extension is too short")
+		}
+		id, length := data[i], int(data[i+1])
+		i += 2
+		if i+length > len(data) {
+			return fmt.Errorf("RTP extension %v is too short", id)
+		}
+		s.Extensions = append(s.Extensions, Extension{ID: id, Payload: data[i : i+length]})
+		i += length
+	}
+	return nil
+}
+
+// GetExtension returns payload of the extension with id
+func (s *Header) GetExtension(id uint8) (payload []byte, check bool) {
+	for _, ext := range s.Extensions {
+		if ext.ID == id {
+			return ext.Payload, true
+		}
+	}
+	return
+}
+
+// SetExtension sets payload of the extension with id. Profile is selected automatically:
+// one-byte if all extensions fit it, two-byte otherwise
+func (s *Header) SetExtension(id uint8, payload []byte) error {
+	if id == 0 {
+		return fmt.Errorf("RTP extension id 0 is reserved")
+	}
+	s.Extension, s.ExtensionPayload = true, nil
+	replaced := false
+	for i, ext := range s.Extensions {
+		if ext.ID == id {
+			s.Extensions[i].Payload, replaced = payload, true
+		}
+	}
+	if !replaced {
+		s.Extensions = append(s.Extensions, Extension{ID: id, Payload: payload})
+	}
+	s.ExtensionProfile = ExtensionProfileOneByte
+	for _, ext := range s.Extensions {
+		if ext.ID > 14 || len(ext.Payload) == 0 || len(ext.Payload) > 16 {
+			s.ExtensionProfile = ExtensionProfileTwoByte
+		}
+	}
+	return nil
+}
+
+// extensionData returns extension data padded to 32 bits
+func (s *Header) extensionData() (data []byte, err error) {
+	switch {
+	case s.ExtensionProfile == ExtensionProfileOneByte && len(s.Extensions) > 0:
+		for _, ext := range s.Extensions {
+			if ext.ID == 0 || ext.ID > 14 || len(ext.Payload) == 0 || len(ext.Payload) > 16 {
+				return nil, fmt.Errorf("RTP extension %v doesn't fit one-byte profile", ext.ID)
+			}
+			data = append(data, ext.ID<<4|byte(len(ext.Payload)-1))
+			data = append(data, ext.Payload...)
+		}
+	case s.ExtensionProfile&0xFFF0 == ExtensionProfileTwoByte && len(s.Extensions) > 0:
+		for _, ext := range s.Extensions {
+			if ext.ID == 0 || len(ext.Payload) > 255 {
+				return nil, fmt.Errorf("RTP extension %v doesn't fit two-byte profile", ext.ID)
+			}
+			data = append(data, ext.ID, byte(len(ext.Payload)))
+			data = append(data, ext.Payload...)
+		}
+	default:
+		data = append(data, s.ExtensionPayload...)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	return
+}
+
+// Marshal returns header source
+func (s *Header) Marshal() (buf []byte, err error) {
+	if len(s.CSRC) > 15 {
+		return nil, fmt.Errorf("RTP header CSRC count %v is more than 15", len(s.CSRC))
+	}
+	if s.PayloadType > 127 {
+		return nil, fmt.Errorf("RTP payload type %v is more than 127", s.PayloadType)
+	}
+	buf = make([]byte, headerSize, headerSize+len(s.CSRC)*4)
+	buf[0] = version<<6 | byte(len(s.CSRC))
+	if s.Padding {
+		buf[0] |= 0x20
+	}
+	if s.Extension {
+		buf[0] |= 0x10
+	}
+	buf[1] = s.PayloadType
+	if s.Marker {
+		buf[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(buf[2:], s.SequenceNumber)
+	binary.BigEndian.PutUint32(buf[4:], s.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:], s.SSRC)
+	for _, csrc := range s.CSRC {
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[len(buf)-4:], csrc)
+	}
+	if s.Extension {
+		var data []byte
+		if data, err = s.extensionData(); err != nil {
+			return nil, err
+		}
+		if len(data)/4 > 0xFFFF {
+			return nil, fmt.Errorf("RTP header extension is too long")
+		}
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-4:], s.ExtensionProfile)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(data)/4))
+		buf = append(buf, data...)
+	}
+	return
+}
+
+// Packet is the RTP packet
+type Packet struct {
+	Header
+	Payload []byte
+	// PaddingSize is the count of the padding bytes (including the last count byte)
+	PaddingSize uint8
+}
+
+// Unmarshal parses RTP packet. Payload refers to the buf data
+func (s *Packet) Unmarshal(buf []byte) error {
+	size, err := s.Header.Unmarshal(buf)
+	if err != nil {
+		return err
+	}
+	end := len(buf)
+	s.PaddingSize = 0
+	if s.Padding {
+		if end <= size {
+			return ErrShortPacket
+		}
+		s.PaddingSize = buf[end-1]
+		if s.PaddingSize == 0 || int(s.PaddingSize) > end-size {
+			return fmt.Errorf("RTP padding size %v is invalid", s.PaddingSize)
+		}
+		end -= int(s.PaddingSize)
+	}
+	s.Payload = buf[size:end]
+	return nil
+}
+
+// Marshal returns packet source
+func (s *Packet) Marshal() ([]byte, error) {
+	s.Padding = s.PaddingSize > 0
+	buf, err := s.Header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, s.Payload...)
+	if s.PaddingSize > 0 {
+		for i := 0; i < int(s.PaddingSize)-1; i++ {
+			buf = append(buf, 0)
+		}
+		buf = append(buf, s.PaddingSize)
+	}
+	return buf, nil
+}
+
+// Parse returns parsed RTP packet
+func Parse(buf []byte) (p *Packet, err error) {
+	p = new(Packet)
+	if err = p.Unmarshal(buf); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// IsRTP checks data is like the RTP packet: version 2, size of the header and payload type
+// out of the RTCP range. Used to find RTP packets in the captured UDP traffic
+func IsRTP(buf []byte) bool {
+	if len(buf) < headerSize || buf[0]>>6 != version {
+		return false
+	}
+	// RTCP packet types 192-223 look like payload types 64-95 with marker bit
+	pt := buf[1] & 0x7F
+	return pt < 64 || pt > 95
+}
@@ -0,0 +1,282 @@
+package rtp
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	maxDropout  = 3000
+	maxMisorder = 100
+	seqMod      = 1 << 16
+)
+
+// clockRates of the static payload types (RFC 3551)
+var clockRates = map[uint8]int{
+	0: 8000, 3: 8000, 4: 8000, 5: 8000, 6: 16000, 7: 8000, 8: 8000, 9: 8000,
+	10: 44100, 11: 44100, 12: 8000, 13: 8000, 14: 90000, 15: 8000, 16: 11025,
+	17: 22050, 18: 8000, 25: 90000, 26: 90000, 28: 90000, 31: 90000, 32: 90000,
+	33: 90000, 34: 90000,
+}
+
+// ClockRate returns clock rate of the static payload type. Dynamic types return 8000
+func ClockRate(payloadType uint8) int {
+	if rate, check := clockRates[payloadType]; check {
+		return rate
+	}
+	return 8000
+}
+
+// Gap is the sequence of the lost packets
+type Gap struct {
+	// First and Last are sequence numbers of the lost packets
+	First, Last uint16
+	Count       int
+	// Time is the arrival time of the packet after gap
+	Time time.Time
+}
+
+// Stats is the quality statistics of the RTP stream
+type Stats struct {
+	SSRC        uint32
+	PayloadType uint8
+	ClockRate   int
+	// Received is the count of the received packets, including duplicates
+	Received int
+	// Expected is the count calculated by the sequence numbers
+	Expected    int
+	Lost        int
+	LossPercent float64
+	Duplicates  int
+	Reordered   int
+	Gaps        []Gap
+	// Jitter is the RFC 3550 interarrival jitter
+	Jitter    time.Duration
+	MaxJitter time.Duration
+	// First and Last are arrival times of the first and the last packets
+	First, Last time.Time
+	// MOS is the mean opinion score (1 - 4.5) estimated by the E-model from loss and jitter
+	MOS float64
+}
+
+// Duration returns duration of the stream
+func (s Stats) Duration() time.Duration { return s.Last.Sub(s.First) }
+
+// Stream calculates statistics of the single RTP stream (RFC 3550 appendix A)
+type Stream struct {
+	ssrc        uint32
+	payloadType uint8
+	clockRate   int
+	started     bool
+	baseSeq     uint32
+	maxSeq      uint16
+	cycles      uint32
+	badSeq      uint32
+	received    int
+	duplicates  int
+	reordered   int
+	seen        map[uint32]bool // extended sequence numbers of the last packets, used to find duplicates
+	gaps        []Gap
+	// arrival (in the timestamp units) and timestamp of the previous packet for the jitter
+	arrivalTS   float64
+	timestamp   uint32
+	jitter      float64
+	maxJitter   float64
+	first, last time.Time
+}
+
+// NewStream creates stream analyzer. If clockRate is 0, it is selected by the payload type of the first packet
+func NewStream(clockRate int) *Stream {
+	return &Stream{clockRate: clockRate, badSeq: seqMod + 1, seen: make(map[uint32]bool)}
+}
+
+func (s *Stream) init(p *Packet) {
+	s.started = true
+	s.ssrc, s.payloadType = p.SSRC, p.PayloadType
+	if s.clockRate == 0 {
+		s.clockRate = ClockRate(p.PayloadType)
+	}
+	s.baseSeq, s.maxSeq, s.cycles = uint32(p.SequenceNumber), p.SequenceNumber, 0
+}
+
+// extended returns extended sequence number of the current cycle
+func (s *Stream) extended(seq uint16) uint32 {
+	return s.cycles + uint32(seq)
+}
+
+// Add accepts packet received at the arrival time
+func (s *Stream) Add(p *Packet, arrival time.Time) {
+	if !s.started {
+		s.init(p)
+		s.first = arrival
+		s.accept(p, arrival, s.extended(p.SequenceNumber))
+		return
+	}
+	seq := p.SequenceNumber
+	delta := seq - s.maxSeq
+	switch {
+	case delta == 0:
+		s.duplicates++
+		s.received++
+		return
+	case delta < maxDropout:
+		// in order, with permissible gap
+		if seq < s.maxSeq {
+			s.cycles += seqMod
+		}
+		if delta > 1 {
+			s.gaps = append(s.gaps, Gap{First: s.maxSeq + 1, Last: seq - 1, Count: int(delta - 1), Time: arrival})
+		}
+		s.maxSeq = seq
+	case delta <= seqMod-maxMisorder:
+		// very large jump, the stream is restarted if the next packet continues the sequence
+		if uint32(seq) == s.badSeq {
+			// two sequential packets after jump, assume the source restarted
+			s.init(p)
+			s.received, s.duplicates, s.reordered = 0, 0, 0
+			s.gaps, s.seen = nil, make(map[uint32]bool)
+		} else {
+			// the packet is not accepted during probation (RFC 3550 A.1)
+			s.badSeq = (uint32(seq) + 1) & (seqMod - 1)
+			return
+		}
+	default:
+		// duplicate or reordered packet
+		ext := s.extended(seq)
+		if seq > s.maxSeq {
+			ext -= seqMod
+		}
+		if s.seen[ext] {
+			s.duplicates++
+			s.received++
+			return
+		}
+		s.reordered++
+		s.removeFromGap(seq)
+		s.accept(p, arrival, ext)
+		return
+	}
+	s.accept(p, arrival, s.extended(seq))
+}
+
+// removeFromGap decreases the gap of the late packet
+func (s *Stream) removeFromGap(seq uint16) {
+	for i := len(s.gaps) - 1; i >= 0; i-- {
+		g := &s.gaps[i]
+		if uint16(seq-g.First) <= uint16(g.Last-g.First) {
+			if g.Count--; g.Count == 0 {
+				s.gaps = append(s.gaps[:i], s.gaps[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+func (s *Stream) accept(p *Packet, arrival time.Time, ext uint32) {
+	s.received++
+	s.seen[ext] = true
+	// remove old values, duplicates are searched only in the misorder window
+	if len(s.seen) > maxMisorder*2 {
+		for key := range s.seen {
+			if s.cycles+uint32(s.maxSeq)-key > maxMisorder {
+				delete(s.seen, key)
+			}
+		}
+	}
+	// interarrival jitter in the timestamp units (RFC 3550 A.8), the timestamp difference is signed
+	// to pass the wrap around of the 32 bit timestamp
+	arrivalTS := arrival.Sub(s.first).Seconds() * float64(s.clockRate)
+	if s.received > 1 {
+		d := math.Abs(arrivalTS - s.arrivalTS - float64(int32(p.Timestamp-s.timestamp)))
+		s.jitter += (d - s.jitter) / 16
+		if s.jitter > s.maxJitter {
+			s.maxJitter = s.jitter
+		}
+	}
+	s.arrivalTS, s.timestamp = arrivalTS, p.Timestamp
+	if arrival.After(s.last) {
+		s.last = arrival
+	}
+}
+
+// Stats returns current statistics of the stream
+func (s *Stream) Stats() (res Stats) {
+	res = Stats{
+		SSRC:        s.ssrc,
+		PayloadType: s.payloadType,
+		ClockRate:   s.clockRate,
+		Received:    s.received,
+		Duplicates:  s.duplicates,
+		Reordered:   s.reordered,
+		Gaps:        append([]Gap(nil), s.gaps...),
+		First:       s.first,
+		Last:        s.last,
+	}
+	if !s.started {
+		return
+	}
+	res.Expected = int(s.extended(s.maxSeq) - s.baseSeq + 1)
+	if res.Lost = res.Expected - (s.received - s.duplicates); res.Lost < 0 {
+		res.Lost = 0
+	}
+	res.LossPercent = float64(res.Lost) * 100 / float64(res.Expected)
+	toDuration := func(v float64) time.Duration {
+		return time.Duration(v / float64(s.clockRate) * float64(time.Second))
+	}
+	res.Jitter, res.MaxJitter = toDuration(s.jitter), toDuration(s.maxJitter)
+	res.MOS = EstimateMOS(0, res.Jitter, res.LossPercent)
+	return
+}
+
+// EstimateMOS returns MOS estimated by the simplified E-model (ITU-T G.107)
+// from the one-way latency, jitter and packet loss percent
+func EstimateMOS(latency, jitter time.Duration, lossPercent float64) float64 {
+	effective := float64(latency+jitter*2)/float64(time.Millisecond) + 10
+	var r float64
+	if effective < 160 {
+		r = 93.2 - effective/40
+	} else {
+		r = 93.2 - (effective-120)/10
+	}
+	r -= lossPercent * 2.5
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+}
+
+// Analyzer splits packets to streams by SSRC
+type Analyzer struct {
+	// ClockRate of the streams, if 0 it is selected by the payload type
+	ClockRate int
+	streams   map[uint32]*Stream
+}
+
+// NewAnalyzer creates analyzer with clock rate of the streams. If clockRate is 0,
+// it is selected by the payload type
+func NewAnalyzer(clockRate int) *Analyzer {
+	return &Analyzer{ClockRate: clockRate, streams: make(map[uint32]*Stream)}
+}
+
+// Add accepts packet received at the arrival time
+func (s *Analyzer) Add(p *Packet, arrival time.Time) {
+	stream, check := s.streams[p.SSRC]
+	if !check {
+		stream = NewStream(s.ClockRate)
+		s.streams[p.SSRC] = stream
+	}
+	stream.Add(p, arrival)
+}
+
+// Stats returns statistics of all streams ordered by the first packet time
+func (s *Analyzer) Stats() (res []Stats) {
+	for _, stream := range s.streams {
+		res = append(res, stream.Stats())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].First.Before(res[j].First) })
+	return
+}
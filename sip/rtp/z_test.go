@@ -0,0 +1,155 @@
+package rtp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fcg-xvii/go-tools/sip/pcap"
+)
+
+func TestPacket(t *testing.T) {
+	p := Packet{
+		Header: Header{
+			Marker:         true,
+			PayloadType:    8,
+			SequenceNumber: 1000,
+			Timestamp:      160000,
+			SSRC:           0x11223344,
+			CSRC:           []uint32{1, 2},
+		},
+		Payload:     []byte{1, 2, 3, 4, 5},
+		PaddingSize: 3,
+	}
+	if err := p.SetExtension(1, []byte{0xAA}); err != nil {
+		t.Fatal(err)
+	}
+	p.SetExtension(3, []byte{1, 2, 3})
+	buf, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SSRC != p.SSRC || res.SequenceNumber != 1000 || !res.Marker || res.PayloadType != 8 || len(res.CSRC) != 2 {
+		t.Fatal(res.Header)
+	}
+	if res.ExtensionProfile != ExtensionProfileOneByte || !bytes.Equal(res.Payload, p.Payload) || res.PaddingSize != 3 {
+		t.Fatal(res)
+	}
+	if ext, check := res.GetExtension(3); !check || !bytes.Equal(ext, []byte{1, 2, 3}) {
+		t.Fatal(ext, check)
+	}
+
+	// long extension switches profile to two-byte
+	p.SetExtension(20, make([]byte, 20))
+	buf, _ = p.Marshal()
+	if res, err = Parse(buf); err != nil || res.ExtensionProfile != ExtensionProfileTwoByte || len(res.Extensions) != 3 {
+		t.Fatal(err, res)
+	}
+	if _, err = Parse(buf[:8]); err != ErrShortPacket {
+		t.Fatal(err)
+	}
+	if !IsRTP(buf) || IsRTP([]byte{0x80, 200, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Fatal("IsRTP")
+	}
+}
+
+func testPacket(seq uint16, ts uint32) *Packet {
+	return &Packet{Header: Header{PayloadType: 0, SequenceNumber: seq, Timestamp: ts, SSRC: 1}, Payload: make([]byte, 160)}
+}
+
+func TestStream(t *testing.T) {
+	start := time.Now()
+	s := NewStream(0)
+	// 100 packets of 20ms, packets 10-12 are lost, 20 is duplicated, 30 and 31 are swapped
+	for i := 0; i < 100; i++ {
+		seq := uint16(65500 + i)
+		switch i {
+		case 10, 11, 12:
+			continue
+		case 30:
+			seq++
+		case 31:
+			seq--
+		}
+		idx := int(uint16(seq - 65500))
+		arrival := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		if i%2 == 1 {
+			arrival = arrival.Add(4 * time.Millisecond)
+		}
+		s.Add(testPacket(seq, uint32(idx*160)), arrival)
+		if i == 20 {
+			s.Add(testPacket(seq, uint32(idx*160)), arrival)
+		}
+	}
+	stats := s.Stats()
+	if stats.ClockRate != 8000 || stats.Expected != 100 || stats.Lost != 3 || stats.Duplicates != 1 || stats.Reordered != 1 {
+		t.Fatal(stats)
+	}
+	if len(stats.Gaps) != 1 || stats.Gaps[0].Count != 3 || stats.Gaps[0].First != uint16(65510) {
+		t.Fatal(stats.Gaps)
+	}
+	if stats.Jitter < time.Millisecond || stats.Jitter > 5*time.Millisecond {
+		t.Fatal(stats.Jitter)
+	}
+	if stats.MOS < 3.5 || stats.MOS > 4.5 {
+		t.Fatal(stats.MOS)
+	}
+	// timestamp wraps around 2^32 without the jitter spike
+	s = NewStream(0)
+	for i := 0; i < 50; i++ {
+		s.Add(testPacket(uint16(i), uint32(1<<32-160*25+160*i)), start.Add(time.Duration(i)*20*time.Millisecond))
+	}
+	if stats = s.Stats(); stats.Jitter > time.Millisecond || stats.MaxJitter > time.Millisecond {
+		t.Fatal(stats.Jitter, stats.MaxJitter)
+	}
+	// single packets of the large jump are not counted, so they don't hide the loss
+	s = NewStream(0)
+	for i := 0; i < 40; i++ {
+		if i >= 10 && i < 13 {
+			continue
+		}
+		s.Add(testPacket(uint16(i), uint32(160*i)), start.Add(time.Duration(i)*20*time.Millisecond))
+		if i%10 == 5 {
+			s.Add(testPacket(uint16(i*1000+10000), 0), start.Add(time.Duration(i)*20*time.Millisecond))
+		}
+	}
+	if stats = s.Stats(); stats.Expected != 40 || stats.Lost != 3 {
+		t.Fatal(stats)
+	}
+	if EstimateMOS(0, 0, 0) < 4.3 || EstimateMOS(300*time.Millisecond, 50*time.Millisecond, 20) > 2.5 {
+		t.Fatal("MOS")
+	}
+}
+
+func TestAnalyzePcap(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := pcap.NewWriter(&buf, pcap.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 10000}
+	dst := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 20000}
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		if i == 25 {
+			continue
+		}
+		p := testPacket(uint16(i), uint32(i*160))
+		data, _ := p.Marshal()
+		w.WritePacket(start.Add(time.Duration(i)*20*time.Millisecond), pcap.EthernetUDP(src, dst, data))
+		// not RTP datagram
+		w.WritePacket(start, pcap.EthernetUDP(src, dst, []byte("OPTIONS sip:test SIP/2.0")))
+	}
+	stats, err := AnalyzePcap(&buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Lost != 1 || stats[0].Received != 49 || stats[0].Jitter > time.Millisecond {
+		t.Fatal(stats)
+	}
+}
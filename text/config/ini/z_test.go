@@ -1,6 +1,7 @@
 package ini
 
 import (
+	"io/ioutil"
 	"os"
 	"testing"
 
@@ -40,10 +41,11 @@ func TestINI(t *testing.T) {
 	t.Log(cools, check)
 
 	var f *os.File
-	f, err = os.OpenFile("tmp.ini", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	f, err = ioutil.TempFile("", "tmp*.ini")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.Remove(f.Name())
 	conf.Save(f)
 	f.Close()
 }